@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ego008/youdb"
 	"github.com/missdeer/kani/model"
@@ -41,7 +42,6 @@ func (h *BaseHandler) ArticleEdit(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`{"retcode":403,"retmsg":"aid not found"}`))
 		return
 	}
-	aidB := youdb.I2b(aobj.ID)
 
 	cobj, err := model.CategoryGetByID(db, strconv.FormatUint(aobj.CID, 10))
 	if err != nil {
@@ -52,26 +52,8 @@ func (h *BaseHandler) ArticleEdit(w http.ResponseWriter, r *http.Request) {
 	act := r.FormValue("act")
 
 	if act == "del" {
-		// remove
-		// 总文章列表
-		db.Zdel("article_timeline", aidB)
-		// 分类文章列表
-		db.Zdel("category_article_timeline:"+strconv.FormatUint(aobj.CID, 10), aidB)
-		// 用户文章列表
-		db.Hdel("user_article_timeline:"+strconv.FormatUint(aobj.UID, 10), youdb.I2b(aobj.ID))
-		// 分类下文章数
-		db.Zincr("category_article_num", youdb.I2b(aobj.CID), -1)
-
-		// set
-		db.Hset("article_hidden", aidB, []byte(""))
-		aobj.Hidden = true
-		jb, _ := json.Marshal(aobj)
-		db.Hset("article", aidB, jb)
-		uobj, _ := model.UserGetByID(db, aobj.UID)
-		uobj.Articles--
-		jb, _ = json.Marshal(uobj)
-		db.Hset("user", youdb.I2b(uobj.ID), jb)
-
+		model.ArticleTrashAdd(db, aobj, currentUser.ID)
+		h.notifyAdmin(aobj.UID, currentUser.ID, "你的帖子《"+aobj.Title+"》已被管理员删除")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
@@ -86,6 +68,7 @@ func (h *BaseHandler) ArticleEdit(w http.ResponseWriter, r *http.Request) {
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = "修改文章"
 	evn.IsMobile = tpl == "mobile"
 	evn.CurrentUser = currentUser
@@ -156,12 +139,16 @@ func (h *BaseHandler) ArticleEditPost(w http.ResponseWriter, r *http.Request) {
 
 	db := h.App.Db
 	if rec.Act == "preview" {
+		format := util.ContentFormatNew()
+		if aobj, err := model.ArticleGetByID(db, aidS); err == nil {
+			format = aobj.Format
+		}
 		tmp := struct {
 			normalRsp
 			Html string `json:"html"`
 		}{
 			normalRsp{200, ""},
-			util.ContentFmt(db, rec.Content),
+			util.ContentFmt(db, format, rec.Content),
 		}
 		json.NewEncoder(w).Encode(tmp)
 		return
@@ -213,77 +200,20 @@ func (h *BaseHandler) ArticleEditPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	oldCid := aobj.CID
-	oldTitle := aobj.Title
-	oldTags := aobj.Tags
+	oldObj := aobj
 
 	aobj.CID = rec.Cid
 	aobj.Title = rec.Title
 	aobj.Content = rec.Content
 	aobj.Tags = rec.Tags
 	aobj.CloseComment = closeComment
+	aobj.Edited = uint64(time.Now().UTC().Unix())
 
-	jb, _ := json.Marshal(aobj)
-	db.Hset("article", aidB, jb)
-
-	if oldCid != rec.Cid {
-		db.Zincr("category_article_num", youdb.I2b(rec.Cid), 1)
-		db.Zincr("category_article_num", youdb.I2b(oldCid), -1)
-
-		db.Zset("category_article_timeline:"+strconv.FormatUint(rec.Cid, 10), aidB, aobj.EditTime)
-		db.Zdel("category_article_timeline:"+strconv.FormatUint(oldCid, 10), aidB)
-	}
-
-	if oldTitle != rec.Title {
-		hash0 := md5.Sum([]byte(oldTitle))
-		titleMd50 := hex.EncodeToString(hash0[:])
-		db.Hdel("title_md5", []byte(titleMd50))
-		db.Hset("title_md5", []byte(titleMd5), aidB)
-	}
+	model.ArticleUpdate(db, oldObj, aobj)
+	model.ArticleRevisionAdd(db, oldObj, aobj, currentUser.ID, h.ClientIP(r), 0)
 
-	if oldTags != rec.Tags {
-		oldTag := strings.Split(oldTags, ",")
-		newTag := strings.Split(rec.Tags, ",")
-
-		// remove
-		for _, tag1 := range oldTag {
-			contains := false
-			for _, tag2 := range newTag {
-				if tag1 == tag2 {
-					contains = true
-					break
-				}
-			}
-			if contains == false {
-				tagLower := strings.ToLower(tag1)
-				db.Hdel("tag", []byte(tagLower))
-				db.Hdel("tag:"+tagLower, aidB)
-				db.Zincr("tag_article_num", []byte(tagLower), -1)
-			}
-		}
-		// add
-		for _, tag1 := range newTag {
-			contains := false
-			for _, tag2 := range oldTag {
-				if tag1 == tag2 {
-					contains = true
-					break
-				}
-			}
-			if contains == false {
-				tagLower := strings.ToLower(tag1)
-				if db.Hget("tag", []byte(tagLower)).State != "ok" {
-					db.Hset("tag", []byte(tagLower), []byte(""))
-					db.HnextSequence("tag")
-				}
-				// check if not exist !important
-				if db.Hget("tag:"+tagLower, aidB).State != "ok" {
-					db.Hset("tag:"+tagLower, aidB, []byte(""))
-					db.Zincr("tag_article_num", []byte(tagLower), 1)
-				}
-			}
-		}
-	}
+	// 视频等嵌入
+	go util.EmbedPrefetch(db, aobj.Content)
 
 	h.DelCookie(w, "token")
 
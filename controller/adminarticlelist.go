@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/missdeer/kani/model"
+	"github.com/rs/xid"
+)
+
+func (h *BaseHandler) AdminArticleList(w http.ResponseWriter, r *http.Request) {
+	btn, key, score := r.FormValue("btn"), r.FormValue("key"), r.FormValue("score")
+	if len(key) > 0 {
+		_, err := strconv.ParseUint(key, 10, 64)
+		if err != nil {
+			w.Write([]byte(`{"retcode":400,"retmsg":"key type err"}`))
+			return
+		}
+	}
+	if len(score) > 0 {
+		_, err := strconv.ParseUint(score, 10, 64)
+		if err != nil {
+			w.Write([]byte(`{"retcode":400,"retmsg":"score type err"}`))
+			return
+		}
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored err"}`))
+		return
+	}
+	if currentUser.Flag < 99 {
+		w.Write([]byte(`{"retcode":403,"retmsg":"flag forbidden"}`))
+		return
+	}
+
+	cmd := "zrscan"
+	if btn == "prev" {
+		cmd = "zscan"
+	}
+
+	db := h.App.Db
+	scf := h.App.Cf.Site
+	pageInfo := model.ArticleList(db, cmd, "article_timeline", key, score, scf.PageShowNum, scf.TimeZone)
+
+	type pageData struct {
+		PageData
+		PageInfo model.ArticlePageInfo
+	}
+
+	tpl := h.CurrentTpl(r)
+	evn := &pageData{}
+	evn.SiteCf = scf
+	evn.CsrfToken = h.CsrfToken(r)
+	evn.Title = "文章列表"
+	evn.IsMobile = tpl == "mobile"
+	evn.CurrentUser = currentUser
+	evn.ShowSideAd = true
+	evn.PageName = "article_list"
+
+	evn.PageInfo = pageInfo
+
+	h.SetCookie(w, "token", xid.New().String(), 1)
+	h.Render(w, tpl, evn, "layout.html", "adminarticlelist.html")
+}
+
+// AdminArticleListPost 批量删除文章到回收站
+func (h *BaseHandler) AdminArticleListPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	token := h.GetCookie(r, "token")
+	if len(token) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"token cookie missed"}`))
+		return
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored err"}`))
+		return
+	}
+	if currentUser.Flag < 99 {
+		w.Write([]byte(`{"retcode":403,"retmsg":"flag forbidden"}`))
+		return
+	}
+
+	type recForm struct {
+		Act string   `json:"act"`
+		Ids []uint64 `json:"ids"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var rec recForm
+	err := decoder.Decode(&rec)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"json Decode err:` + err.Error() + `"}`))
+		return
+	}
+	defer r.Body.Close()
+
+	if rec.Act != "del" {
+		w.Write([]byte(`{"retcode":400,"retmsg":"unknown act"}`))
+		return
+	}
+	if len(rec.Ids) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
+		return
+	}
+
+	db := h.App.Db
+	for _, aid := range rec.Ids {
+		aobj, err := model.ArticleGetByID(db, strconv.FormatUint(aid, 10))
+		if err != nil {
+			continue
+		}
+		model.ArticleTrashAdd(db, aobj, currentUser.ID)
+		h.notifyAdmin(aobj.UID, currentUser.ID, "你的帖子《"+aobj.Title+"》已被管理员删除")
+	}
+
+	w.Write([]byte(`{"retcode":200,"retmsg":"ok"}`))
+}
@@ -57,6 +57,7 @@ func (h *BaseHandler) AdminCategoryList(w http.ResponseWriter, r *http.Request)
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = "分类列表"
 	evn.IsMobile = tpl == "mobile"
 	evn.CurrentUser = currentUser
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/missdeer/kani/model"
 	"github.com/missdeer/kani/util"
@@ -48,8 +49,9 @@ func (h *BaseHandler) CommentEdit(w http.ResponseWriter, r *http.Request) {
 	act := r.FormValue("act")
 
 	if act == "del" {
-		// remove
-		model.CommentDelByKey(db, aid, cidI)
+		// 删除到回收站
+		model.CommentTrashAdd(db, aobj, cobj, currentUser.ID)
+		h.notifyAdmin(cobj.UID, currentUser.ID, "你在《"+aobj.Title+"》的回复 #"+cid+" 已被管理员删除")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
@@ -63,6 +65,7 @@ func (h *BaseHandler) CommentEdit(w http.ResponseWriter, r *http.Request) {
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = "修改评论"
 	evn.IsMobile = tpl == "mobile"
 	evn.CurrentUser = currentUser
@@ -130,7 +133,7 @@ func (h *BaseHandler) CommentEditPost(w http.ResponseWriter, r *http.Request) {
 			Html string `json:"html"`
 		}{
 			normalRsp{200, ""},
-			util.ContentFmt(db, rec.Content),
+			util.ContentFmt(db, cobj.Format, rec.Content),
 		}
 		json.NewEncoder(w).Encode(tmp)
 		return
@@ -143,9 +146,14 @@ func (h *BaseHandler) CommentEditPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	oldObj := cobj
 	cobj.Content = rec.Content
+	cobj.Edited = uint64(time.Now().UTC().Unix())
 
 	model.CommentSetByKey(db, aid, cidI, cobj)
+	model.CommentRevisionAdd(db, oldObj, cobj, currentUser.ID, h.ClientIP(r), 0)
+	model.SearchIndexArticle(db, aidI)
+	go util.EmbedPrefetch(db, cobj.Content)
 
 	h.DelCookie(w, "token")
 
@@ -51,6 +51,7 @@ func (h *BaseHandler) AdminLinkList(w http.ResponseWriter, r *http.Request) {
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = "链接列表"
 	evn.IsMobile = tpl == "mobile"
 	evn.CurrentUser = currentUser
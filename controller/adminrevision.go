@@ -0,0 +1,345 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/model"
+	"github.com/missdeer/kani/util"
+	"github.com/rs/xid"
+	"goji.io/pat"
+)
+
+type revisionField struct {
+	Name string
+	Old  string
+	New  string
+}
+
+type revisionPageData struct {
+	PageData
+	Aobj        model.Article
+	Cobj        model.Comment
+	IsComment   bool
+	BaseUrl     string
+	Items       []model.RevisionListItem
+	Cur         model.RevisionListItem
+	Prev        model.RevisionListItem
+	HasPrev     bool
+	Fields      []revisionField
+	ContentDiff []util.DiffRow
+}
+
+// revisionPage 版本列表，当前版本 rid 与上一个版本对比
+func (h *BaseHandler) revisionPage(w http.ResponseWriter, r *http.Request, evn *revisionPageData, tb string) {
+	db := h.App.Db
+	scf := h.App.Cf.Site
+
+	evn.Items = model.RevisionList(db, tb, scf.TimeZone)
+	if len(evn.Items) > 0 {
+		rid, _ := strconv.ParseUint(r.FormValue("rid"), 10, 64)
+		pos := 0
+		for i, item := range evn.Items {
+			if item.ID == rid {
+				pos = i
+				break
+			}
+		}
+		evn.Cur = evn.Items[pos]
+		if pos+1 < len(evn.Items) {
+			evn.Prev = evn.Items[pos+1]
+			evn.HasPrev = true
+		}
+	}
+
+	if !evn.IsComment {
+		if evn.Prev.Title != evn.Cur.Title {
+			evn.Fields = append(evn.Fields, revisionField{"标题", evn.Prev.Title, evn.Cur.Title})
+		}
+		if evn.Prev.CategoryID != evn.Cur.CategoryID {
+			oldCobj, _ := model.CategoryGetByID(db, strconv.FormatUint(evn.Prev.CategoryID, 10))
+			newCobj, _ := model.CategoryGetByID(db, strconv.FormatUint(evn.Cur.CategoryID, 10))
+			evn.Fields = append(evn.Fields, revisionField{"分类", oldCobj.Name, newCobj.Name})
+		}
+		if evn.Prev.Tags != evn.Cur.Tags {
+			evn.Fields = append(evn.Fields, revisionField{"标签", evn.Prev.Tags, evn.Cur.Tags})
+		}
+		if evn.Prev.CloseComment != evn.Cur.CloseComment {
+			evn.Fields = append(evn.Fields, revisionField{"关闭评论", strconv.FormatBool(evn.Prev.CloseComment), strconv.FormatBool(evn.Cur.CloseComment)})
+		}
+	}
+	evn.ContentDiff = util.DiffSideBySide(evn.Prev.Content, evn.Cur.Content)
+
+	tpl := h.CurrentTpl(r)
+	evn.SiteCf = scf
+	evn.CsrfToken = h.CsrfToken(r)
+	evn.IsMobile = tpl == "mobile"
+	evn.ShowSideAd = true
+	evn.PageName = "revision"
+
+	h.SetCookie(w, "token", xid.New().String(), 1)
+	h.Render(w, tpl, evn, "layout.html", "adminrevision.html")
+}
+
+func (h *BaseHandler) ArticleRevision(w http.ResponseWriter, r *http.Request) {
+	aid := pat.Param(r, "aid")
+	_, err := strconv.ParseUint(aid, 10, 64)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"aid type err"}`))
+		return
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored err"}`))
+		return
+	}
+	if currentUser.Flag < 99 {
+		w.Write([]byte(`{"retcode":403,"retmsg":"flag forbidden"}`))
+		return
+	}
+
+	db := h.App.Db
+
+	aobj, err := model.ArticleGetByID(db, aid)
+	if err != nil {
+		w.Write([]byte(`{"retcode":404,"retmsg":"aid not found"}`))
+		return
+	}
+
+	evn := &revisionPageData{}
+	evn.Title = "修订历史"
+	evn.CurrentUser = currentUser
+	evn.Aobj = aobj
+	evn.BaseUrl = "/admin/post/revision/" + aid
+
+	h.revisionPage(w, r, evn, model.ArticleRevisionTb(aobj.ID))
+}
+
+func (h *BaseHandler) ArticleRevisionPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	aid := pat.Param(r, "aid")
+	_, err := strconv.ParseUint(aid, 10, 64)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"aid type err"}`))
+		return
+	}
+
+	token := h.GetCookie(r, "token")
+	if len(token) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"token cookie missed"}`))
+		return
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored require"}`))
+		return
+	}
+	if currentUser.Flag < 99 {
+		w.Write([]byte(`{"retcode":403,"retmsg":"flag forbidden"}`))
+		return
+	}
+
+	type recForm struct {
+		Act string `json:"act"`
+		Rid uint64 `json:"rid"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var rec recForm
+	err = decoder.Decode(&rec)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"json Decode err:` + err.Error() + `"}`))
+		return
+	}
+	defer r.Body.Close()
+
+	if rec.Act != "rollback" {
+		w.Write([]byte(`{"retcode":400,"retmsg":"unknown act"}`))
+		return
+	}
+
+	db := h.App.Db
+
+	aobj, err := model.ArticleGetByID(db, aid)
+	if err != nil {
+		w.Write([]byte(`{"retcode":404,"retmsg":"aid not found"}`))
+		return
+	}
+	rev, err := model.RevisionGet(db, model.ArticleRevisionTb(aobj.ID), rec.Rid)
+	if err != nil {
+		w.Write([]byte(`{"retcode":404,"retmsg":"revision not found"}`))
+		return
+	}
+
+	if aobj.CID == rev.CategoryID && aobj.Title == rev.Title && aobj.Content == rev.Content && aobj.Tags == rev.Tags && aobj.CloseComment == rev.CloseComment {
+		w.Write([]byte(`{"retcode":201,"retmsg":"nothing changed"}`))
+		return
+	}
+
+	// check title
+	hash := md5.Sum([]byte(rev.Title))
+	titleMd5 := hex.EncodeToString(hash[:])
+	rs0 := db.Hget("title_md5", []byte(titleMd5))
+	if rs0.State == "ok" && !bytes.Equal(rs0.Data[0], youdb.I2b(aobj.ID)) {
+		w.Write([]byte(`{"retcode":403,"retmsg":"title has existed"}`))
+		return
+	}
+
+	_, err = model.CategoryGetByID(db, strconv.FormatUint(rev.CategoryID, 10))
+	if err != nil {
+		w.Write([]byte(`{"retcode":404,"retmsg":"` + err.Error() + `"}`))
+		return
+	}
+
+	oldObj := aobj
+
+	aobj.CID = rev.CategoryID
+	aobj.Title = rev.Title
+	aobj.Content = rev.Content
+	aobj.Tags = rev.Tags
+	aobj.CloseComment = rev.CloseComment
+	aobj.Edited = uint64(time.Now().UTC().Unix())
+
+	model.ArticleUpdate(db, oldObj, aobj)
+	model.ArticleRevisionAdd(db, oldObj, aobj, currentUser.ID, h.ClientIP(r), rev.ID)
+	// 视频等嵌入
+	go util.EmbedPrefetch(db, aobj.Content)
+
+	h.DelCookie(w, "token")
+
+	w.Write([]byte(`{"retcode":200,"retmsg":"ok"}`))
+}
+
+func (h *BaseHandler) CommentRevision(w http.ResponseWriter, r *http.Request) {
+	aid, cid := pat.Param(r, "aid"), pat.Param(r, "cid")
+	_, err := strconv.ParseUint(aid, 10, 64)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"aid type err"}`))
+		return
+	}
+	cidI, err := strconv.ParseUint(cid, 10, 64)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"cid type err"}`))
+		return
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored err"}`))
+		return
+	}
+	if currentUser.Flag < 99 {
+		w.Write([]byte(`{"retcode":403,"retmsg":"flag forbidden"}`))
+		return
+	}
+
+	db := h.App.Db
+
+	aobj, _ := model.ArticleGetByID(db, aid)
+	cobj, err := model.CommentGetByKey(db, aid, cidI)
+	if err != nil {
+		w.Write([]byte(`{"retcode":404,"retmsg":"` + err.Error() + `"}`))
+		return
+	}
+
+	evn := &revisionPageData{}
+	evn.Title = "评论修订历史"
+	evn.CurrentUser = currentUser
+	evn.Aobj = aobj
+	evn.Cobj = cobj
+	evn.IsComment = true
+	evn.BaseUrl = "/admin/comment/revision/" + aid + "/" + cid
+
+	h.revisionPage(w, r, evn, model.CommentRevisionTb(cobj.AID, cobj.ID))
+}
+
+func (h *BaseHandler) CommentRevisionPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	aid, cid := pat.Param(r, "aid"), pat.Param(r, "cid")
+	aidI, err := strconv.ParseUint(aid, 10, 64)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"aid type err"}`))
+		return
+	}
+	cidI, err := strconv.ParseUint(cid, 10, 64)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"cid type err"}`))
+		return
+	}
+
+	token := h.GetCookie(r, "token")
+	if len(token) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"token cookie missed"}`))
+		return
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored require"}`))
+		return
+	}
+	if currentUser.Flag < 99 {
+		w.Write([]byte(`{"retcode":403,"retmsg":"flag forbidden"}`))
+		return
+	}
+
+	type recForm struct {
+		Act string `json:"act"`
+		Rid uint64 `json:"rid"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var rec recForm
+	err = decoder.Decode(&rec)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"json Decode err:` + err.Error() + `"}`))
+		return
+	}
+	defer r.Body.Close()
+
+	if rec.Act != "rollback" {
+		w.Write([]byte(`{"retcode":400,"retmsg":"unknown act"}`))
+		return
+	}
+
+	db := h.App.Db
+
+	cobj, err := model.CommentGetByKey(db, aid, cidI)
+	if err != nil {
+		w.Write([]byte(`{"retcode":404,"retmsg":"` + err.Error() + `"}`))
+		return
+	}
+	rev, err := model.RevisionGet(db, model.CommentRevisionTb(aidI, cidI), rec.Rid)
+	if err != nil {
+		w.Write([]byte(`{"retcode":404,"retmsg":"revision not found"}`))
+		return
+	}
+
+	if cobj.Content == rev.Content {
+		w.Write([]byte(`{"retcode":201,"retmsg":"nothing changed"}`))
+		return
+	}
+
+	oldObj := cobj
+	cobj.Content = rev.Content
+	cobj.Edited = uint64(time.Now().UTC().Unix())
+
+	model.CommentSetByKey(db, aid, cidI, cobj)
+	model.CommentRevisionAdd(db, oldObj, cobj, currentUser.ID, h.ClientIP(r), rev.ID)
+	model.SearchIndexArticle(db, aidI)
+	go util.EmbedPrefetch(db, cobj.Content)
+
+	h.DelCookie(w, "token")
+
+	w.Write([]byte(`{"retcode":200,"retmsg":"ok"}`))
+}
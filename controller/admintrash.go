@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/model"
+	"github.com/rs/xid"
+)
+
+func (h *BaseHandler) AdminTrash(w http.ResponseWriter, r *http.Request) {
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored err"}`))
+		return
+	}
+	if currentUser.Flag < 99 {
+		w.Write([]byte(`{"retcode":403,"retmsg":"flag forbidden"}`))
+		return
+	}
+
+	db := h.App.Db
+	scf := h.App.Cf.Site
+
+	type pageData struct {
+		PageData
+		Articles []model.TrashListItem
+		Comments []model.TrashListItem
+	}
+
+	tpl := h.CurrentTpl(r)
+	evn := &pageData{}
+	evn.SiteCf = scf
+	evn.CsrfToken = h.CsrfToken(r)
+	evn.Title = "回收站"
+	evn.IsMobile = tpl == "mobile"
+	evn.CurrentUser = currentUser
+	evn.ShowSideAd = true
+	evn.PageName = "trash"
+
+	evn.Articles = model.ArticleTrashList(db, 100, scf.TimeZone)
+	evn.Comments = model.CommentTrashList(db, 100, scf.TimeZone)
+
+	h.SetCookie(w, "token", xid.New().String(), 1)
+	h.Render(w, tpl, evn, "layout.html", "admintrash.html")
+}
+
+// AdminTrashPost 恢复或彻底删除，act: restore/purge，type: article/comment
+func (h *BaseHandler) AdminTrashPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	token := h.GetCookie(r, "token")
+	if len(token) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"token cookie missed"}`))
+		return
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored err"}`))
+		return
+	}
+	if currentUser.Flag < 99 {
+		w.Write([]byte(`{"retcode":403,"retmsg":"flag forbidden"}`))
+		return
+	}
+
+	type recForm struct {
+		Act  string   `json:"act"`
+		Type string   `json:"type"`
+		Keys []string `json:"keys"` // 文章为 aid，评论为 aid:cid
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var rec recForm
+	err := decoder.Decode(&rec)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"json Decode err:` + err.Error() + `"}`))
+		return
+	}
+	defer r.Body.Close()
+
+	if rec.Act != "restore" && rec.Act != "purge" {
+		w.Write([]byte(`{"retcode":400,"retmsg":"unknown act"}`))
+		return
+	}
+	if rec.Type != "article" && rec.Type != "comment" {
+		w.Write([]byte(`{"retcode":400,"retmsg":"unknown type"}`))
+		return
+	}
+	if len(rec.Keys) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
+		return
+	}
+
+	db := h.App.Db
+
+	var failed []string
+	for _, key := range rec.Keys {
+		if rec.Type == "article" {
+			aid := youdb.DS2i(key)
+			if rec.Act == "purge" {
+				err = model.ArticleTrashPurge(db, aid)
+			} else {
+				err = model.ArticleTrashRestore(db, aid)
+			}
+			if err != nil {
+				failed = append(failed, key+" "+err.Error())
+			}
+			continue
+		}
+
+		kv := strings.SplitN(key, ":", 2)
+		if len(kv) != 2 {
+			failed = append(failed, key+" key type err")
+			continue
+		}
+		aid, cid := youdb.DS2i(kv[0]), youdb.DS2i(kv[1])
+		if rec.Act == "purge" {
+			err = model.CommentTrashPurge(db, aid, cid)
+		} else {
+			err = model.CommentTrashRestore(db, aid, cid)
+		}
+		if err != nil {
+			failed = append(failed, key+" "+err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		json.NewEncoder(w).Encode(normalRsp{403, strings.Join(failed, "; ")})
+		return
+	}
+
+	w.Write([]byte(`{"retcode":200,"retmsg":"ok"}`))
+}
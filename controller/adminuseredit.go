@@ -44,13 +44,15 @@ func (h *BaseHandler) UserEdit(w http.ResponseWriter, r *http.Request) {
 
 	type pageData struct {
 		PageData
-		Uobj model.User
-		Now  int64
+		Uobj   model.User
+		Now    int64
+		Audits []model.AdminAuditListItem
 	}
 
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = "修改用户"
 	evn.IsMobile = tpl == "mobile"
 	evn.CurrentUser = currentUser
@@ -59,6 +61,7 @@ func (h *BaseHandler) UserEdit(w http.ResponseWriter, r *http.Request) {
 
 	evn.Uobj = uobj
 	evn.Now = time.Now().UTC().Unix()
+	evn.Audits = model.AdminAuditListByUser(db, uobj.ID, 20, h.App.Cf.Site.TimeZone)
 
 	h.SetCookie(w, "token", xid.New().String(), 1)
 	h.Render(w, tpl, evn, "layout.html", "adminuseredit.html")
@@ -172,6 +175,13 @@ func (h *BaseHandler) UserEditPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	isChanged := false
+	var resetURL string
+	audit := model.AdminAudit{
+		AdminUID:  currentUser.ID,
+		Act:       recAct,
+		TargetUID: uobj.ID,
+		IP:        h.ClientIP(r),
+	}
 	if recAct == "info" {
 		oldName := uobj.Name
 		nameLow := strings.ToLower(rec.Name)
@@ -179,29 +189,66 @@ func (h *BaseHandler) UserEditPost(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte(`{"retcode":400,"retmsg":"name fmt err"}`))
 			return
 		}
+		// 先做完全部检查，再释放旧邮箱
+		if oldName != rec.Name && db.Hget("user_name2uid", []byte(nameLow)).State == "ok" {
+			w.Write([]byte(`{"retcode":400,"retmsg":"name is exist"}`))
+			return
+		}
 
-		uobj.Email = rec.Email
+		if uobj.Email != rec.Email {
+			model.EmailVerifyRelease(db, uobj)
+			uobj.Email = rec.Email
+			uobj.EmailVerified = false
+		}
 		uobj.URL = rec.Url
 		uobj.About = rec.About
 		uobj.Hidden = hidden
 		isChanged = true
 
 		if oldName != rec.Name {
-			if db.Hget("user_name2uid", []byte(nameLow)).State == "ok" {
-				w.Write([]byte(`{"retcode":400,"retmsg":"name is exist"}`))
-				return
-			}
 			db.Hdel("user_name2uid", []byte(strings.ToLower(oldName)))
 			db.Hset("user_name2uid", []byte(nameLow), youdb.I2b(uobj.ID))
+			// 旧的密码哈希依赖用户名
+			uobj.Password = util.PasswordPinName(uobj.Password, oldName)
 			uobj.Name = rec.Name
+			audit.Content = "改名 " + oldName + " -> " + rec.Name
 		}
 	} else if recAct == "change_pw" {
 		if len(rec.Password) == 0 {
 			w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
 			return
 		}
-		uobj.Password = rec.Password
+		pw, err := util.PasswordHash(rec.Password)
+		if err != nil {
+			w.Write([]byte(`{"retcode":500,"retmsg":"password hash err"}`))
+			return
+		}
+		uobj.Password = pw
+		// 让该用户所有已登录的会话失效
+		uobj.Session = ""
+		model.SessionDelByUser(db, uobj.ID, "")
+		isChanged = true
+		audit.Content = "重设密码"
+	} else if recAct == "revoke_sessions" {
+		uobj.Session = ""
 		isChanged = true
+		audit.Content = "退出全部登录设备 " + strconv.Itoa(model.SessionDelByUser(db, uobj.ID, "")) + " 个"
+	} else if recAct == "totp_disable" {
+		// 用户丢失验证器和恢复码时由管理员关闭
+		if uobj.TotpEnabled {
+			model.TotpDisable(db, &uobj)
+			isChanged = true
+			audit.Content = "关闭两步验证"
+		}
+	} else if recAct == "reset_link" {
+		// 生成一次性的找回密码链接，由管理员转交给用户
+		resetToken, err := model.PasswordResetNew(db, uobj.ID, h.App.Cf.Site.PasswordResetExpire*60, 0)
+		if err != nil {
+			w.Write([]byte(`{"retcode":500,"retmsg":"` + err.Error() + `"}`))
+			return
+		}
+		resetURL = h.App.Cf.Site.MainDomain + "/reset/" + resetToken
+		audit.Content = "生成重置密码链接"
 	} else if recAct == "flag" {
 		if rec.Flag != uobj.Flag {
 			oldFlag := strconv.Itoa(uobj.Flag)
@@ -210,19 +257,25 @@ func (h *BaseHandler) UserEditPost(w http.ResponseWriter, r *http.Request) {
 
 			db.Hset("user_flag:"+strconv.Itoa(rec.Flag), youdb.I2b(uobj.ID), []byte(""))
 			db.Hdel("user_flag:"+oldFlag, youdb.I2b(uobj.ID))
+			audit.Content = "权限 " + oldFlag + " -> " + strconv.Itoa(rec.Flag)
 		}
 	}
 
 	if isChanged {
 		model.UserUpdate(db, uobj)
 	}
+	if len(audit.Content) > 0 {
+		model.AdminAuditAdd(db, audit)
+	}
 
 	type response struct {
 		normalRsp
+		URL string `json:"url,omitempty"`
 	}
 
 	rsp := response{}
 	rsp.Retcode = 200
 	rsp.Retmsg = "修改成功"
+	rsp.URL = resetURL
 	json.NewEncoder(w).Encode(rsp)
 }
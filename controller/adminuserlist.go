@@ -55,6 +55,7 @@ func (h *BaseHandler) AdminUserList(w http.ResponseWriter, r *http.Request) {
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = "用户列表"
 	evn.IsMobile = tpl == "mobile"
 	evn.CurrentUser = currentUser
@@ -122,13 +123,19 @@ func (h *BaseHandler) AdminUserListPost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	pw, err := util.PasswordHash(rec.Password)
+	if err != nil {
+		w.Write([]byte(`{"retcode":500,"retmsg":"password hash err"}`))
+		return
+	}
+
 	userId, _ := db.HnextSequence("user")
 	flag := 5
 
 	uobj := model.User{
 		ID:            userId,
 		Name:          rec.Name,
-		Password:      rec.Password,
+		Password:      pw,
 		Flag:          flag,
 		RegTime:       timeStamp,
 		LastLoginTime: timeStamp,
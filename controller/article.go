@@ -58,11 +58,13 @@ func (h *BaseHandler) ArticleAdd(w http.ResponseWriter, r *http.Request) {
 		PageData
 		Cobj      model.Category
 		MainNodes []model.CategoryMini
+		Captcha   string
 	}
 
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = "发表文章"
 	evn.IsMobile = tpl == "mobile"
 	evn.CurrentUser = currentUser
@@ -71,6 +73,7 @@ func (h *BaseHandler) ArticleAdd(w http.ResponseWriter, r *http.Request) {
 
 	evn.Cobj = cobj
 	evn.MainNodes = model.CategoryGetMain(db, cobj)
+	evn.Captcha = h.captchaNew(captchaActNewPost, currentUser)
 
 	h.SetCookie(w, "token", xid.New().String(), 1)
 	h.Render(w, tpl, evn, "layout.html", "articlecreate.html")
@@ -96,10 +99,13 @@ func (h *BaseHandler) ArticleAddPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type recForm struct {
-		Act     string `json:"act"`
-		Cid     uint64 `json:"cid"`
-		Title   string `json:"title"`
-		Content string `json:"content"`
+		Act       string `json:"act"`
+		Cid       uint64 `json:"cid"`
+		Pid       uint64 `json:"pid"`
+		Title     string `json:"title"`
+		Content   string `json:"content"`
+		CaptchaID string `json:"captchaid"`
+		Captcha   string `json:"captcha"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -121,7 +127,7 @@ func (h *BaseHandler) ArticleAddPost(w http.ResponseWriter, r *http.Request) {
 			Html string `json:"html"`
 		}{
 			normalRsp{200, ""},
-			util.ContentFmt(db, rec.Content),
+			util.ContentFmt(db, util.ContentFormatNew(), rec.Content),
 		}
 		json.NewEncoder(w).Encode(tmp)
 		return
@@ -169,6 +175,11 @@ func (h *BaseHandler) ArticleAddPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.captchaRequired(captchaActNewPost, currentUser) && !model.CaptchaCheck(db, rec.CaptchaID, rec.Captcha) {
+		h.captchaFailRsp(w)
+		return
+	}
+
 	newAid, _ := db.HnextSequence("article")
 	aobj := model.Article{
 		ID:       newAid,
@@ -176,9 +187,10 @@ func (h *BaseHandler) ArticleAddPost(w http.ResponseWriter, r *http.Request) {
 		CID:      rec.Cid,
 		Title:    rec.Title,
 		Content:  rec.Content,
+		Format:   util.ContentFormatNew(),
 		AddTime:  now,
 		EditTime: now,
-		ClientIP: r.Header.Get("X-FORWARDED-FOR"),
+		ClientIP: h.ClientIP(r),
 	}
 
 	jb, _ := json.Marshal(aobj)
@@ -202,6 +214,11 @@ func (h *BaseHandler) ArticleAddPost(w http.ResponseWriter, r *http.Request) {
 	// title md5
 	db.Hset("title_md5", []byte(titleMd5), aidB)
 
+	// 全文索引
+	model.SearchIndexArticle(db, aobj.ID)
+	// 视频等嵌入
+	go util.EmbedPrefetch(db, aobj.Content)
+
 	// send task work
 	// get tag from title
 	if scf.AutoGetTag && len(scf.GetTagApi) > 0 {
@@ -209,37 +226,7 @@ func (h *BaseHandler) ArticleAddPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// @ somebody in content
-	sbs := util.GetMention(rec.Content,
-		[]string{currentUser.Name, strconv.FormatUint(currentUser.ID, 10)})
-
-	aid := strconv.FormatUint(newAid, 10)
-	for _, sb := range sbs {
-		var sbObj model.User
-		sbu, err := strconv.ParseUint(sb, 10, 64)
-		if err != nil {
-			// @ user name
-			sbObj, err = model.UserGetByName(db, strings.ToLower(sb))
-		} else {
-			// @ user id
-			sbObj, err = model.UserGetByID(db, sbu)
-		}
-
-		if err == nil {
-			if len(sbObj.Notice) > 0 {
-				aidList := util.SliceUniqStr(strings.Split(aid+","+sbObj.Notice, ","))
-				if len(aidList) > 100 {
-					aidList = aidList[:100]
-				}
-				sbObj.Notice = strings.Join(aidList, ",")
-				sbObj.NoticeNum = len(aidList)
-			} else {
-				sbObj.Notice = aid
-				sbObj.NoticeNum = 1
-			}
-			jb, _ := json.Marshal(sbObj)
-			db.Hset("user", youdb.I2b(sbObj.ID), jb)
-		}
-	}
+	h.notifyMention(rec.Content, currentUser, aobj.ID, 0, map[uint64]bool{currentUser.ID: true})
 
 	h.DelCookie(w, "token")
 
@@ -344,6 +331,7 @@ func (h *BaseHandler) ArticleHomeList(w http.ResponseWriter, r *http.Request) {
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = scf
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = scf.Name
 	evn.Keywords = evn.Title
 	evn.Description = scf.Desc
@@ -363,20 +351,15 @@ func (h *BaseHandler) ArticleHomeList(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *BaseHandler) ArticleDetail(w http.ResponseWriter, r *http.Request) {
-	btn, key, score := r.FormValue("btn"), r.FormValue("key"), r.FormValue("score")
-	if len(key) > 0 {
-		_, err := strconv.ParseUint(key, 10, 64)
-		if err != nil {
-			w.Write([]byte(`{"retcode":400,"retmsg":"key type err"}`))
-			return
-		}
-	}
-	if len(score) > 0 {
-		_, err := strconv.ParseUint(score, 10, 64)
-		if err != nil {
-			w.Write([]byte(`{"retcode":400,"retmsg":"score type err"}`))
+	p := r.FormValue("p")
+	page := 1
+	if len(p) > 0 {
+		pageI, err := strconv.Atoi(p)
+		if err != nil || pageI < 1 {
+			w.Write([]byte(`{"retcode":400,"retmsg":"p type err"}`))
 			return
 		}
+		page = pageI
 	}
 
 	aid := pat.Param(r, "aid")
@@ -386,11 +369,6 @@ func (h *BaseHandler) ArticleDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cmd := "hscan"
-	if btn == "prev" {
-		cmd = "hrscan"
-	}
-
 	db := h.App.Db
 	scf := h.App.Cf.Site
 	aobj, err := model.ArticleGetByID(db, aid)
@@ -400,22 +378,9 @@ func (h *BaseHandler) ArticleDetail(w http.ResponseWriter, r *http.Request) {
 	}
 	currentUser, _ := h.CurrentUser(w, r)
 
-	if len(currentUser.Notice) > 0 && len(currentUser.Notice) >= len(aid) {
-		if len(aid) == len(currentUser.Notice) && aid == currentUser.Notice {
-			currentUser.Notice = ""
-			currentUser.NoticeNum = 0
-			jb, _ := json.Marshal(currentUser)
-			db.Hset("user", youdb.I2b(currentUser.ID), jb)
-		} else {
-			subStr := "," + aid + ","
-			newNotice := "," + currentUser.Notice + ","
-			if strings.Index(newNotice, subStr) >= 0 {
-				currentUser.Notice = strings.Trim(strings.Replace(newNotice, subStr, "", 1), ",")
-				currentUser.NoticeNum--
-				jb, _ := json.Marshal(currentUser)
-				db.Hset("user", youdb.I2b(currentUser.ID), jb)
-			}
-		}
+	if currentUser.NoticeNum > 0 {
+		currentUser.NoticeNum = model.NotificationMarkRead(db, currentUser.ID, aobj.ID)
+		h.publishNotice(currentUser.ID, currentUser.NoticeNum)
 	}
 
 	if aobj.Hidden && currentUser.Flag < 99 {
@@ -443,7 +408,16 @@ func (h *BaseHandler) ArticleDetail(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cobj.Articles = db.Zget("category_article_num", youdb.I2b(cobj.ID)).Uint64()
-	pageInfo := model.CommentList(db, cmd, "article_comment:"+aid, key, scf.CommentListNum, scf.TimeZone)
+	pageInfo := model.CommentListPage(db, aid, page, scf.CommentListNum, scf.TimeZone)
+	if currentUser.ID > 0 && pageInfo.LastKey > 0 {
+		model.CommentReadSet(db, currentUser.ID, aobj.ID, pageInfo.LastKey)
+	}
+	for i, item := range pageInfo.Items {
+		pageInfo.Items[i].Editable = h.authorEditable(currentUser, item.UID, item.AddTime, commentReplied(db, aid, item.ID))
+	}
+	if scf.CommentMaxDepth > 0 {
+		pageInfo.Items = model.CommentThread(pageInfo.Items, scf.CommentMaxDepth)
+	}
 
 	type articleForDetail struct {
 		model.Article
@@ -454,6 +428,8 @@ func (h *BaseHandler) ArticleDetail(w http.ResponseWriter, r *http.Request) {
 		Views       uint64
 		AddTimeFmt  string
 		EditTimeFmt string
+		EditedFmt   string
+		Editable    bool
 	}
 
 	type pageData struct {
@@ -464,11 +440,13 @@ func (h *BaseHandler) ArticleDetail(w http.ResponseWriter, r *http.Request) {
 		Relative model.ArticleRelative
 		PageInfo model.CommentPageInfo
 		Views    uint64
+		Captcha  string // 评论验证码 ID
 	}
 
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = scf
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = aobj.Title + " - " + cobj.Name + " - " + scf.Name
 	evn.Keywords = aobj.Tags
 	evn.Description = cobj.Name + " - " + aobj.Title + " - " + aobj.Tags
@@ -484,12 +462,16 @@ func (h *BaseHandler) ArticleDetail(w http.ResponseWriter, r *http.Request) {
 	viewsNum, _ := db.Hincr("article_views", youdb.I2b(aobj.ID), 1)
 	evn.Aobj = articleForDetail{
 		Article:     aobj,
-		ContentFmt:  template.HTML(util.ContentFmt(db, aobj.Content)),
+		ContentFmt:  template.HTML(util.ContentFmt(db, aobj.Format, aobj.Content)),
 		Name:        author.Name,
 		Avatar:      author.Avatar,
 		Views:       viewsNum,
 		AddTimeFmt:  util.TimeFmt(aobj.AddTime, "2006-01-02 15:04", scf.TimeZone),
 		EditTimeFmt: util.TimeFmt(aobj.EditTime, "2006-01-02 15:04", scf.TimeZone),
+		Editable:    h.authorEditable(currentUser, aobj.UID, aobj.AddTime, aobj.Comments > 0),
+	}
+	if aobj.Edited > 0 {
+		evn.Aobj.EditedFmt = util.TimeFmt(aobj.Edited, "2006-01-02 15:04", scf.TimeZone)
 	}
 
 	if len(aobj.Tags) > 0 {
@@ -503,6 +485,9 @@ func (h *BaseHandler) ArticleDetail(w http.ResponseWriter, r *http.Request) {
 	evn.Cobj = cobj
 	evn.Relative = model.ArticleGetRelative(db, aobj.ID, aobj.Tags)
 	evn.PageInfo = pageInfo
+	if currentUser.Flag >= 5 && !aobj.CloseComment {
+		evn.Captcha = h.captchaNew(captchaActComment, currentUser)
+	}
 
 	token := h.GetCookie(r, "token")
 	if len(token) == 0 {
@@ -513,6 +498,45 @@ func (h *BaseHandler) ArticleDetail(w http.ResponseWriter, r *http.Request) {
 	h.Render(w, tpl, evn, "layout.html", "article.html")
 }
 
+// ArticleCommentGoto 跳转到评论所在页，cid 为 unread 时跳到第一条未读评论
+func (h *BaseHandler) ArticleCommentGoto(w http.ResponseWriter, r *http.Request) {
+	aid, cid := pat.Param(r, "aid"), pat.Param(r, "cid")
+	aidI, err := strconv.ParseUint(aid, 10, 64)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"aid type err"}`))
+		return
+	}
+
+	db := h.App.Db
+	scf := h.App.Cf.Site
+	tb := "article_comment:" + aid
+
+	var cidI uint64
+	if cid == "unread" {
+		cidI = db.Hsequence(tb)
+		currentUser, _ := h.CurrentUser(w, r)
+		if currentUser.ID > 0 {
+			rs := db.Hscan(tb, youdb.I2b(model.CommentReadGet(db, currentUser.ID, aidI)), 1)
+			if rs.State == "ok" {
+				cidI = youdb.B2i(rs.Data[0])
+			}
+		}
+		if cidI == 0 {
+			http.Redirect(w, r, "/t/"+aid, http.StatusFound)
+			return
+		}
+	} else {
+		cidI, err = strconv.ParseUint(cid, 10, 64)
+		if err != nil {
+			w.Write([]byte(`{"retcode":400,"retmsg":"cid type err"}`))
+			return
+		}
+	}
+
+	page := model.CommentPageOf(cidI, scf.CommentListNum)
+	http.Redirect(w, r, "/t/"+aid+"?p="+strconv.Itoa(page)+"#reply"+strconv.FormatUint(cidI, 10), http.StatusFound)
+}
+
 func (h *BaseHandler) ArticleDetailPost(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	token := h.GetCookie(r, "token")
@@ -529,15 +553,21 @@ func (h *BaseHandler) ArticleDetailPost(w http.ResponseWriter, r *http.Request)
 	}
 
 	type recForm struct {
-		Act     string `json:"act"`
-		Link    string `json:"link"`
-		Content string `json:"content"`
+		Act       string `json:"act"`
+		Link      string `json:"link"`
+		Cid       uint64 `json:"cid"`
+		Pid       uint64 `json:"pid"`
+		Title     string `json:"title"`
+		Content   string `json:"content"`
+		CaptchaID string `json:"captchaid"`
+		Captcha   string `json:"captcha"`
 	}
 
 	type response struct {
 		normalRsp
 		Content string        `json:"content"`
 		Html    template.HTML `json:"html"`
+		Cid     uint64        `json:"cid,omitempty"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -571,7 +601,11 @@ func (h *BaseHandler) ArticleDetailPost(w http.ResponseWriter, r *http.Request)
 		}
 	} else if rec.Act == "comment_preview" {
 		rsp.Retcode = 200
-		rsp.Html = template.HTML(util.ContentFmt(db, rec.Content))
+		rsp.Html = template.HTML(util.ContentFmt(db, util.ContentFormatNew(), rec.Content))
+	} else if rec.Act == "article_edit" || rec.Act == "article_delete" || rec.Act == "comment_edit" || rec.Act == "comment_delete" {
+		// 作者修改、删除
+		h.authorEditPost(w, r, aid, rec.Act, rec.Cid, rec.Title, rec.Content)
+		return
 	} else if rec.Act == "comment_submit" {
 		timeStamp := uint64(time.Now().UTC().Unix())
 		currentUser, _ := h.CurrentUser(w, r)
@@ -584,7 +618,8 @@ func (h *BaseHandler) ArticleDetailPost(w http.ResponseWriter, r *http.Request)
 			return
 		}
 		aobj, err := model.ArticleGetByID(db, aid)
-		if err != nil {
+		if err != nil || (aobj.Hidden && currentUser.Flag < 99) {
+			// 隐藏或在回收站里的文章，只有管理员能回复
 			w.Write([]byte(`{"retcode":404,"retmsg":"not found"}`))
 			return
 		}
@@ -592,14 +627,29 @@ func (h *BaseHandler) ArticleDetailPost(w http.ResponseWriter, r *http.Request)
 			w.Write([]byte(`{"retcode":403,"retmsg":"comment forbidden"}`))
 			return
 		}
+		// 回复某条评论
+		var parent model.Comment
+		if rec.Pid > 0 {
+			parent, err = model.CommentGetByKey(db, aid, rec.Pid)
+			if err != nil {
+				w.Write([]byte(`{"retcode":404,"retmsg":"parent comment not found"}`))
+				return
+			}
+		}
+		if h.captchaRequired(captchaActComment, currentUser) && !model.CaptchaCheck(db, rec.CaptchaID, rec.Captcha) {
+			h.captchaFailRsp(w)
+			return
+		}
 		commentId, _ := db.HnextSequence("article_comment:" + aid)
 		obj := model.Comment{
 			ID:       commentId,
 			AID:      aobj.ID,
 			UID:      currentUser.ID,
+			PID:      parent.ID,
 			Content:  rec.Content,
+			Format:   util.ContentFormatNew(),
 			AddTime:  timeStamp,
-			ClientIP: r.Header.Get("X-FORWARDED-FOR"),
+			ClientIP: h.ClientIP(r),
 		}
 		jb, _ := json.Marshal(obj)
 
@@ -610,7 +660,7 @@ func (h *BaseHandler) ArticleDetailPost(w http.ResponseWriter, r *http.Request)
 
 		// 更新文章列表时间
 
-		aobj.Comments = commentId
+		aobj.Comments++
 		aobj.RUID = currentUser.ID
 		aobj.EditTime = timeStamp
 		jb2, _ := json.Marshal(aobj)
@@ -626,38 +676,38 @@ func (h *BaseHandler) ArticleDetailPost(w http.ResponseWriter, r *http.Request)
 		// 分类文章列表
 		db.Zset("category_article_timeline:"+strconv.FormatUint(aobj.CID, 10), youdb.I2b(aobj.ID), timeStamp)
 
-		// @ somebody in comment & topic author
-		sbs := util.GetMention("@"+strconv.FormatUint(aobj.UID, 10)+" "+rec.Content,
-			[]string{currentUser.Name, strconv.FormatUint(currentUser.ID, 10)})
-		for _, sb := range sbs {
-			var sbObj model.User
-			sbu, err := strconv.ParseUint(sb, 10, 64)
-			if err != nil {
-				// @ user name
-				sbObj, err = model.UserGetByName(db, strings.ToLower(sb))
-			} else {
-				// @ user id
-				sbObj, err = model.UserGetByID(db, sbu)
-			}
+		// 全文索引
+		model.SearchIndexComment(db, aobj.ID, obj.Content)
+		// 视频等嵌入
+		go util.EmbedPrefetch(db, obj.Content)
 
-			if err == nil {
-				if len(sbObj.Notice) > 0 {
-					aidList := util.SliceUniqStr(strings.Split(aid+","+sbObj.Notice, ","))
-					if len(aidList) > 100 {
-						aidList = aidList[:100]
-					}
-					sbObj.Notice = strings.Join(aidList, ",")
-					sbObj.NoticeNum = len(aidList)
-				} else {
-					sbObj.Notice = aid
-					sbObj.NoticeNum = 1
-				}
-				jb, _ := json.Marshal(sbObj)
-				db.Hset("user", youdb.I2b(sbObj.ID), jb)
+		// 提醒被回复的评论作者、文章作者和 @ 到的人
+		notified := map[uint64]bool{currentUser.ID: true}
+		for _, uid := range []uint64{parent.UID, aobj.UID} {
+			if uid == 0 || notified[uid] {
+				continue
 			}
+			notified[uid] = true
+			h.notify(model.Notification{
+				UID:     uid,
+				Type:    model.NotificationReply,
+				FromUID: currentUser.ID,
+				AID:     aobj.ID,
+				CID:     obj.ID,
+			})
 		}
+		h.notifyMention(rec.Content, currentUser, aobj.ID, obj.ID, notified)
+
+		// 推送给正在看这篇文章的人
+		h.App.Hub.PublishTopic("article:"+aid, "comment", map[string]interface{}{
+			"aid":  aobj.ID,
+			"cid":  obj.ID,
+			"uid":  currentUser.ID,
+			"name": currentUser.Name,
+		})
 
 		rsp.Retcode = 200
+		rsp.Cid = obj.ID
 	}
 
 	json.NewEncoder(w).Encode(rsp)
@@ -678,15 +728,17 @@ func (h *BaseHandler) ContentPreviewPost(w http.ResponseWriter, r *http.Request)
 	}
 
 	type recForm struct {
-		Act     string `json:"act"`
-		Link    string `json:"link"`
-		Content string `json:"content"`
+		Act     string  `json:"act"`
+		Link    string  `json:"link"`
+		Content string  `json:"content"`
+		Format  *string `json:"format"` // 修改时为原来的格式，没有时按新内容处理
 	}
 
 	type response struct {
 		normalRsp
 		Content string        `json:"content"`
 		Html    template.HTML `json:"html"`
+		Cid     uint64        `json:"cid,omitempty"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -702,8 +754,12 @@ func (h *BaseHandler) ContentPreviewPost(w http.ResponseWriter, r *http.Request)
 	rsp := response{}
 
 	if rec.Act == "preview" && len(rec.Content) > 0 {
+		format := util.ContentFormatNew()
+		if rec.Format != nil {
+			format = *rec.Format
+		}
 		rsp.Retcode = 200
-		rsp.Html = template.HTML(util.ContentFmt(db, rec.Content))
+		rsp.Html = template.HTML(util.ContentFmt(db, format, rec.Content))
 	}
 	json.NewEncoder(w).Encode(rsp)
 }
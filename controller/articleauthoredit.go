@@ -0,0 +1,262 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/model"
+	"github.com/missdeer/kani/util"
+	"github.com/rs/xid"
+	"goji.io/pat"
+)
+
+// authorEditable 作者在时限内可修改、删除自己的帖子和评论
+func (h *BaseHandler) authorEditable(currentUser model.User, uid, addTime uint64, replied bool) bool {
+	scf := h.App.Cf.Site
+	if currentUser.ID == 0 || currentUser.ID != uid || currentUser.Flag < 5 {
+		return false
+	}
+	if scf.AuthorEditWindow <= 0 {
+		return false
+	}
+	if replied && !scf.AuthorEditReplied {
+		return false
+	}
+	now := uint64(time.Now().UTC().Unix())
+	return now-addTime <= uint64(scf.AuthorEditWindow)
+}
+
+// commentReplied 评论之后是否还有新评论
+func commentReplied(db *youdb.DB, aid string, cid uint64) bool {
+	return db.Hsequence("article_comment:"+aid) > cid
+}
+
+func (h *BaseHandler) ArticleAuthorEdit(w http.ResponseWriter, r *http.Request) {
+	aid := pat.Param(r, "aid")
+	_, err := strconv.ParseUint(aid, 10, 64)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"aid type err"}`))
+		return
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored err"}`))
+		return
+	}
+
+	db := h.App.Db
+
+	aobj, err := model.ArticleGetByID(db, aid)
+	if err != nil || aobj.Hidden {
+		w.Write([]byte(`{"retcode":404,"retmsg":"aid not found"}`))
+		return
+	}
+	if !h.authorEditable(currentUser, aobj.UID, aobj.AddTime, aobj.Comments > 0) {
+		w.Write([]byte(`{"retcode":403,"retmsg":"edit forbidden"}`))
+		return
+	}
+
+	type pageData struct {
+		PageData
+		Aobj model.Article
+		Cobj model.Comment
+	}
+
+	tpl := h.CurrentTpl(r)
+	evn := &pageData{}
+	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
+	evn.Title = "修改帖子"
+	evn.IsMobile = tpl == "mobile"
+	evn.CurrentUser = currentUser
+	evn.ShowSideAd = true
+	evn.PageName = "article_author_edit"
+	evn.Aobj = aobj
+
+	h.SetCookie(w, "token", xid.New().String(), 1)
+	h.Render(w, tpl, evn, "layout.html", "articleedit.html")
+}
+
+func (h *BaseHandler) CommentAuthorEdit(w http.ResponseWriter, r *http.Request) {
+	aid, cid := pat.Param(r, "aid"), pat.Param(r, "cid")
+	_, err := strconv.ParseUint(aid, 10, 64)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"aid type err"}`))
+		return
+	}
+	cidI, err := strconv.ParseUint(cid, 10, 64)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"cid type err"}`))
+		return
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored err"}`))
+		return
+	}
+
+	db := h.App.Db
+
+	aobj, err := model.ArticleGetByID(db, aid)
+	if err != nil || aobj.Hidden {
+		w.Write([]byte(`{"retcode":404,"retmsg":"aid not found"}`))
+		return
+	}
+	cobj, err := model.CommentGetByKey(db, aid, cidI)
+	if err != nil {
+		w.Write([]byte(`{"retcode":404,"retmsg":"` + err.Error() + `"}`))
+		return
+	}
+	if !h.authorEditable(currentUser, cobj.UID, cobj.AddTime, commentReplied(db, aid, cobj.ID)) {
+		w.Write([]byte(`{"retcode":403,"retmsg":"edit forbidden"}`))
+		return
+	}
+
+	type pageData struct {
+		PageData
+		Aobj model.Article
+		Cobj model.Comment
+	}
+
+	tpl := h.CurrentTpl(r)
+	evn := &pageData{}
+	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
+	evn.Title = "修改评论"
+	evn.IsMobile = tpl == "mobile"
+	evn.CurrentUser = currentUser
+	evn.ShowSideAd = true
+	evn.PageName = "comment_author_edit"
+	evn.Aobj = aobj
+	evn.Cobj = cobj
+
+	h.SetCookie(w, "token", xid.New().String(), 1)
+	h.Render(w, tpl, evn, "layout.html", "commentedit.html")
+}
+
+// authorEditPost 作者修改、删除帖子和评论，act: article_edit/article_delete/comment_edit/comment_delete
+func (h *BaseHandler) authorEditPost(w http.ResponseWriter, r *http.Request, aid, act string, cid uint64, title, content string) {
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored require"}`))
+		return
+	}
+
+	db := h.App.Db
+	scf := h.App.Cf.Site
+	now := uint64(time.Now().UTC().Unix())
+	clientIP := h.ClientIP(r)
+
+	aobj, err := model.ArticleGetByID(db, aid)
+	if err != nil || aobj.Hidden {
+		w.Write([]byte(`{"retcode":404,"retmsg":"not found"}`))
+		return
+	}
+
+	title = strings.TrimSpace(title)
+	content = strings.TrimSpace(content)
+
+	switch act {
+	case "article_edit", "article_delete":
+		if !h.authorEditable(currentUser, aobj.UID, aobj.AddTime, aobj.Comments > 0) {
+			w.Write([]byte(`{"retcode":403,"retmsg":"edit forbidden"}`))
+			return
+		}
+
+		if act == "article_delete" {
+			model.ArticleTrashAdd(db, aobj, currentUser.ID)
+			w.Write([]byte(`{"retcode":200,"retmsg":"ok"}`))
+			return
+		}
+
+		if len(title) == 0 {
+			w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
+			return
+		}
+		if len(title) > scf.TitleMaxLen {
+			w.Write([]byte(`{"retcode":403,"retmsg":"TitleMaxLen limited"}`))
+			return
+		}
+		if len(content) > scf.ContentMaxLen {
+			w.Write([]byte(`{"retcode":403,"retmsg":"ContentMaxLen limited"}`))
+			return
+		}
+		if aobj.Title == title && aobj.Content == content {
+			w.Write([]byte(`{"retcode":201,"retmsg":"nothing changed"}`))
+			return
+		}
+
+		// check title
+		hash := md5.Sum([]byte(title))
+		titleMd5 := hex.EncodeToString(hash[:])
+		rs0 := db.Hget("title_md5", []byte(titleMd5))
+		if rs0.State == "ok" && !bytes.Equal(rs0.Data[0], youdb.I2b(aobj.ID)) {
+			w.Write([]byte(`{"retcode":403,"retmsg":"title has existed"}`))
+			return
+		}
+
+		oldObj := aobj
+		aobj.Title = title
+		aobj.Content = content
+		aobj.Edited = now
+
+		model.ArticleUpdate(db, oldObj, aobj)
+		model.ArticleRevisionAdd(db, oldObj, aobj, currentUser.ID, clientIP, 0)
+		// 视频等嵌入
+		go util.EmbedPrefetch(db, aobj.Content)
+
+	case "comment_edit", "comment_delete":
+		cobj, err := model.CommentGetByKey(db, aid, cid)
+		if err != nil {
+			w.Write([]byte(`{"retcode":404,"retmsg":"` + err.Error() + `"}`))
+			return
+		}
+		if !h.authorEditable(currentUser, cobj.UID, cobj.AddTime, commentReplied(db, aid, cobj.ID)) {
+			w.Write([]byte(`{"retcode":403,"retmsg":"edit forbidden"}`))
+			return
+		}
+
+		if act == "comment_delete" {
+			model.CommentTrashAdd(db, aobj, cobj, currentUser.ID)
+			w.Write([]byte(`{"retcode":200,"retmsg":"ok"}`))
+			return
+		}
+
+		if len(content) == 0 {
+			w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
+			return
+		}
+		if len(content) > scf.ContentMaxLen {
+			w.Write([]byte(`{"retcode":403,"retmsg":"ContentMaxLen limited"}`))
+			return
+		}
+		if cobj.Content == content {
+			w.Write([]byte(`{"retcode":201,"retmsg":"nothing changed"}`))
+			return
+		}
+
+		oldObj := cobj
+		cobj.Content = content
+		cobj.Edited = now
+
+		model.CommentSetByKey(db, aid, cid, cobj)
+		model.CommentRevisionAdd(db, oldObj, cobj, currentUser.ID, clientIP, 0)
+		model.SearchIndexArticle(db, aobj.ID)
+		go util.EmbedPrefetch(db, cobj.Content)
+
+	default:
+		w.Write([]byte(`{"retcode":400,"retmsg":"unknown act"}`))
+		return
+	}
+
+	h.DelCookie(w, "token")
+	w.Write([]byte(`{"retcode":200,"retmsg":"ok"}`))
+}
@@ -7,12 +7,15 @@ import (
 	"log"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ego008/youdb"
+	"github.com/gorilla/securecookie"
 	"github.com/missdeer/kani/model"
 	"github.com/missdeer/kani/system"
+	"github.com/missdeer/kani/util"
 )
 
 var mobileRegexp = regexp.MustCompile(`Mobile|iP(hone|od|ad)|Android|BlackBerry|IEMobile|Kindle|NetFront|Silk-Accelerated|(hpw|web)OS|Fennec|Minimo|Opera M(obi|ini)|Blazer|Dolfin|Dolphin|Skyfire|Zune`)
@@ -29,6 +32,7 @@ type (
 		Description   string
 		IsMobile      bool
 		CurrentUser   model.User
+		CsrfToken     string // 页面里的 ajax 和表单提交时带上
 		PageName      string // index/post_add/post_detail/...
 		ShowPostTopAd bool
 		ShowPostBotAd bool
@@ -63,32 +67,66 @@ func (h *BaseHandler) Render(w http.ResponseWriter, tpl string, data interface{}
 	return err
 }
 
+// sessionTouchInterval 会话最后活动时间的更新间隔（秒），避免每个请求都写库
+const sessionTouchInterval = 300
+
 func (h *BaseHandler) CurrentUser(w http.ResponseWriter, r *http.Request) (model.User, error) {
 	var user model.User
 	ssValue := h.GetCookie(r, "SessionID")
 	if len(ssValue) == 0 {
 		return user, errors.New("SessionID cookie not found ")
 	}
-	z := strings.Split(ssValue, ":")
+	z := strings.SplitN(ssValue, ":", 2)
+	if len(z) != 2 {
+		return user, errors.New("SessionID cookie fmt err")
+	}
 	uid := z[0]
 	sessionID := z[1]
 
-	rs := h.App.Db.Hget("user", youdb.DS2b(uid))
+	db := h.App.Db
+	rs := db.Hget("user", youdb.DS2b(uid))
 	if rs.State == "ok" {
 		if err := json.Unmarshal(rs.Data[0], &user); err != nil {
 			return user, err
 		}
-		if sessionID == user.Session {
-			h.SetCookie(w, "SessionID", ssValue, 365)
+		sobj, err := model.SessionGet(db, sessionID)
+		if err == nil && sobj.UID == user.ID {
+			if uint64(time.Now().UTC().Unix()) > sobj.LastTime+sessionTouchInterval {
+				model.SessionTouch(db, sobj, h.ClientIP(r))
+				h.SetCookie(w, "SessionID", ssValue, h.App.Cf.Site.SessionKeepDays)
+			}
+			return user, nil
+		}
+		if len(user.Session) > 0 && sessionID == user.Session {
+			// 旧的单会话，转为会话记录
+			model.SessionNew(db, user.ID, sessionID, r.UserAgent(), h.ClientIP(r))
+			user.Session = ""
+			model.UserUpdate(db, user)
+			h.SetCookie(w, "SessionID", ssValue, h.App.Cf.Site.SessionKeepDays)
 			return user, nil
 		}
 	}
 
-	return user, errors.New("user not found")
+	return model.User{}, errors.New("user not found")
+}
+
+// CurrentSessionID 当前请求的会话 ID
+func (h *BaseHandler) CurrentSessionID(r *http.Request) string {
+	z := strings.SplitN(h.GetCookie(r, "SessionID"), ":", 2)
+	if len(z) != 2 {
+		return ""
+	}
+	return z[1]
+}
+
+// LoginSession 登录成功后新建会话并写入 cookie
+func (h *BaseHandler) LoginSession(w http.ResponseWriter, r *http.Request, uid uint64) {
+	sobj := model.SessionNew(h.App.Db, uid, "", r.UserAgent(), h.ClientIP(r))
+	h.SetCookie(w, "SessionID", strconv.FormatUint(uid, 10)+":"+sobj.ID, h.App.Cf.Site.SessionKeepDays)
 }
 
 func (h *BaseHandler) SetCookie(w http.ResponseWriter, name, value string, days int) error {
-	encoded, err := h.App.Sc.Encode(name, value)
+	encoded, err := securecookie.EncodeMulti(name, value, h.App.Sc...)
 	if err != nil {
 		return err
 	}
@@ -106,7 +144,7 @@ func (h *BaseHandler) SetCookie(w http.ResponseWriter, name, value string, days
 func (h *BaseHandler) GetCookie(r *http.Request, name string) string {
 	if cookie, err := r.Cookie(name); err == nil {
 		var value string
-		if err = h.App.Sc.Decode(name, cookie.Value, &value); err == nil {
+		if err = securecookie.DecodeMulti(name, cookie.Value, &value, h.App.Sc...); err == nil {
 			return value
 		}
 	}
@@ -126,6 +164,11 @@ func (h *BaseHandler) DelCookie(w http.ResponseWriter, name string) {
 	}
 }
 
+// ClientIP 客户端 IP，只有对端是 MainConf.TrustedProxies 里的代理时才看 X-Forwarded-For
+func (h *BaseHandler) ClientIP(r *http.Request) string {
+	return util.ClientIP(r.RemoteAddr, r.Header.Values("X-Forwarded-For"), h.App.Proxy)
+}
+
 func (h *BaseHandler) CurrentTpl(r *http.Request) string {
 	tpl := "desktop"
 	//tpl := "mobile"
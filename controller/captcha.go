@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/missdeer/kani/model"
+	"github.com/missdeer/kani/util"
+	"goji.io/pat"
+)
+
+// 图片验证码，各操作是否需要由 SiteConf 的 Captcha* 开关决定；
+// 登录失败后的验证码见 loginCaptchaRequired
+
+const (
+	captchaActRegister = "register"
+	captchaActNewPost  = "newpost"
+	captchaActComment  = "comment"
+)
+
+// captchaRequired uobj 做 act 时是否需要输入验证码，管理员不需要
+func (h *BaseHandler) captchaRequired(act string, uobj model.User) bool {
+	scf := h.App.Cf.Site
+	if uobj.Flag >= 99 {
+		return false
+	}
+	switch act {
+	case captchaActRegister:
+		return scf.CaptchaRegister
+	case captchaActNewPost:
+		// 注册不满 CaptchaNewPostDays 天的新用户
+		return scf.CaptchaNewPostDays > 0 && uint64(time.Now().UTC().Unix()) < uobj.RegTime+uint64(scf.CaptchaNewPostDays)*86400
+	case captchaActComment:
+		return scf.CaptchaComment
+	}
+	return false
+}
+
+// captchaNew 需要验证码时生成一个 ID，否则返回空；这时还不写数据库，见 model.CaptchaAnswer
+func (h *BaseHandler) captchaNew(act string, uobj model.User) string {
+	if !h.captchaRequired(act, uobj) {
+		return ""
+	}
+	id, _ := model.CaptchaNew()
+	return id
+}
+
+// captchaFailRsp 验证码不正确，带上新的验证码 ID 让页面换一张
+func (h *BaseHandler) captchaFailRsp(w http.ResponseWriter) {
+	type response struct {
+		normalRsp
+		Captcha string `json:"captcha"`
+	}
+	rsp := response{}
+	rsp.Retcode = 400
+	rsp.Retmsg = "验证码不正确"
+	rsp.Captcha, _ = model.CaptchaNew()
+	json.NewEncoder(w).Encode(rsp)
+}
+
+// CaptchaImage 输出验证码图片，第一次请求时才生成答案，reload=1 时换一个答案
+func (h *BaseHandler) CaptchaImage(w http.ResponseWriter, r *http.Request) {
+	answer, ok := model.CaptchaAnswer(h.App.Db, pat.Param(r, "id"), r.FormValue("reload") == "1")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	img, err := util.CaptchaImage(answer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(img)
+}
@@ -66,6 +66,7 @@ func (h *BaseHandler) CategoryDetail(w http.ResponseWriter, r *http.Request) {
 
 	evn := &pageData{}
 	evn.SiteCf = scf
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = cobj.Name + " - " + scf.Name
 	evn.Keywords = cobj.Name
 	evn.Description = cobj.About
@@ -24,6 +24,7 @@ func (h *BaseHandler) UserCharge(w http.ResponseWriter, r *http.Request) {
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = "设置"
 	evn.Keywords = ""
 	evn.Description = ""
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testCsrfToken = "0123456789abcdef0123456789abcdef"
+
+func TestCsrfCheckHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		token  string
+		ok     bool
+	}{
+		{"match", testCsrfToken, testCsrfToken, true},
+		{"mismatch", "fedcba9876543210fedcba9876543210", testCsrfToken, false},
+		{"prefix", testCsrfToken[:16], testCsrfToken, false},
+		{"missing", "", testCsrfToken, false},
+		{"empty cookie token", "", "", false},
+	}
+	for _, tt := range tests {
+		body := `{"act":"info"}`
+		r := httptest.NewRequest("POST", "/setting", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if len(tt.header) > 0 {
+			r.Header.Set("X-CSRF-Token", tt.header)
+		}
+		if got := csrfCheck(r, tt.token); got != tt.ok {
+			t.Errorf("%s: csrfCheck = %v, want %v", tt.name, got, tt.ok)
+		}
+		// JSON 的 body 要留给后面的 handler
+		if b, _ := io.ReadAll(r.Body); string(b) != body {
+			t.Errorf("%s: body consumed, left %q", tt.name, b)
+		}
+	}
+}
+
+func newCsrfMultipart(t *testing.T, fields map[string]string) (*bytes.Buffer, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fw, err := mw.CreateFormFile("avatar", "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("png"))
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func TestCsrfCheckMultipart(t *testing.T) {
+	tests := []struct {
+		name   string
+		field  string
+		header string
+		ok     bool
+	}{
+		{"form field", testCsrfToken, "", true},
+		{"wrong form field", "fedcba9876543210fedcba9876543210", "", false},
+		{"no form field", "", "", false},
+		{"header wins", "wrong", testCsrfToken, true},
+	}
+	for _, tt := range tests {
+		fields := map[string]string{"act": "avatar"}
+		if len(tt.field) > 0 {
+			fields["csrf_token"] = tt.field
+		}
+		body, contentType := newCsrfMultipart(t, fields)
+		r := httptest.NewRequest("POST", "/setting", body)
+		r.Header.Set("Content-Type", contentType)
+		if len(tt.header) > 0 {
+			r.Header.Set("X-CSRF-Token", tt.header)
+		}
+		if got := csrfCheck(r, testCsrfToken); got != tt.ok {
+			t.Errorf("%s: csrfCheck = %v, want %v", tt.name, got, tt.ok)
+		}
+	}
+
+	// 检查时解析过的表单，handler 还能读到字段和文件
+	body, contentType := newCsrfMultipart(t, map[string]string{"act": "avatar", "csrf_token": testCsrfToken})
+	r := httptest.NewRequest("POST", "/setting", body)
+	r.Header.Set("Content-Type", contentType)
+	if !csrfCheck(r, testCsrfToken) {
+		t.Fatal("csrfCheck rejected a valid multipart form")
+	}
+	if r.FormValue("act") != "avatar" {
+		t.Error("form field lost after csrfCheck")
+	}
+	if _, _, err := r.FormFile("avatar"); err != nil {
+		t.Errorf("form file lost after csrfCheck: %v", err)
+	}
+}
+
+// 只有 multipart 表单才从 body 里取 token，普通表单和 JSON 里的 csrf_token 不算
+func TestCsrfCheckOtherBody(t *testing.T) {
+	form := url.Values{"csrf_token": {testCsrfToken}}.Encode()
+	r := httptest.NewRequest("POST", "/setting", strings.NewReader(form))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if csrfCheck(r, testCsrfToken) {
+		t.Error("urlencoded csrf_token accepted")
+	}
+
+	r = httptest.NewRequest("POST", "/setting?csrf_token="+testCsrfToken, strings.NewReader(`{}`))
+	r.Header.Set("Content-Type", "application/json")
+	if csrfCheck(r, testCsrfToken) {
+		t.Error("query string csrf_token accepted")
+	}
+}
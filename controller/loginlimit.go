@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/missdeer/kani/model"
+	"github.com/rs/xid"
+)
+
+// 登录失败限制：同一 IP、同一帐号在 LoginFailWindow 秒内失败太多次后锁定，
+// 锁定时长逐次翻倍；失败达到 LoginCaptchaAfter 次后需要输入图片验证码
+
+// loginCaptchaRequired 开启 CaptchaLogin 时，keys 中任一个的失败次数达到 LoginCaptchaAfter
+func (h *BaseHandler) loginCaptchaRequired(keys ...string) bool {
+	scf := h.App.Cf.Site
+	return scf.CaptchaLogin && scf.LoginCaptchaAfter > 0 && model.LoginFailCount(h.App.Db, scf.LoginFailWindow, keys...) >= scf.LoginCaptchaAfter
+}
+
+// loginFail 记一次登录失败，返回锁定的秒数
+func (h *BaseHandler) loginFail(ipKey, userKey string) int64 {
+	db := h.App.Db
+	scf := h.App.Cf.Site
+	lock := model.LoginFailAdd(db, ipKey, scf.LoginFailWindow, scf.LoginFailIPMax, scf.LoginLockTime)
+	if n := model.LoginFailAdd(db, userKey, scf.LoginFailWindow, scf.LoginFailUserMax, scf.LoginLockTime); n > lock {
+		lock = n
+	}
+	return lock
+}
+
+func loginLockMsg(remain int64) string {
+	return fmt.Sprintf("登录失败次数过多，请 %d 分钟后再试", (remain+59)/60)
+}
+
+func (h *BaseHandler) AdminLoginLock(w http.ResponseWriter, r *http.Request) {
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored err"}`))
+		return
+	}
+	if currentUser.Flag < 99 {
+		w.Write([]byte(`{"retcode":403,"retmsg":"flag forbidden"}`))
+		return
+	}
+
+	scf := h.App.Cf.Site
+
+	type pageData struct {
+		PageData
+		Items []model.LoginLockItem
+	}
+
+	tpl := h.CurrentTpl(r)
+	evn := &pageData{}
+	evn.SiteCf = scf
+	evn.CsrfToken = h.CsrfToken(r)
+	evn.Title = "登录锁定"
+	evn.IsMobile = tpl == "mobile"
+	evn.CurrentUser = currentUser
+	evn.ShowSideAd = true
+	evn.PageName = "login_lock"
+
+	evn.Items = model.LoginLockList(h.App.Db, 100, scf.TimeZone)
+
+	h.SetCookie(w, "token", xid.New().String(), 1)
+	h.Render(w, tpl, evn, "layout.html", "adminloginlock.html")
+}
+
+// AdminLoginLockPost 解除锁定，act: unlock，keys 为 LoginLockItem.Key
+func (h *BaseHandler) AdminLoginLockPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	token := h.GetCookie(r, "token")
+	if len(token) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"token cookie missed"}`))
+		return
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored err"}`))
+		return
+	}
+	if currentUser.Flag < 99 {
+		w.Write([]byte(`{"retcode":403,"retmsg":"flag forbidden"}`))
+		return
+	}
+
+	type recForm struct {
+		Act  string   `json:"act"`
+		Keys []string `json:"keys"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var rec recForm
+	err := decoder.Decode(&rec)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"json Decode err:` + err.Error() + `"}`))
+		return
+	}
+	defer r.Body.Close()
+
+	if rec.Act != "unlock" || len(rec.Keys) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
+		return
+	}
+
+	db := h.App.Db
+	for _, key := range rec.Keys {
+		model.LoginFailClear(db, key)
+		audit := model.AdminAudit{
+			AdminUID: currentUser.ID,
+			Act:      "login_unlock",
+			Content:  "解除登录锁定 " + key,
+			IP:       h.ClientIP(r),
+		}
+		if strings.HasPrefix(key, "user:") {
+			if uobj, err := model.UserGetByName(db, strings.TrimPrefix(key, "user:")); err == nil {
+				audit.TargetUID = uobj.ID
+			}
+		}
+		model.AdminAuditAdd(db, audit)
+	}
+
+	rsp := normalRsp{}
+	rsp.Retcode = 200
+	rsp.Retmsg = "已解除锁定"
+	json.NewEncoder(w).Encode(rsp)
+}
@@ -0,0 +1,270 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/missdeer/kani/model"
+	"github.com/missdeer/kani/system"
+	"github.com/missdeer/kani/util"
+	"github.com/rs/xid"
+)
+
+// publishNotice 推送未读提醒数
+func (h *BaseHandler) publishNotice(uid uint64, num int) {
+	if num >= 0 {
+		h.App.Hub.PublishUser(uid, "notice", map[string]int{"num": num})
+	}
+}
+
+// notify 添加提醒并推送，按用户设置发送邮件
+func (h *BaseHandler) notify(obj model.Notification) {
+	obj.AddTime = uint64(time.Now().UTC().Unix())
+	num := model.NotificationAdd(h.App.Db, obj)
+	h.publishNotice(obj.UID, num)
+	if num >= 0 {
+		h.mailNotify(obj)
+	}
+}
+
+// mailNotify 立即发送提醒邮件，或加入每日汇总队列
+func (h *BaseHandler) mailNotify(obj model.Notification) {
+	if h.App.Mailer == nil {
+		return
+	}
+	db := h.App.Db
+	scf := h.App.Cf.Site
+	uobj, err := model.UserGetByID(db, obj.UID)
+	if err != nil || !model.UserEmailSendable(uobj) {
+		return
+	}
+
+	switch model.UserEmailMode(uobj, obj.Type) {
+	case model.EmailModeDigest:
+		model.EmailDigestQueueAdd(db, uobj.ID, obj.AddTime)
+	case model.EmailModeImmediate:
+		items := model.NotificationItems(db, []model.Notification{obj}, scf.TimeZone)
+		subject := items[0].FromName + " 在《" + items[0].Title + "》中提到了你"
+		if obj.Type == model.NotificationReply {
+			subject = items[0].FromName + " 在《" + items[0].Title + "》中回复了你"
+		}
+		unsubscribeURL := model.EmailUnsubscribeURL(db, scf.MainDomain, uobj.ID, obj.Type)
+		err = h.App.SendMail(uobj.Email, "["+scf.Name+"] "+subject, "notification.html", system.MailData{
+			SiteCf:         scf,
+			Name:           uobj.Name,
+			Items:          items,
+			UnsubscribeURL: unsubscribeURL,
+		}, model.EmailUnsubscribeHeader(unsubscribeURL))
+		if err != nil {
+			log.Println("send notification mail err", err)
+		}
+	}
+}
+
+// notifyMention 提醒内容里 @ 到的人，notified 中的用户不再重复提醒
+func (h *BaseHandler) notifyMention(content string, from model.User, aid, cid uint64, notified map[uint64]bool) {
+	db := h.App.Db
+	sbs := util.GetMention(content, []string{from.Name, strconv.FormatUint(from.ID, 10)})
+	for _, sb := range sbs {
+		var sbObj model.User
+		sbu, err := strconv.ParseUint(sb, 10, 64)
+		if err != nil {
+			// @ user name
+			sbObj, err = model.UserGetByName(db, strings.ToLower(sb))
+		} else {
+			// @ user id
+			sbObj, err = model.UserGetByID(db, sbu)
+		}
+		if err != nil || notified[sbObj.ID] {
+			continue
+		}
+		notified[sbObj.ID] = true
+		h.notify(model.Notification{
+			UID:     sbObj.ID,
+			Type:    model.NotificationMention,
+			FromUID: from.ID,
+			AID:     aid,
+			CID:     cid,
+		})
+	}
+}
+
+// notifyAdmin 管理员操作提醒
+func (h *BaseHandler) notifyAdmin(uid, adminUID uint64, content string) {
+	h.notify(model.Notification{
+		UID:     uid,
+		Type:    model.NotificationAdmin,
+		FromUID: adminUID,
+		Content: content,
+	})
+}
+
+func (h *BaseHandler) UserNotification(w http.ResponseWriter, r *http.Request) {
+	btn, key := r.FormValue("btn"), r.FormValue("key")
+	if len(key) > 0 {
+		_, err := strconv.ParseUint(key, 10, 64)
+		if err != nil {
+			w.Write([]byte(`{"retcode":400,"retmsg":"key type err"}`))
+			return
+		}
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	cmd := "hrscan"
+	if btn == "prev" {
+		cmd = "hscan"
+	}
+
+	type pageData struct {
+		PageData
+		PageInfo model.NotificationPageInfo
+	}
+
+	db := h.App.Db
+	scf := h.App.Cf.Site
+
+	tpl := h.CurrentTpl(r)
+
+	evn := &pageData{}
+	evn.SiteCf = scf
+	evn.CsrfToken = h.CsrfToken(r)
+	evn.Title = "站内提醒 - " + scf.Name
+	evn.IsMobile = tpl == "mobile"
+
+	evn.CurrentUser = currentUser
+	evn.ShowSideAd = true
+	evn.PageName = "user_notification"
+	evn.HotNodes = model.CategoryHot(db, scf.CategoryShowNum)
+	evn.NewestNodes = model.CategoryNewest(db, scf.CategoryShowNum)
+
+	evn.PageInfo = model.NotificationList(db, cmd, currentUser.ID, key, scf.PageShowNum, scf.TimeZone)
+
+	h.SetCookie(w, "token", xid.New().String(), 1)
+	h.Render(w, tpl, evn, "layout.html", "notification.html")
+}
+
+// UserNotificationPost act: read_all 全部标记已读，read 标记一篇文章的提醒已读，del 删除一条
+func (h *BaseHandler) UserNotificationPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	token := h.GetCookie(r, "token")
+	if len(token) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"token cookie missed"}`))
+		return
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored require"}`))
+		return
+	}
+
+	type recForm struct {
+		Act string `json:"act"`
+		Aid uint64 `json:"aid"`
+		Nid uint64 `json:"nid"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var rec recForm
+	err := decoder.Decode(&rec)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"json Decode err:` + err.Error() + `"}`))
+		return
+	}
+	defer r.Body.Close()
+
+	db := h.App.Db
+
+	switch rec.Act {
+	case "read_all":
+		h.publishNotice(currentUser.ID, model.NotificationMarkRead(db, currentUser.ID, 0))
+	case "read":
+		if rec.Aid == 0 {
+			w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
+			return
+		}
+		h.publishNotice(currentUser.ID, model.NotificationMarkRead(db, currentUser.ID, rec.Aid))
+	case "del":
+		if rec.Nid == 0 {
+			w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
+			return
+		}
+		h.publishNotice(currentUser.ID, model.NotificationDel(db, currentUser.ID, rec.Nid))
+	default:
+		w.Write([]byte(`{"retcode":400,"retmsg":"unknown act"}`))
+		return
+	}
+
+	w.Write([]byte(`{"retcode":200,"retmsg":"ok"}`))
+}
+
+// NotificationStream SSE 推送，登录用户接收未读提醒数，带 aid 时接收该文章的新评论
+func (h *BaseHandler) NotificationStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"retcode":500,"retmsg":"streaming unsupported"}`))
+		return
+	}
+
+	var topics []string
+	if aid := r.FormValue("aid"); len(aid) > 0 {
+		_, err := strconv.ParseUint(aid, 10, 64)
+		if err != nil {
+			w.Write([]byte(`{"retcode":400,"retmsg":"aid type err"}`))
+			return
+		}
+		topics = append(topics, "article:"+aid)
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 && len(topics) == 0 {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored require"}`))
+		return
+	}
+
+	sub := h.App.Hub.Subscribe(currentUser.ID, topics)
+	defer h.App.Hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 10000\n\n")
+	if currentUser.ID > 0 {
+		fmt.Fprintf(w, "event: notice\ndata: {\"num\":%d}\n\n", currentUser.NoticeNum)
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				// 服务关闭
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Name, ev.Data)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
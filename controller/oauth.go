@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/lib/oauth"
+	"github.com/missdeer/kani/model"
+	"github.com/missdeer/kani/util"
+	"goji.io/pat"
+)
+
+// 第三方登录，各登录方式见 system.Application.initOAuth
+// 跳转前把 provider 和 oauth.AuthParams 存到 OAuthState cookie，回调时取出校验
+
+type oauthState struct {
+	Provider string `json:"provider"`
+	oauth.AuthParams
+}
+
+// oauthRedirectURL 回调地址，微博沿用旧的 /oauth/wb/callback，免得重新设置应用
+func (h *BaseHandler) oauthRedirectURL(name string) string {
+	if name == "weibo" {
+		name = "wb"
+	}
+	return h.App.Cf.Site.MainDomain + "/oauth/" + name + "/callback"
+}
+
+func (h *BaseHandler) OAuthLogin(w http.ResponseWriter, r *http.Request) {
+	h.oauthLogin(w, r, pat.Param(r, "provider"))
+}
+
+func (h *BaseHandler) OAuthCallback(w http.ResponseWriter, r *http.Request) {
+	h.oauthCallback(w, r, pat.Param(r, "provider"))
+}
+
+func (h *BaseHandler) QQOauthHandler(w http.ResponseWriter, r *http.Request) {
+	h.oauthLogin(w, r, "qq")
+}
+
+func (h *BaseHandler) QQOauthCallback(w http.ResponseWriter, r *http.Request) {
+	h.oauthCallback(w, r, "qq")
+}
+
+func (h *BaseHandler) WeiboOauthHandler(w http.ResponseWriter, r *http.Request) {
+	h.oauthLogin(w, r, "weibo")
+}
+
+func (h *BaseHandler) WeiboOauthCallback(w http.ResponseWriter, r *http.Request) {
+	h.oauthCallback(w, r, "weibo")
+}
+
+func (h *BaseHandler) oauthLogin(w http.ResponseWriter, r *http.Request, name string) {
+	p, ok := h.App.OAuth[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`provider not found`))
+		return
+	}
+
+	ap, err := oauth.NewAuthParams()
+	if err != nil {
+		w.Write([]byte(err.Error()))
+		return
+	}
+	urlStr, err := p.AuthCodeURL(h.oauthRedirectURL(name), ap)
+	if err != nil {
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	jb, _ := json.Marshal(oauthState{Provider: name, AuthParams: ap})
+	h.SetCookie(w, "OAuthState", string(jb), 1)
+	http.Redirect(w, r, urlStr, http.StatusSeeOther)
+}
+
+func (h *BaseHandler) oauthCallback(w http.ResponseWriter, r *http.Request, name string) {
+	p, ok := h.App.OAuth[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`provider not found`))
+		return
+	}
+
+	var st oauthState
+	if err := json.Unmarshal([]byte(h.GetCookie(r, "OAuthState")), &st); err != nil || len(st.State) == 0 {
+		w.Write([]byte(`OAuthState cookie missed`))
+		return
+	}
+	h.DelCookie(w, "OAuthState")
+	if st.Provider != name || r.FormValue("state") != st.State {
+		w.Write([]byte("Invalid state"))
+		return
+	}
+
+	code := r.FormValue("code")
+	if code == "" {
+		w.Write([]byte("Invalid code"))
+		return
+	}
+
+	ident, err := p.Exchange(h.oauthRedirectURL(name), code, st.AuthParams)
+	if err != nil {
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	timeStamp := uint64(time.Now().UTC().Unix())
+	db := h.App.Db
+	currentUser, _ := h.CurrentUser(w, r)
+
+	if obj, ok := model.OAuthIdentityGet(db, name, ident.ID); ok {
+		if currentUser.ID > 0 {
+			// 已登录，帐号已经绑定过
+			if obj.Uid != currentUser.ID {
+				w.Write([]byte(`该` + p.Title() + `帐号已绑定其它用户`))
+				return
+			}
+			http.Redirect(w, r, "/setting", http.StatusSeeOther)
+			return
+		}
+		// login
+		uobj, err := model.UserGetByID(db, obj.Uid)
+		if err != nil {
+			w.Write([]byte(err.Error()))
+			return
+		}
+		uobj.LastLoginTime = timeStamp
+		jb, _ := json.Marshal(uobj)
+		db.Hset("user", youdb.I2b(uobj.ID), jb)
+		if !h.LoginStart(w, r, uobj) {
+			http.Redirect(w, r, "/login/totp", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if currentUser.ID > 0 {
+		// 已登录，绑定到当前用户
+		model.OAuthIdentitySet(db, model.OAuthIdentity{
+			Uid:      currentUser.ID,
+			Provider: name,
+			Name:     ident.Name,
+			Openid:   ident.ID,
+		})
+		http.Redirect(w, r, "/setting", http.StatusSeeOther)
+		return
+	}
+
+	// register
+
+	siteCf := h.App.Cf.Site
+	if siteCf.CloseReg {
+		w.Write([]byte(`{"retcode":400,"retmsg":"stop to new register"}`))
+		return
+	}
+
+	userName := util.RemoveCharacter(ident.Name)
+	userName = strings.TrimSpace(strings.Replace(userName, " ", "", -1))
+	if len(userName) == 0 {
+		userName = name
+	}
+	var nameLow string
+	i := 1
+	for {
+		nameLow = strings.ToLower(userName)
+		if db.Hget("user_name2uid", []byte(nameLow)).State == "ok" {
+			userName = userName + strconv.Itoa(i)
+		} else {
+			break
+		}
+		i++
+	}
+
+	userId, _ := db.HnextSequence("user")
+	flag := 5
+	if siteCf.RegReview {
+		flag = 1
+	}
+	if userId == 1 {
+		flag = 99
+	}
+
+	uobj := model.User{
+		ID:            userId,
+		Name:          userName,
+		About:         ident.About,
+		URL:           ident.URL,
+		Gender:        ident.Gender,
+		Flag:          flag,
+		RegTime:       timeStamp,
+		LastLoginTime: timeStamp,
+	}
+
+	uidStr := strconv.FormatUint(userId, 10)
+	savePath := "static/avatar/" + uidStr + ".jpg"
+	err = util.FetchAvatar(ident.Avatar, savePath, r.UserAgent())
+	if err != nil {
+		err = util.GenerateAvatar(ident.Gender, userName, 73, 73, savePath)
+	}
+	if err != nil {
+		uobj.Avatar = "0"
+	} else {
+		uobj.Avatar = uidStr
+	}
+
+	jb, _ := json.Marshal(uobj)
+	db.Hset("user", youdb.I2b(uobj.ID), jb)
+	db.Hset("user_name2uid", []byte(nameLow), youdb.I2b(userId))
+	db.Hset("user_flag:"+strconv.Itoa(flag), youdb.I2b(uobj.ID), []byte(""))
+
+	model.OAuthIdentitySet(db, model.OAuthIdentity{
+		Uid:      userId,
+		Provider: name,
+		Name:     userName,
+		Openid:   ident.ID,
+	})
+
+	h.LoginSession(w, r, uobj.ID)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
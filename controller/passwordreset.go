@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/missdeer/kani/model"
+	"github.com/missdeer/kani/system"
+	"github.com/missdeer/kani/util"
+	"github.com/rs/xid"
+	"goji.io/pat"
+)
+
+func (h *BaseHandler) UserForgot(w http.ResponseWriter, r *http.Request) {
+	type pageData struct {
+		PageData
+		Token string
+		Msg   string
+	}
+
+	tpl := h.CurrentTpl(r)
+	evn := &pageData{}
+	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
+	evn.Title = "找回密码"
+	evn.IsMobile = tpl == "mobile"
+	evn.CurrentUser, _ = h.CurrentUser(w, r)
+
+	evn.ShowSideAd = true
+	evn.PageName = "user_forgot"
+
+	if h.App.Mailer == nil {
+		evn.Msg = "本站暂未开通邮件发送，请联系管理员重置密码"
+	}
+
+	h.SetCookie(w, "token", xid.New().String(), 1)
+	h.Render(w, tpl, evn, "layout.html", "forgot.html")
+}
+
+// UserForgotPost 按用户名或已验证的邮箱发送重置邮件，结果不区分账号是否存在
+func (h *BaseHandler) UserForgotPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	token := h.GetCookie(r, "token")
+	if len(token) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"token cookie missed"}`))
+		return
+	}
+
+	type recForm struct {
+		Name string `json:"name"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var rec recForm
+	err := decoder.Decode(&rec)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"json Decode err:` + err.Error() + `"}`))
+		return
+	}
+	defer r.Body.Close()
+
+	name := strings.TrimSpace(rec.Name)
+	if len(name) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
+		return
+	}
+	if h.App.Mailer == nil {
+		w.Write([]byte(`{"retcode":500,"retmsg":"本站暂未开通邮件发送"}`))
+		return
+	}
+
+	db := h.App.Db
+	scf := h.App.Cf.Site
+
+	var uobj model.User
+	if strings.Contains(name, "@") {
+		if uid := model.EmailVerifiedUID(db, name); uid > 0 {
+			uobj, err = model.UserGetByID(db, uid)
+		}
+	} else {
+		uobj, err = model.UserGetByName(db, strings.ToLower(name))
+	}
+
+	if err == nil && uobj.ID > 0 && len(uobj.Password) > 0 && model.UserEmailSendable(uobj) {
+		resetToken, err := model.PasswordResetNew(db, uobj.ID, scf.PasswordResetExpire*60, scf.EmailVerifyInterval)
+		if err == nil {
+			err = h.App.SendMail(uobj.Email, "["+scf.Name+"] 重置密码", "resetpassword.html", system.MailData{
+				SiteCf: scf,
+				Name:   uobj.Name,
+				URL:    scf.MainDomain + "/reset/" + resetToken,
+			}, nil)
+		}
+		if err != nil {
+			log.Println("send reset password mail err", uobj.ID, err)
+		}
+	}
+
+	w.Write([]byte(`{"retcode":200,"retmsg":"如果账号存在且邮箱已验证，重置邮件已发送，请查收"}`))
+}
+
+func (h *BaseHandler) UserReset(w http.ResponseWriter, r *http.Request) {
+	type pageData struct {
+		PageData
+		Uobj       model.User
+		ResetToken string
+		Msg        string
+	}
+
+	tpl := h.CurrentTpl(r)
+	evn := &pageData{}
+	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
+	evn.Title = "重置密码"
+	evn.IsMobile = tpl == "mobile"
+	evn.CurrentUser, _ = h.CurrentUser(w, r)
+
+	evn.ShowSideAd = true
+	evn.PageName = "user_reset"
+
+	resetToken := pat.Param(r, "token")
+	uobj, err := model.PasswordResetGet(h.App.Db, resetToken)
+	if err != nil {
+		evn.Msg = err.Error()
+	} else {
+		evn.Uobj = uobj
+		evn.ResetToken = resetToken
+	}
+
+	h.SetCookie(w, "token", xid.New().String(), 1)
+	h.Render(w, tpl, evn, "layout.html", "reset.html")
+}
+
+// UserResetPost 设置新密码，token 作废，并让已登录的会话失效
+func (h *BaseHandler) UserResetPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	token := h.GetCookie(r, "token")
+	if len(token) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"token cookie missed"}`))
+		return
+	}
+
+	type recForm struct {
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var rec recForm
+	err := decoder.Decode(&rec)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"json Decode err:` + err.Error() + `"}`))
+		return
+	}
+	defer r.Body.Close()
+
+	if len(rec.Password) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
+		return
+	}
+
+	db := h.App.Db
+	uobj, err := model.PasswordResetUse(db, pat.Param(r, "token"))
+	if err != nil {
+		json.NewEncoder(w).Encode(normalRsp{400, err.Error()})
+		return
+	}
+
+	uobj.Password, err = util.PasswordHash(rec.Password)
+	if err != nil {
+		w.Write([]byte(`{"retcode":500,"retmsg":"password hash err"}`))
+		return
+	}
+	uobj.Session = ""
+	model.UserUpdate(db, uobj)
+	model.SessionDelByUser(db, uobj.ID, "")
+
+	h.DelCookie(w, "SessionID")
+	h.DelCookie(w, "token")
+
+	w.Write([]byte(`{"retcode":200,"retmsg":"密码已重置，请重新登录"}`))
+}
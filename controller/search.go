@@ -1,7 +1,9 @@
 package controller
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/missdeer/kani/model"
@@ -14,36 +16,51 @@ func (h *BaseHandler) SearchDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	q := r.FormValue("q")
+	q, btn, key, score := r.FormValue("q"), r.FormValue("btn"), r.FormValue("key"), r.FormValue("score")
+	if len(key) > 0 {
+		_, err := strconv.ParseUint(key, 10, 64)
+		if err != nil {
+			w.Write([]byte(`{"retcode":400,"retmsg":"key type err"}`))
+			return
+		}
+	}
+	if len(score) > 0 {
+		_, err := strconv.ParseUint(score, 10, 64)
+		if err != nil {
+			w.Write([]byte(`{"retcode":400,"retmsg":"score type err"}`))
+			return
+		}
+	}
 
 	if len(q) == 0 {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	qLow := strings.ToLower(q)
+	qLow := strings.TrimSpace(strings.ToLower(q))
+
+	cmd := "next"
+	if btn == "prev" {
+		cmd = "prev"
+	}
 
 	db := h.App.Db
 	scf := h.App.Cf.Site
 
-	where := "title"
-	if strings.HasPrefix(qLow, "c:") {
-		where = "content"
-		qLow = qLow[2:]
-	}
-
-	pageInfo := model.ArticleSearchList(db, where, qLow, scf.PageShowNum, scf.TimeZone)
+	pageInfo, truncated := model.ArticleSearchList(db, cmd, qLow, key, score, scf.PageShowNum, scf.TimeZone)
 
 	type pageData struct {
 		PageData
-		Q        string
-		PageInfo model.ArticlePageInfo
+		Q         string
+		PageInfo  model.ArticlePageInfo
+		Truncated bool
 	}
 
 	tpl := h.CurrentTpl(r)
 
 	evn := &pageData{}
 	evn.SiteCf = scf
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = qLow + " - " + scf.Name
 	evn.IsMobile = tpl == "mobile"
 
@@ -55,6 +72,56 @@ func (h *BaseHandler) SearchDetail(w http.ResponseWriter, r *http.Request) {
 
 	evn.Q = qLow
 	evn.PageInfo = pageInfo
+	evn.Truncated = truncated
 
 	h.Render(w, tpl, evn, "layout.html", "search.html")
 }
+
+// AdminSearchRebuildPost 在后台重建全文索引，完成后给管理员发站内提醒
+func (h *BaseHandler) AdminSearchRebuildPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	token := h.GetCookie(r, "token")
+	if len(token) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"token cookie missed"}`))
+		return
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored err"}`))
+		return
+	}
+	if currentUser.Flag < 99 {
+		w.Write([]byte(`{"retcode":403,"retmsg":"flag forbidden"}`))
+		return
+	}
+
+	if model.SearchRebuildRunning() {
+		w.Write([]byte(`{"retcode":400,"retmsg":"正在重建索引，请稍后"}`))
+		return
+	}
+
+	db := h.App.Db
+	model.AdminAuditAdd(db, model.AdminAudit{
+		AdminUID: currentUser.ID,
+		Act:      "search_rebuild",
+		Content:  "重建全文索引",
+		IP:       h.ClientIP(r),
+	})
+	go func(uid uint64) {
+		num := model.SearchRebuildIndex(db)
+		if num < 0 {
+			return
+		}
+		h.notify(model.Notification{
+			UID:     uid,
+			Type:    model.NotificationSystem,
+			Content: "全文索引已重建，共 " + strconv.Itoa(num) + " 篇文章",
+		})
+	}(currentUser.ID)
+
+	rsp := normalRsp{}
+	rsp.Retcode = 200
+	rsp.Retmsg = "已开始重建索引，完成后会收到提醒"
+	json.NewEncoder(w).Encode(rsp)
+}
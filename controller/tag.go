@@ -53,6 +53,7 @@ func (h *BaseHandler) TagDetail(w http.ResponseWriter, r *http.Request) {
 
 	evn := &pageData{}
 	evn.SiteCf = scf
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = tag + " - " + scf.Name
 	evn.Keywords = tag
 	evn.Description = tag
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/missdeer/kani/model"
+	"github.com/rs/xid"
+)
+
+// 两步验证登录：密码（或 QQ、微博）验证通过后，开启了两步验证的用户
+// 拿到 TotpLogin cookie，跳到 /login/totp 输入验证码或恢复码后才建立会话
+
+const (
+	totpLoginExpire   = 300 // 输入验证码的时限（秒）
+	totpLoginAttempts = 5
+	totpRecoveryNum   = 10
+)
+
+// LoginStart 第一步验证通过后调用，不需要两步验证时直接登录并返回 true
+func (h *BaseHandler) LoginStart(w http.ResponseWriter, r *http.Request, uobj model.User) bool {
+	if !uobj.TotpEnabled {
+		h.LoginSession(w, r, uobj.ID)
+		return true
+	}
+	token, err := model.TotpLoginNew(h.App.Db, uobj.ID, totpLoginExpire)
+	if err == nil {
+		h.SetCookie(w, "TotpLogin", token, 1)
+	}
+	return false
+}
+
+func (h *BaseHandler) UserLoginTotp(w http.ResponseWriter, r *http.Request) {
+	if _, err := model.TotpLoginGet(h.App.Db, h.GetCookie(r, "TotpLogin")); err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	type pageData struct {
+		PageData
+	}
+
+	tpl := h.CurrentTpl(r)
+	evn := &pageData{}
+	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
+	evn.Title = "两步验证"
+	evn.Keywords = ""
+	evn.Description = ""
+	evn.IsMobile = tpl == "mobile"
+
+	evn.ShowSideAd = true
+	evn.PageName = "user_login_totp"
+
+	h.SetCookie(w, "token", xid.New().String(), 1)
+	h.Render(w, tpl, evn, "layout.html", "userlogintotp.html")
+}
+
+func (h *BaseHandler) UserLoginTotpPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	token := h.GetCookie(r, "token")
+	if len(token) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"token cookie missed"}`))
+		return
+	}
+
+	type recForm struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var rec recForm
+	err := decoder.Decode(&rec)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"json Decode err:` + err.Error() + `"}`))
+		return
+	}
+	defer r.Body.Close()
+
+	if len(rec.Code) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
+		return
+	}
+
+	db := h.App.Db
+	loginToken := h.GetCookie(r, "TotpLogin")
+	lobj, err := model.TotpLoginGet(db, loginToken)
+	if err != nil {
+		json.NewEncoder(w).Encode(normalRsp{401, err.Error()})
+		return
+	}
+	uobj, err := model.UserGetByID(db, lobj.UID)
+	if err != nil {
+		model.TotpLoginDel(db, loginToken)
+		w.Write([]byte(`{"retcode":404,"retmsg":"user not found"}`))
+		return
+	}
+
+	// 验证码错误和密码错误一样计入登录失败，免得反复走密码这一步来穷举验证码
+	ipKey, userKey := model.LoginFailKeyIP(h.ClientIP(r)), model.LoginFailKeyUser(uobj.Name)
+	if remain := model.LoginLocked(db, ipKey, userKey); remain > 0 {
+		model.TotpLoginDel(db, loginToken)
+		json.NewEncoder(w).Encode(normalRsp{429, loginLockMsg(remain)})
+		return
+	}
+
+	if uobj.TotpEnabled {
+		ok, recovery := model.TotpVerify(db, &uobj, rec.Code)
+		if !ok {
+			if lock := h.loginFail(ipKey, userKey); lock > 0 {
+				model.TotpLoginDel(db, loginToken)
+				json.NewEncoder(w).Encode(normalRsp{429, loginLockMsg(lock)})
+				return
+			}
+			err = model.TotpLoginFail(db, loginToken, lobj, totpLoginAttempts)
+			json.NewEncoder(w).Encode(normalRsp{400, err.Error()})
+			return
+		}
+		if recovery {
+			h.notify(model.Notification{
+				UID:     uobj.ID,
+				Type:    model.NotificationSystem,
+				Content: "你的帐号用恢复码登录了一次，还剩 " + strconv.Itoa(model.TotpRecoveryCount(db, uobj.ID)) + " 个恢复码",
+			})
+		}
+	}
+
+	model.LoginFailClear(db, userKey)
+	model.TotpLoginDel(db, loginToken)
+	h.DelCookie(w, "TotpLogin")
+	h.DelCookie(w, "token")
+	h.LoginSession(w, r, uobj.ID)
+
+	rsp := normalRsp{}
+	rsp.Retcode = 200
+	json.NewEncoder(w).Encode(rsp)
+}
+
+// AdminTotpMiddleware 开启 AdminRequireTotp 后，没有开启两步验证的管理员不能进入后台
+func (h *BaseHandler) AdminTotpMiddleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if h.App.Cf.Site.AdminRequireTotp && strings.HasPrefix(r.URL.Path, "/admin/") {
+			currentUser, _ := h.CurrentUser(w, r)
+			if currentUser.Flag >= 99 && !currentUser.TotpEnabled {
+				if r.Method == "GET" {
+					http.Redirect(w, r, "/setting#6", http.StatusSeeOther)
+				} else {
+					w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+					w.Write([]byte(`{"retcode":403,"retmsg":"管理员需要先开启两步验证"}`))
+				}
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
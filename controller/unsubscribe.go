@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/missdeer/kani/model"
+)
+
+// 邮件里的退订链接，不需要登录
+// GET 只显示确认页面，免得邮件安全扫描之类预先访问链接就把提醒关掉了；
+// POST 才真正退订，包括邮件客户端按 RFC 8058 发来的一键退订（List-Unsubscribe=One-Click）
+
+func (h *BaseHandler) EmailUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	h.emailUnsubscribe(w, r, false)
+}
+
+func (h *BaseHandler) EmailUnsubscribePost(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("List-Unsubscribe") == "One-Click" {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		uid, err := strconv.ParseUint(r.FormValue("uid"), 10, 64)
+		if err != nil {
+			w.Write([]byte(`{"retcode":400,"retmsg":"uid type err"}`))
+			return
+		}
+		_, err = model.EmailUnsubscribe(h.App.Db, uid, r.FormValue("type"), r.FormValue("sign"))
+		if err != nil {
+			w.Write([]byte(`{"retcode":403,"retmsg":"` + err.Error() + `"}`))
+			return
+		}
+		w.Write([]byte(`{"retcode":200,"retmsg":"ok"}`))
+		return
+	}
+	h.emailUnsubscribe(w, r, true)
+}
+
+func (h *BaseHandler) emailUnsubscribe(w http.ResponseWriter, r *http.Request, confirm bool) {
+	uid, err := strconv.ParseUint(r.FormValue("uid"), 10, 64)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"uid type err"}`))
+		return
+	}
+	typ, sign := r.FormValue("type"), r.FormValue("sign")
+
+	db := h.App.Db
+	scf := h.App.Cf.Site
+
+	type pageData struct {
+		PageData
+		Uobj  model.User
+		UID   uint64
+		Type  string
+		Sign  string
+		Done  bool
+		Error string
+	}
+
+	tpl := h.CurrentTpl(r)
+	evn := &pageData{}
+	evn.SiteCf = scf
+	evn.CsrfToken = h.CsrfToken(r)
+	evn.Title = "退订邮件提醒 - " + scf.Name
+	evn.IsMobile = tpl == "mobile"
+	evn.CurrentUser, _ = h.CurrentUser(w, r)
+	evn.ShowSideAd = true
+	evn.PageName = "unsubscribe"
+
+	evn.UID = uid
+	evn.Type = typ
+	evn.Sign = sign
+	if confirm {
+		evn.Uobj, err = model.EmailUnsubscribe(db, uid, typ, sign)
+		evn.Done = err == nil
+	} else {
+		evn.Uobj, err = model.EmailUnsubscribeCheck(db, uid, typ, sign)
+	}
+	if err != nil {
+		evn.Error = "退订链接无效"
+	}
+
+	h.Render(w, tpl, evn, "layout.html", "unsubscribe.html")
+}
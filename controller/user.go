@@ -1,10 +1,7 @@
 package controller
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -20,8 +17,9 @@ import (
 func (h *BaseHandler) UserLogin(w http.ResponseWriter, r *http.Request) {
 	type pageData struct {
 		PageData
-		Act   string
-		Token string
+		Act     string
+		Token   string
+		Captcha string // 验证码 ID，为空时不需要
 	}
 	act := strings.TrimLeft(r.RequestURI, "/")
 	title := "登录"
@@ -32,6 +30,7 @@ func (h *BaseHandler) UserLogin(w http.ResponseWriter, r *http.Request) {
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = title
 	evn.Keywords = ""
 	evn.Description = ""
@@ -41,6 +40,11 @@ func (h *BaseHandler) UserLogin(w http.ResponseWriter, r *http.Request) {
 	evn.PageName = "user_login_register"
 
 	evn.Act = act
+	if act == "login" && h.loginCaptchaRequired(model.LoginFailKeyIP(h.ClientIP(r))) {
+		evn.Captcha, _ = model.CaptchaNew()
+	} else if act == "register" {
+		evn.Captcha = h.captchaNew(captchaActRegister, model.User{})
+	}
 
 	token := h.GetCookie(r, "token")
 	if len(token) == 0 {
@@ -63,12 +67,15 @@ func (h *BaseHandler) UserLoginPost(w http.ResponseWriter, r *http.Request) {
 	act := strings.TrimLeft(r.RequestURI, "/")
 
 	type recForm struct {
-		Name     string `json:"name"`
-		Password string `json:"password"`
+		Name      string `json:"name"`
+		Password  string `json:"password"`
+		CaptchaID string `json:"captchaid"`
+		Captcha   string `json:"captcha"`
 	}
 
 	type response struct {
 		normalRsp
+		Captcha string `json:"captcha,omitempty"` // 需要输入验证码时的验证码 ID
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -92,34 +99,55 @@ func (h *BaseHandler) UserLoginPost(w http.ResponseWriter, r *http.Request) {
 	db := h.App.Db
 	timeStamp := uint64(time.Now().UTC().Unix())
 
-	hash := sha256.New()
-	hash.Write([]byte(fmt.Sprintf("%s%d%s%d", rec.Name, len(rec.Name), rec.Password, len(rec.Password))))
-	pw := hex.EncodeToString(hash.Sum(nil))
-
 	if act == "login" {
-		bn := "user_login_token"
-		key := []byte(token + ":loginerr")
-		if db.Zget(bn, key).State == "ok" {
-			// todo
-			//w.Write([]byte(`{"retcode":400,"retmsg":"name and pw not match"}`))
-			//return
+		ipKey, userKey := model.LoginFailKeyIP(h.ClientIP(r)), model.LoginFailKeyUser(nameLow)
+		if remain := model.LoginLocked(db, ipKey, userKey); remain > 0 {
+			json.NewEncoder(w).Encode(normalRsp{429, loginLockMsg(remain)})
+			return
 		}
-		uobj, err := model.UserGetByName(db, nameLow)
-		if err != nil {
-			w.Write([]byte(`{"retcode":400,"retmsg":"json Decode err:` + err.Error() + `"}`))
+		failRsp := func(retmsg string) {
+			rsp := response{}
+			rsp.Retcode = 400
+			rsp.Retmsg = retmsg
+			if h.loginCaptchaRequired(ipKey, userKey) {
+				rsp.Captcha, _ = model.CaptchaNew()
+			}
+			json.NewEncoder(w).Encode(rsp)
+		}
+		if h.loginCaptchaRequired(ipKey, userKey) && !model.CaptchaCheck(db, rec.CaptchaID, rec.Captcha) {
+			failRsp("验证码不正确")
 			return
 		}
-		if uobj.Password != pw {
-			db.Zset(bn, key, uint64(time.Now().UTC().Unix()))
-			w.Write([]byte(`{"retcode":400,"retmsg":"name and pw not match"}`))
+
+		// 用户不存在也算一次失败，和密码错误返回同样的信息
+		uobj, err := model.UserGetByName(db, nameLow)
+		ok, upgrade := false, false
+		if err == nil {
+			ok, upgrade = util.PasswordCheck(uobj.Password, uobj.Name, rec.Password)
+		}
+		if !ok {
+			if lock := h.loginFail(ipKey, userKey); lock > 0 {
+				json.NewEncoder(w).Encode(normalRsp{429, loginLockMsg(lock)})
+				return
+			}
+			failRsp("name and pw not match")
 			return
 		}
-		sessionid := xid.New().String()
+		if upgrade {
+			// 旧的 sha256 哈希升级
+			if pw, err := util.PasswordHash(rec.Password); err == nil {
+				uobj.Password = pw
+			}
+		}
 		uobj.LastLoginTime = timeStamp
-		uobj.Session = sessionid
 		jb, _ := json.Marshal(uobj)
 		db.Hset("user", youdb.I2b(uobj.ID), jb)
-		h.SetCookie(w, "SessionID", strconv.FormatUint(uobj.ID, 10)+":"+sessionid, 365)
+		if !h.LoginStart(w, r, uobj) {
+			// 还需要输入两步验证码，失败记录等验证码通过后再清除
+			w.Write([]byte(`{"retcode":202,"retmsg":"totp required"}`))
+			return
+		}
+		model.LoginFailClear(db, userKey)
 	} else {
 		// register
 		siteCf := h.App.Cf.Site
@@ -131,8 +159,27 @@ func (h *BaseHandler) UserLoginPost(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte(`{"retcode":400,"retmsg":"stop to new register"}`))
 			return
 		}
+		// 先校验验证码，免得不输验证码就能探测用户名是否已注册
+		captchaRequired := h.captchaRequired(captchaActRegister, model.User{})
+		if captchaRequired && !model.CaptchaCheck(db, rec.CaptchaID, rec.Captcha) {
+			h.captchaFailRsp(w)
+			return
+		}
 		if db.Hget("user_name2uid", []byte(nameLow)).State == "ok" {
-			w.Write([]byte(`{"retcode":400,"retmsg":"name is exist"}`))
+			// 验证码已经用掉了，换一个新的
+			rsp := response{}
+			rsp.Retcode = 400
+			rsp.Retmsg = "name is exist"
+			if captchaRequired {
+				rsp.Captcha, _ = model.CaptchaNew()
+			}
+			json.NewEncoder(w).Encode(rsp)
+			return
+		}
+
+		pw, err := util.PasswordHash(rec.Password)
+		if err != nil {
+			w.Write([]byte(`{"retcode":500,"retmsg":"password hash err"}`))
 			return
 		}
 
@@ -153,7 +200,6 @@ func (h *BaseHandler) UserLoginPost(w http.ResponseWriter, r *http.Request) {
 			Flag:          flag,
 			RegTime:       timeStamp,
 			LastLoginTime: timeStamp,
-			Session:       xid.New().String(),
 		}
 
 		uidStr := strconv.FormatUint(userId, 10)
@@ -169,7 +215,7 @@ func (h *BaseHandler) UserLoginPost(w http.ResponseWriter, r *http.Request) {
 		db.Hset("user_name2uid", []byte(nameLow), youdb.I2b(userId))
 		db.Hset("user_flag:"+strconv.Itoa(flag), youdb.I2b(uobj.ID), []byte(""))
 
-		h.SetCookie(w, "SessionID", strconv.FormatUint(uobj.ID, 10)+":"+uobj.Session, 365)
+		h.LoginSession(w, r, uobj.ID)
 	}
 
 	h.DelCookie(w, "token")
@@ -179,41 +225,12 @@ func (h *BaseHandler) UserLoginPost(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(rsp)
 }
 
-func (h *BaseHandler) UserNotification(w http.ResponseWriter, r *http.Request) {
+func (h *BaseHandler) UserLogout(w http.ResponseWriter, r *http.Request) {
 	currentUser, _ := h.CurrentUser(w, r)
-	if currentUser.ID == 0 {
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
-		return
-	}
-
-	type pageData struct {
-		PageData
-		PageInfo model.ArticlePageInfo
+	if currentUser.ID > 0 {
+		model.SessionDel(h.App.Db, currentUser.ID, h.CurrentSessionID(r))
 	}
-
-	db := h.App.Db
-	scf := h.App.Cf.Site
-
-	tpl := h.CurrentTpl(r)
-
-	evn := &pageData{}
-	evn.SiteCf = scf
-	evn.Title = "站内提醒 - " + scf.Name
-	evn.IsMobile = tpl == "mobile"
-
-	evn.CurrentUser = currentUser
-	evn.ShowSideAd = true
-	evn.PageName = "user_notification"
-	evn.HotNodes = model.CategoryHot(db, scf.CategoryShowNum)
-	evn.NewestNodes = model.CategoryNewest(db, scf.CategoryShowNum)
-
-	evn.PageInfo = model.ArticleNotificationList(db, currentUser.Notice, scf.TimeZone)
-
-	h.Render(w, tpl, evn, "layout.html", "notification.html")
-}
-
-func (h *BaseHandler) UserLogout(w http.ResponseWriter, r *http.Request) {
-	cks := []string{"SessionID", "QQUrlState", "WeiboUrlState", "token"}
+	cks := []string{"SessionID", "OAuthState", "token", "TotpLogin"}
 	for _, k := range cks {
 		h.DelCookie(w, k)
 	}
@@ -298,6 +315,7 @@ func (h *BaseHandler) UserDetail(w http.ResponseWriter, r *http.Request) {
 
 	evn := &pageData{}
 	evn.SiteCf = scf
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = uobj.Name + " - " + scf.Name
 	evn.Keywords = uobj.Name
 	evn.Description = uobj.About
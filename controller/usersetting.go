@@ -2,10 +2,7 @@ package controller
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"strconv"
@@ -26,13 +23,18 @@ func (h *BaseHandler) UserSetting(w http.ResponseWriter, r *http.Request) {
 
 	type pageData struct {
 		PageData
-		Uobj model.User
-		Now  int64
+		Uobj     model.User
+		Now      int64
+		Sessions []model.SessionListItem
+		// 两步验证
+		TotpRecoveryNum int
+		TotpRequired    bool
 	}
 
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = h.App.Cf.Site
+	evn.CsrfToken = h.CsrfToken(r)
 	evn.Title = "设置"
 	evn.Keywords = ""
 	evn.Description = ""
@@ -44,6 +46,9 @@ func (h *BaseHandler) UserSetting(w http.ResponseWriter, r *http.Request) {
 
 	evn.Uobj = currentUser
 	evn.Now = time.Now().UTC().Unix()
+	evn.Sessions = model.SessionListByUser(h.App.Db, currentUser.ID, h.CurrentSessionID(r), h.App.Cf.Site.TimeZone)
+	evn.TotpRecoveryNum = model.TotpRecoveryCount(h.App.Db, currentUser.ID)
+	evn.TotpRequired = h.App.Cf.Site.AdminRequireTotp && currentUser.Flag >= 99
 
 	h.SetCookie(w, "token", xid.New().String(), 1)
 	h.Render(w, tpl, evn, "layout.html", "usersetting.html")
@@ -116,14 +121,18 @@ func (h *BaseHandler) UserSettingPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type recForm struct {
-		Act        string `json:"act"`
-		Email      string `json:"email"`
-		Telephone  string `json:"telephone"`
-		URL        string `json:"url"`
-		About      string `json:"about"`
-		Password0  string `json:"password0"`
-		Password   string `json:"password"`
-		VerifyCode string `json:"verifycode"`
+		Act          string `json:"act"`
+		Email        string `json:"email"`
+		Telephone    string `json:"telephone"`
+		URL          string `json:"url"`
+		About        string `json:"about"`
+		Password0    string `json:"password0"`
+		Password     string `json:"password"`
+		VerifyCode   string `json:"verifycode"`
+		EmailMention string `json:"emailmention"`
+		EmailReply   string `json:"emailreply"`
+		Sid          string `json:"sid"`  // SessionListItem.Key
+		Code         string `json:"code"` // 两步验证码或恢复码
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -141,6 +150,15 @@ func (h *BaseHandler) UserSettingPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	type response struct {
+		normalRsp
+		Secret string   `json:"secret,omitempty"`
+		URI    string   `json:"uri,omitempty"`
+		Qr     string   `json:"qr,omitempty"`
+		Codes  []string `json:"codes,omitempty"`
+	}
+	rsp := response{}
+
 	isChanged := false
 	switch recAct {
 	case "info":
@@ -149,6 +167,7 @@ func (h *BaseHandler) UserSettingPost(w http.ResponseWriter, r *http.Request) {
 			currentUser.TelephoneVerified = false
 		}
 		if currentUser.Email != rec.Email {
+			model.EmailVerifyRelease(h.App.Db, currentUser)
 			currentUser.Email = rec.Email
 			currentUser.EmailVerified = false
 		}
@@ -160,30 +179,127 @@ func (h *BaseHandler) UserSettingPost(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
 			return
 		}
-		hash := sha256.New()
-		hash.Write([]byte(fmt.Sprintf("%s%d%s%d", currentUser.Name, len(currentUser.Name), rec.Password0, len(rec.Password0))))
-		pw := hex.EncodeToString(hash.Sum(nil))
-
-		if currentUser.Password != pw {
+		if ok, _ := util.PasswordCheck(currentUser.Password, currentUser.Name, rec.Password0); !ok {
 			w.Write([]byte(`{"retcode":400,"retmsg":"当前密码不正确"}`))
 			return
 		}
-		hash = sha256.New()
-		hash.Write([]byte(fmt.Sprintf("%s%d%s%d", currentUser.Name, len(currentUser.Name), rec.Password, len(rec.Password))))
-		pw = hex.EncodeToString(hash.Sum(nil))
+		pw, err := util.PasswordHash(rec.Password)
+		if err != nil {
+			w.Write([]byte(`{"retcode":500,"retmsg":"password hash err"}`))
+			return
+		}
 		currentUser.Password = pw
 		isChanged = true
+		// 其它设备需要用新密码重新登录
+		model.SessionDelByUser(h.App.Db, currentUser.ID, h.CurrentSessionID(r))
 	case "set_pw":
 		if len(rec.Password) == 0 {
 			w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
 			return
 		}
-		hash := sha256.New()
-		hash.Write([]byte(fmt.Sprintf("%s%d%s%d", currentUser.Name, len(currentUser.Name), rec.Password, len(rec.Password))))
-		pw := hex.EncodeToString(hash.Sum(nil))
+		pw, err := util.PasswordHash(rec.Password)
+		if err != nil {
+			w.Write([]byte(`{"retcode":500,"retmsg":"password hash err"}`))
+			return
+		}
 		currentUser.Password = pw
 		isChanged = true
+	case "email_notice":
+		modes := map[string]bool{model.EmailModeOff: true, model.EmailModeImmediate: true, model.EmailModeDigest: true}
+		if !modes[rec.EmailMention] || !modes[rec.EmailReply] {
+			w.Write([]byte(`{"retcode":400,"retmsg":"unknown email mode"}`))
+			return
+		}
+		currentUser.EmailMention = rec.EmailMention
+		currentUser.EmailReply = rec.EmailReply
+		isChanged = true
+	case "revoke_session":
+		if len(rec.Sid) == 0 {
+			w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
+			return
+		}
+		if !model.SessionDelByKey(h.App.Db, currentUser.ID, rec.Sid) {
+			w.Write([]byte(`{"retcode":404,"retmsg":"session not found"}`))
+			return
+		}
+	case "revoke_other_sessions":
+		model.SessionDelByUser(h.App.Db, currentUser.ID, h.CurrentSessionID(r))
 	case "verifycode":
+		if len(rec.VerifyCode) == 0 {
+			w.Write([]byte(`{"retcode":400,"retmsg":"missed args"}`))
+			return
+		}
+		if currentUser.TelephoneVerified {
+			w.Write([]byte(`{"retcode":400,"retmsg":"手机号码已验证"}`))
+			return
+		}
+		scf := h.App.Cf.Site
+		err := model.SmsCodeCheck(h.App.Db, currentUser.ID, currentUser.Telephone, rec.VerifyCode, scf.SMSCodeAttempts)
+		if err != nil {
+			json.NewEncoder(w).Encode(normalRsp{400, err.Error()})
+			return
+		}
+		currentUser.TelephoneVerified = true
+		isChanged = true
+	case "totp_setup":
+		// 生成新的密钥，输入一次验证码后才开启
+		if currentUser.TotpEnabled {
+			w.Write([]byte(`{"retcode":400,"retmsg":"已开启两步验证"}`))
+			return
+		}
+		secret, err := util.TotpSecretNew()
+		if err != nil {
+			w.Write([]byte(`{"retcode":500,"retmsg":"totp secret err"}`))
+			return
+		}
+		currentUser.TotpSecret = secret
+		isChanged = true
+		rsp.Secret = secret
+		rsp.URI = util.TotpURI(h.App.Cf.Site.Name, currentUser.Name, secret)
+		rsp.Qr, _ = util.QrDataURI(rsp.URI, 200)
+	case "totp_enable":
+		if currentUser.TotpEnabled || len(currentUser.TotpSecret) == 0 {
+			w.Write([]byte(`{"retcode":400,"retmsg":"请先获取密钥"}`))
+			return
+		}
+		step, ok := util.TotpCheck(currentUser.TotpSecret, rec.Code, time.Now().UTC())
+		if !ok {
+			w.Write([]byte(`{"retcode":400,"retmsg":"验证码不正确，请检查手机时间"}`))
+			return
+		}
+		codes, err := model.TotpRecoveryNew(h.App.Db, currentUser.ID, totpRecoveryNum)
+		if err != nil {
+			w.Write([]byte(`{"retcode":500,"retmsg":"recovery code err"}`))
+			return
+		}
+		currentUser.TotpEnabled = true
+		currentUser.TotpLastStep = step
+		isChanged = true
+		rsp.Codes = codes
+	case "totp_disable", "totp_recovery":
+		if !currentUser.TotpEnabled {
+			w.Write([]byte(`{"retcode":400,"retmsg":"未开启两步验证"}`))
+			return
+		}
+		if recAct == "totp_disable" && h.App.Cf.Site.AdminRequireTotp && currentUser.Flag >= 99 {
+			w.Write([]byte(`{"retcode":403,"retmsg":"管理员必须开启两步验证"}`))
+			return
+		}
+		if ok, _ := model.TotpVerify(h.App.Db, &currentUser, rec.Code); !ok {
+			w.Write([]byte(`{"retcode":400,"retmsg":"验证码不正确"}`))
+			return
+		}
+		if recAct == "totp_disable" {
+			model.TotpDisable(h.App.Db, &currentUser)
+			isChanged = true
+		} else {
+			codes, err := model.TotpRecoveryNew(h.App.Db, currentUser.ID, totpRecoveryNum)
+			if err != nil {
+				w.Write([]byte(`{"retcode":500,"retmsg":"recovery code err"}`))
+				return
+			}
+			rsp.Codes = codes
+		}
 	}
 
 	if isChanged {
@@ -191,11 +307,6 @@ func (h *BaseHandler) UserSettingPost(w http.ResponseWriter, r *http.Request) {
 		h.App.Db.Hset("user", youdb.I2b(currentUser.ID), jb)
 	}
 
-	type response struct {
-		normalRsp
-	}
-
-	rsp := response{}
 	rsp.Retcode = 200
 	rsp.Retmsg = "修改成功"
 	json.NewEncoder(w).Encode(rsp)
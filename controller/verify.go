@@ -1,10 +1,15 @@
 package controller
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
-	"time"
+	"strconv"
 
 	"github.com/missdeer/kani/model"
+	"github.com/missdeer/kani/system"
+	"github.com/missdeer/kani/util"
 	"github.com/rs/xid"
 )
 
@@ -18,54 +23,167 @@ func (h *BaseHandler) UserVerifyEmail(w http.ResponseWriter, r *http.Request) {
 	type pageData struct {
 		PageData
 		Uobj model.User
-		Now  int64
+		Msg  string
 	}
 
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = h.App.Cf.Site
-	evn.Title = "设置"
-	evn.Keywords = ""
-	evn.Description = ""
+	evn.CsrfToken = h.CsrfToken(r)
+	evn.Title = "验证电子邮件"
 	evn.IsMobile = tpl == "mobile"
 	evn.CurrentUser = currentUser
 
 	evn.ShowSideAd = true
-	evn.PageName = "user_setting"
+	evn.PageName = "user_verify_email"
 
 	evn.Uobj = currentUser
-	evn.Now = time.Now().UTC().Unix()
+	if h.App.Mailer == nil {
+		evn.Msg = "本站暂未开通邮件发送"
+	}
 	h.SetCookie(w, "token", xid.New().String(), 1)
-	h.Render(w, tpl, evn, "layout.html", "usersetting.html")
+	h.Render(w, tpl, evn, "layout.html", "verifyemail.html")
 }
 
-func (h *BaseHandler) UserVerifyTelephone(w http.ResponseWriter, r *http.Request) {
+// UserVerifyEmailPost 发送验证邮件
+func (h *BaseHandler) UserVerifyEmailPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	token := h.GetCookie(r, "token")
+	if len(token) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"token cookie missed"}`))
+		return
+	}
+
 	currentUser, _ := h.CurrentUser(w, r)
 	if currentUser.ID == 0 {
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored require"}`))
+		return
+	}
+
+	db := h.App.Db
+	scf := h.App.Cf.Site
+
+	if h.App.Mailer == nil {
+		w.Write([]byte(`{"retcode":500,"retmsg":"本站暂未开通邮件发送"}`))
+		return
+	}
+	if currentUser.EmailVerified {
+		w.Write([]byte(`{"retcode":400,"retmsg":"电子邮件已验证"}`))
+		return
+	}
+	if !util.IsMail(currentUser.Email) {
+		w.Write([]byte(`{"retcode":400,"retmsg":"请先在设置中填写正确的电子邮件地址"}`))
+		return
+	}
+	if uid := model.EmailVerifiedUID(db, currentUser.Email); uid > 0 && uid != currentUser.ID {
+		w.Write([]byte(`{"retcode":400,"retmsg":"该邮箱已被其他账号验证"}`))
+		return
+	}
+	if wait := model.EmailVerifySendCheck(db, currentUser.ID, scf.EmailVerifyInterval); wait > 0 {
+		w.Write([]byte(`{"retcode":403,"retmsg":"发送太频繁，请 ` + strconv.Itoa(wait) + ` 秒后再试"}`))
+		return
+	}
+
+	verifyURL := model.EmailVerifyURL(db, scf.MainDomain, currentUser, scf.EmailVerifyExpire)
+	err := h.App.SendMail(currentUser.Email, "["+scf.Name+"] 验证你的电子邮件地址", "verifyemail.html", system.MailData{
+		SiteCf: scf,
+		Name:   currentUser.Name,
+		URL:    verifyURL,
+	}, nil)
+	if err != nil {
+		w.Write([]byte(`{"retcode":500,"retmsg":"send mail err"}`))
+		return
+	}
+	model.EmailVerifySendSet(db, currentUser.ID)
+
+	w.Write([]byte(`{"retcode":200,"retmsg":"验证邮件已发送，请查收"}`))
+}
+
+// UserVerifyEmailConfirm 邮件里的验证链接，不要求登录
+func (h *BaseHandler) UserVerifyEmailConfirm(w http.ResponseWriter, r *http.Request) {
+	uid, err := strconv.ParseUint(r.FormValue("uid"), 10, 64)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"uid type err"}`))
+		return
+	}
+	expire, err := strconv.ParseInt(r.FormValue("expire"), 10, 64)
+	if err != nil {
+		w.Write([]byte(`{"retcode":400,"retmsg":"expire type err"}`))
 		return
 	}
 
 	type pageData struct {
 		PageData
 		Uobj model.User
-		Now  int64
+		Msg  string
 	}
 
 	tpl := h.CurrentTpl(r)
 	evn := &pageData{}
 	evn.SiteCf = h.App.Cf.Site
-	evn.Title = "设置"
-	evn.Keywords = ""
-	evn.Description = ""
+	evn.CsrfToken = h.CsrfToken(r)
+	evn.Title = "验证电子邮件"
 	evn.IsMobile = tpl == "mobile"
-	evn.CurrentUser = currentUser
+	evn.CurrentUser, _ = h.CurrentUser(w, r)
 
 	evn.ShowSideAd = true
-	evn.PageName = "user_setting"
+	evn.PageName = "user_verify_email"
 
-	evn.Uobj = currentUser
-	evn.Now = time.Now().UTC().Unix()
+	uobj, err := model.EmailVerifyConfirm(h.App.Db, uid, expire, r.FormValue("sign"))
+	if err != nil {
+		evn.Msg = err.Error()
+		evn.Uobj = evn.CurrentUser
+	} else {
+		evn.Uobj = uobj
+		if evn.CurrentUser.ID == uobj.ID {
+			evn.CurrentUser = uobj
+		}
+	}
 	h.SetCookie(w, "token", xid.New().String(), 1)
-	h.Render(w, tpl, evn, "layout.html", "usersetting.html")
+	h.Render(w, tpl, evn, "layout.html", "verifyemail.html")
+}
+
+func (h *BaseHandler) UserVerifyTelephone(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/setting#1", http.StatusSeeOther)
+}
+
+// UserVerifyTelephonePost 给已保存的手机号码发送验证码，在设置页 act=verifycode 校验
+func (h *BaseHandler) UserVerifyTelephonePost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	token := h.GetCookie(r, "token")
+	if len(token) == 0 {
+		w.Write([]byte(`{"retcode":400,"retmsg":"token cookie missed"}`))
+		return
+	}
+
+	currentUser, _ := h.CurrentUser(w, r)
+	if currentUser.ID == 0 {
+		w.Write([]byte(`{"retcode":401,"retmsg":"authored require"}`))
+		return
+	}
+	if currentUser.TelephoneVerified {
+		w.Write([]byte(`{"retcode":400,"retmsg":"手机号码已验证"}`))
+		return
+	}
+	if !util.IsTelephone(currentUser.Telephone) {
+		w.Write([]byte(`{"retcode":400,"retmsg":"请先保存正确的手机号码"}`))
+		return
+	}
+
+	db := h.App.Db
+	scf := h.App.Cf.Site
+
+	code, err := model.SmsCodeNew(db, currentUser.ID, currentUser.Telephone, scf.SMSCodeExpire, scf.SMSCodeInterval)
+	if err != nil {
+		json.NewEncoder(w).Encode(normalRsp{403, err.Error()})
+		return
+	}
+	content := fmt.Sprintf("【%s】你的验证码是 %s，%d 分钟内有效。", scf.Name, code, scf.SMSCodeExpire/60)
+	if err := h.App.Sms.Send(currentUser.Telephone, content); err != nil {
+		log.Println("send sms err", err)
+		w.Write([]byte(`{"retcode":500,"retmsg":"短信发送失败，请稍后再试"}`))
+		return
+	}
+
+	w.Write([]byte(`{"retcode":200,"retmsg":"验证码已发送"}`))
 }
@@ -0,0 +1,74 @@
+package cronjob
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/missdeer/kani/model"
+	"github.com/missdeer/kani/system"
+)
+
+// emailDigest 每天 EmailDigestHour 点后发送一次汇总邮件
+func (h *BaseHandler) emailDigest() {
+	app := h.App
+	if app.Mailer == nil {
+		return
+	}
+	db := app.Db
+	scf := app.Cf.Site
+
+	now := time.Now().UTC()
+	local := now.Add(time.Duration(scf.TimeZone) * time.Hour)
+	if local.Hour() < scf.EmailDigestHour {
+		return
+	}
+	today := local.Format("2006-01-02")
+	rs := db.Hget("keyValue", []byte("email_digest_date"))
+	if rs.State == "ok" && string(rs.Data[0]) == today {
+		return
+	}
+	db.Hset("keyValue", []byte("email_digest_date"), []byte(today))
+
+	num := 0
+	nowUnix := uint64(now.Unix())
+	for uids := model.EmailDigestQueueList(db, 100); len(uids) > 0; uids = model.EmailDigestQueueList(db, 100) {
+		for _, uid := range uids {
+			if h.sendDigest(uid) {
+				num++
+			}
+			model.EmailDigestDone(db, uid, nowUnix)
+		}
+	}
+	if num > 0 {
+		log.Println("email digest sent:", num)
+	}
+}
+
+func (h *BaseHandler) sendDigest(uid uint64) bool {
+	db := h.App.Db
+	scf := h.App.Cf.Site
+
+	uobj, err := model.UserGetByID(db, uid)
+	if err != nil || !model.UserEmailSendable(uobj) {
+		return false
+	}
+	nitems := model.EmailDigestItems(db, uobj, 50)
+	if len(nitems) == 0 {
+		return false
+	}
+
+	unsubscribeURL := model.EmailUnsubscribeURL(db, scf.MainDomain, uobj.ID, model.EmailUnsubscribeDigest)
+	subject := "[" + scf.Name + "] 你有 " + strconv.Itoa(len(nitems)) + " 条未读提醒"
+	err = h.App.SendMail(uobj.Email, subject, "digest.html", system.MailData{
+		SiteCf:         scf,
+		Name:           uobj.Name,
+		Items:          model.NotificationItems(db, nitems, scf.TimeZone),
+		UnsubscribeURL: unsubscribeURL,
+	}, model.EmailUnsubscribeHeader(unsubscribeURL))
+	if err != nil {
+		log.Println("send digest mail err", err)
+		return false
+	}
+	return true
+}
@@ -33,7 +33,6 @@ func (h *BaseHandler) MainCronJob() {
 			scoreStartB := youdb.I2b(timeBefore)
 			zbnList := []string{
 				"article_detail_token",
-				"user_login_token",
 			}
 			for _, bn := range zbnList {
 				rs := db.Zrscan(bn, []byte(""), scoreStartB, limit)
@@ -47,8 +46,21 @@ func (h *BaseHandler) MainCronJob() {
 					db.Zmdel(bn, keys)
 				}
 			}
+			// 清除回收站中过期的文章和评论
+			model.TrashPurgeExpired(db, scf.TrashKeepDays, 100)
+			// 清除两天内没有再失败的登录失败记录
+			model.LoginFailExpire(db, 2*86400, 100)
+			// 清除长期不活动的登录会话
+			model.SessionExpire(db, scf.SessionKeepDays, 100)
+			// 每日汇总邮件
+			h.emailDigest()
 
 		case <-tick2:
+			// 清除过期的短信验证码、找回密码链接、两步验证登录和图片验证码
+			model.SmsCodeExpire(db, 100)
+			model.PasswordResetExpire(db, 100)
+			model.TotpLoginExpire(db, 100)
+			model.CaptchaExpire(db, 100)
 			if scf.AutoGetTag && len(scf.GetTagApi) > 0 {
 				getTagFromTitle(db, scf.GetTagApi)
 			}
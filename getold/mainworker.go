@@ -250,16 +250,12 @@ func (h *BaseHandler) GetLocal() error {
 							db.Hincr("getold_last_tb_id", []byte(tb), 1) // count flag
 							continue
 						}
-						obj := model.QQ{
-							Uid:    youdb.DS2i(t.Uid),
-							Name:   t.Name,
-							Openid: t.Openid,
-						}
-						jb, _ := json.Marshal(obj)
-
-						db.Hset("oauth_qq", []byte(t.Openid), jb)
-						db.HnextSequence("oauth_qq")
-						db.Hset("qq_uid2openid", youdb.I2b(obj.Uid), []byte(t.Openid))
+						model.OAuthIdentitySet(db, model.OAuthIdentity{
+							Uid:      youdb.DS2i(t.Uid),
+							Provider: "qq",
+							Name:     t.Name,
+							Openid:   t.Openid,
+						})
 						db.Hincr("getold_last_tb_id", []byte(tb), 1) // count flag
 					}
 				case tb == "weibo":
@@ -270,16 +266,12 @@ func (h *BaseHandler) GetLocal() error {
 							db.Hincr("getold_last_tb_id", []byte(tb), 1) // count flag
 							continue
 						}
-						obj := model.WeiBo{
-							Uid:    youdb.DS2i(t.Uid),
-							Name:   t.Name,
-							Openid: t.Openid,
-						}
-						jb, _ := json.Marshal(obj)
-
-						db.Hset("oauth_weibo", []byte(t.Openid), jb)
-						db.HnextSequence("oauth_weibo")
-						db.Hset("weibo_uid2openid", youdb.I2b(obj.Uid), []byte(t.Openid))
+						model.OAuthIdentitySet(db, model.OAuthIdentity{
+							Uid:      youdb.DS2i(t.Uid),
+							Provider: "weibo",
+							Name:     t.Name,
+							Openid:   t.Openid,
+						})
 						db.Hincr("getold_last_tb_id", []byte(tb), 1) // count flag
 					}
 				}
@@ -0,0 +1,313 @@
+package oauth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// generic 标准的授权码流程，带 PKCE；设置了 Issuer 时按 OpenID Connect 处理：
+// 自动发现各地址，scope 加上 openid，并校验 id_token。
+// id_token 是服务端直接从 token 地址（HTTPS）取得的，按 OIDC Core 3.1.3.7
+// 可以用 TLS 代替签名校验，这里只检查 iss、aud、exp 和 nonce
+type generic struct {
+	conf Config
+
+	mu          sync.Mutex
+	discovered  bool
+	authURL     string
+	tokenURL    string
+	userInfoURL string
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func newGeneric(conf Config) *generic {
+	return &generic{
+		conf:        conf,
+		authURL:     conf.AuthURL,
+		tokenURL:    conf.TokenURL,
+		userInfoURL: conf.UserInfoURL,
+	}
+}
+
+func (g *generic) Name() string  { return g.conf.Name }
+func (g *generic) Title() string { return g.conf.Title }
+
+func (g *generic) isOIDC() bool {
+	return len(g.conf.Issuer) > 0
+}
+
+// endpoints OIDC 第一次使用时请求 discovery 文档，配置里填了的地址优先
+func (g *generic) endpoints() error {
+	if !g.isOIDC() {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.discovered {
+		return nil
+	}
+
+	issuer := strings.TrimRight(g.conf.Issuer, "/")
+	var doc discovery
+	if err := getJSON(issuer+"/.well-known/openid-configuration", "", &doc); err != nil {
+		return err
+	}
+	if strings.TrimRight(doc.Issuer, "/") != issuer {
+		return fmt.Errorf("issuer mismatch: %s", doc.Issuer)
+	}
+	if len(g.authURL) == 0 {
+		g.authURL = doc.AuthorizationEndpoint
+	}
+	if len(g.tokenURL) == 0 {
+		g.tokenURL = doc.TokenEndpoint
+	}
+	if len(g.userInfoURL) == 0 {
+		g.userInfoURL = doc.UserinfoEndpoint
+	}
+	if len(g.authURL) == 0 || len(g.tokenURL) == 0 {
+		return errors.New("discovery document missing endpoints")
+	}
+	g.discovered = true
+	return nil
+}
+
+func (g *generic) scopes() []string {
+	scopes := g.conf.Scopes
+	if g.isOIDC() {
+		if len(scopes) == 0 {
+			scopes = []string{"profile", "email"}
+		}
+		for _, s := range scopes {
+			if s == "openid" {
+				return scopes
+			}
+		}
+		scopes = append([]string{"openid"}, scopes...)
+	}
+	return scopes
+}
+
+func (g *generic) AuthCodeURL(redirectURL string, p AuthParams) (string, error) {
+	if err := g.endpoints(); err != nil {
+		return "", err
+	}
+	qs := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {g.conf.ClientID},
+		"redirect_uri":          {redirectURL},
+		"state":                 {p.State},
+		"code_challenge":        {pkceChallenge(p.Verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	if scopes := g.scopes(); len(scopes) > 0 {
+		qs.Set("scope", strings.Join(scopes, " "))
+	}
+	if g.isOIDC() {
+		qs.Set("nonce", p.Nonce)
+	}
+	sep := "?"
+	if strings.Contains(g.authURL, "?") {
+		sep = "&"
+	}
+	return g.authURL + sep + qs.Encode(), nil
+}
+
+func (g *generic) Exchange(redirectURL, code string, p AuthParams) (*Identity, error) {
+	if len(code) == 0 {
+		return nil, errors.New("code cannot be empty")
+	}
+	if err := g.endpoints(); err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {g.conf.ClientID},
+		"client_secret": {g.conf.ClientSecret},
+		"code_verifier": {p.Verifier},
+	}
+	req, err := http.NewRequest("POST", g.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var token tokenResponse
+	if err := doJSON(req, &token); err != nil {
+		return nil, err
+	}
+	if len(token.Error) > 0 {
+		return nil, errors.New("token error: " + strings.TrimSpace(token.Error+" "+token.ErrorDescription))
+	}
+	if len(token.AccessToken) == 0 {
+		return nil, errors.New("empty access token")
+	}
+
+	ident := &Identity{}
+	if g.isOIDC() {
+		if len(token.IDToken) == 0 {
+			return nil, errors.New("missing id_token")
+		}
+		claims, err := g.checkIDToken(token.IDToken, p.Nonce)
+		if err != nil {
+			return nil, err
+		}
+		g.fill(ident, claims)
+	}
+
+	if len(g.userInfoURL) > 0 {
+		var info map[string]interface{}
+		if err := getJSON(g.userInfoURL, token.AccessToken, &info); err != nil {
+			return nil, err
+		}
+		sub := ident.ID
+		g.fill(ident, info)
+		if len(sub) > 0 && ident.ID != sub {
+			return nil, errors.New("userinfo sub mismatch")
+		}
+	}
+	if len(ident.ID) == 0 {
+		return nil, errors.New("missing user id")
+	}
+	return ident, nil
+}
+
+// checkIDToken 解出 id_token 的 claims 并校验，见 generic 的说明
+func (g *generic) checkIDToken(idToken, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id_token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, err
+	}
+	claims := map[string]interface{}{}
+	dec := json.NewDecoder(strings.NewReader(string(payload)))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != strings.TrimRight(g.conf.Issuer, "/") {
+		return nil, errors.New("id_token issuer mismatch")
+	}
+	audOK := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audOK = aud == g.conf.ClientID
+	case []interface{}:
+		for _, a := range aud {
+			if s, _ := a.(string); s == g.conf.ClientID {
+				audOK = true
+			}
+		}
+	}
+	if !audOK {
+		return nil, errors.New("id_token audience mismatch")
+	}
+	exp, _ := claims["exp"].(json.Number)
+	if n, err := exp.Int64(); err != nil || n < time.Now().Unix() {
+		return nil, errors.New("id_token expired")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// fill 按字段名从 claims 或用户信息里取值，已有的值被非空的新值覆盖
+func (g *generic) fill(ident *Identity, m map[string]interface{}) {
+	idField := g.conf.IDField
+	if len(idField) == 0 {
+		idField = "id"
+		if g.isOIDC() {
+			idField = "sub"
+		}
+	}
+	set := func(dst *string, fields ...string) {
+		for _, f := range fields {
+			if len(f) == 0 {
+				continue
+			}
+			switch v := m[f].(type) {
+			case string:
+				if len(v) > 0 {
+					*dst = v
+					return
+				}
+			case json.Number:
+				*dst = v.String()
+				return
+			}
+		}
+	}
+	set(&ident.ID, idField)
+	set(&ident.Name, g.conf.NameField, "preferred_username", "nickname", "name", "login")
+	set(&ident.Email, g.conf.EmailField, "email")
+	set(&ident.Avatar, g.conf.AvatarField, "picture", "avatar_url")
+	set(&ident.Gender, "gender")
+	set(&ident.About, "bio")
+	set(&ident.URL, "blog", "website")
+}
+
+func getJSON(u, accessToken string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(accessToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return doJSON(req, v)
+}
+
+func doJSON(req *http.Request, v interface{}) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// token 地址出错时也可能返回 JSON 的 error 字段
+		if json.Unmarshal(body, v) == nil {
+			if t, ok := v.(*tokenResponse); ok && len(t.Error) > 0 {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s %s: status %d", req.Method, req.URL.Path, resp.StatusCode)
+	}
+	dec := json.NewDecoder(strings.NewReader(string(body)))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
@@ -0,0 +1,301 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID    = "client-1"
+	testRedirectURL = "https://kani.example/oauth/test/callback"
+	testAccessToken = "access-1"
+)
+
+// testIDP 模拟的 OpenID Connect 服务，claims 为 token 地址返回的 id_token 内容
+type testIDP struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	issuer    string // discovery 文档里的 issuer，为空时用服务地址
+	challenge string // 授权地址里的 code_challenge，token 地址据此校验 code_verifier
+	claims    map[string]interface{}
+	noIDToken bool
+	userinfo  map[string]interface{}
+}
+
+func newTestIDP(t *testing.T) *testIDP {
+	idp := &testIDP{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/userinfo", idp.userInfo)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *testIDP) discovery(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	issuer := idp.issuer
+	idp.mu.Unlock()
+	if len(issuer) == 0 {
+		issuer = idp.URL
+	}
+	json.NewEncoder(w).Encode(discovery{
+		Issuer:                issuer,
+		AuthorizationEndpoint: idp.URL + "/authorize",
+		TokenEndpoint:         idp.URL + "/token",
+		UserinfoEndpoint:      idp.URL + "/userinfo",
+	})
+}
+
+func (idp *testIDP) token(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	if r.Method != "POST" {
+		http.Error(w, "method", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code",
+		r.PostForm.Get("code") != "code-1",
+		r.PostForm.Get("client_id") != testClientID,
+		r.PostForm.Get("redirect_uri") != testRedirectURL:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_request"}`))
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant","error_description":"pkce"}`))
+		return
+	}
+	rsp := tokenResponse{AccessToken: testAccessToken, TokenType: "Bearer"}
+	if !idp.noIDToken {
+		rsp.IDToken = testIDToken(idp.claims)
+	}
+	json.NewEncoder(w).Encode(rsp)
+}
+
+func (idp *testIDP) userInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+testAccessToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	json.NewEncoder(w).Encode(idp.userinfo)
+}
+
+// testIDToken 不签名的 JWT，generic 不校验签名
+func testIDToken(claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	return enc(map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + enc(claims) + ".c2ln"
+}
+
+// authorize 生成授权地址并记下 code_challenge，相当于浏览器跳到了授权页面
+func (idp *testIDP) authorize(t *testing.T, p Provider, ap AuthParams) url.Values {
+	t.Helper()
+	u, err := p.AuthCodeURL(testRedirectURL, ap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u, idp.URL+"/authorize?") {
+		t.Fatalf("auth url %s not on %s", u, idp.URL)
+	}
+	qs := pu.Query()
+	idp.mu.Lock()
+	idp.challenge = qs.Get("code_challenge")
+	idp.mu.Unlock()
+	return qs
+}
+
+func newTestOIDC(t *testing.T, idp *testIDP) Provider {
+	t.Helper()
+	p, err := New(Config{Name: "test", Type: "oidc", ClientID: testClientID, ClientSecret: "secret", Issuer: idp.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestGenericAuthCodeURL(t *testing.T) {
+	idp := newTestIDP(t)
+	p := newTestOIDC(t, idp)
+	ap, err := NewAuthParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	qs := idp.authorize(t, p, ap)
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"state":                 ap.State,
+		"nonce":                 ap.Nonce,
+		"scope":                 "openid profile email",
+		"code_challenge":        pkceChallenge(ap.Verifier),
+		"code_challenge_method": "S256",
+	}
+	for k, v := range want {
+		if got := qs.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if qs.Get("code_challenge") == ap.Verifier {
+		t.Error("code_challenge leaks the verifier")
+	}
+}
+
+func TestGenericDiscovery(t *testing.T) {
+	tests := []struct {
+		name   string
+		issuer string
+		ok     bool
+	}{
+		{"same issuer", "", true},
+		{"trailing slash", "/", true},
+		{"other issuer", "https://evil.example", false},
+	}
+	for _, tt := range tests {
+		idp := newTestIDP(t)
+		if tt.issuer == "/" {
+			idp.issuer = idp.URL + "/"
+		} else {
+			idp.issuer = tt.issuer
+		}
+		_, err := newTestOIDC(t, idp).AuthCodeURL(testRedirectURL, AuthParams{State: "s", Nonce: "n", Verifier: "v"})
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: AuthCodeURL err %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+
+	// 配置里填了的地址优先于 discovery 文档
+	idp := newTestIDP(t)
+	p, _ := New(Config{Name: "test", Type: "oidc", ClientID: testClientID, Issuer: idp.URL, AuthURL: "https://login.example/auth?x=1"})
+	u, err := p.AuthCodeURL(testRedirectURL, AuthParams{State: "s"})
+	if err != nil || !strings.HasPrefix(u, "https://login.example/auth?x=1&") {
+		t.Errorf("configured AuthURL not used: %s %v", u, err)
+	}
+}
+
+func TestGenericExchange(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name      string
+		claims    func(c map[string]interface{}, issuer string)
+		noIDToken bool
+		userinfo  map[string]interface{}
+		verifier  string // 不为空时代替正确的 code_verifier
+		err       string
+	}{
+		{name: "ok"},
+		{name: "aud list", claims: func(c map[string]interface{}, _ string) { c["aud"] = []string{"other", testClientID} }},
+		{name: "issuer with slash", claims: func(c map[string]interface{}, iss string) { c["iss"] = iss + "/" }},
+		{name: "wrong iss", claims: func(c map[string]interface{}, _ string) { c["iss"] = "https://evil.example" }, err: "issuer"},
+		{name: "missing iss", claims: func(c map[string]interface{}, _ string) { delete(c, "iss") }, err: "issuer"},
+		{name: "wrong aud", claims: func(c map[string]interface{}, _ string) { c["aud"] = "other" }, err: "audience"},
+		{name: "wrong aud list", claims: func(c map[string]interface{}, _ string) { c["aud"] = []string{"other"} }, err: "audience"},
+		{name: "expired", claims: func(c map[string]interface{}, _ string) { c["exp"] = now - 10 }, err: "expired"},
+		{name: "missing exp", claims: func(c map[string]interface{}, _ string) { delete(c, "exp") }, err: "expired"},
+		{name: "wrong nonce", claims: func(c map[string]interface{}, _ string) { c["nonce"] = "other" }, err: "nonce"},
+		{name: "missing nonce", claims: func(c map[string]interface{}, _ string) { delete(c, "nonce") }, err: "nonce"},
+		{name: "missing id_token", noIDToken: true, err: "id_token"},
+		{name: "userinfo sub mismatch", userinfo: map[string]interface{}{"sub": "someone-else"}, err: "sub mismatch"},
+		{name: "wrong verifier", verifier: "guess", err: "token error"},
+	}
+	for _, tt := range tests {
+		idp := newTestIDP(t)
+		p := newTestOIDC(t, idp)
+		ap, _ := NewAuthParams()
+		idp.authorize(t, p, ap)
+
+		idp.mu.Lock()
+		idp.claims = map[string]interface{}{
+			"iss":   idp.URL,
+			"aud":   testClientID,
+			"exp":   now + 300,
+			"nonce": ap.Nonce,
+			"sub":   "user-1",
+			"email": "alice@example.com",
+		}
+		if tt.claims != nil {
+			tt.claims(idp.claims, idp.URL)
+		}
+		idp.noIDToken = tt.noIDToken
+		idp.userinfo = map[string]interface{}{"sub": "user-1", "preferred_username": "alice", "picture": "https://img.example/a.png"}
+		if tt.userinfo != nil {
+			idp.userinfo = tt.userinfo
+		}
+		idp.mu.Unlock()
+
+		if len(tt.verifier) > 0 {
+			ap.Verifier = tt.verifier
+		}
+		ident, err := p.Exchange(testRedirectURL, "code-1", ap)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		want := Identity{ID: "user-1", Name: "alice", Email: "alice@example.com", Avatar: "https://img.example/a.png"}
+		if *ident != want {
+			t.Errorf("%s: identity %+v, want %+v", tt.name, *ident, want)
+		}
+	}
+}
+
+// 不是 OIDC 时没有 nonce 和 id_token，用户 ID 从用户信息的数字 id 取
+func TestGenericOAuth2(t *testing.T) {
+	idp := newTestIDP(t)
+	idp.noIDToken = true
+	idp.userinfo = map[string]interface{}{"id": 12345, "login": "octocat", "avatar_url": "https://img.example/o.png"}
+	p, err := New(Config{
+		Name:        "gh",
+		Type:        "github",
+		ClientID:    testClientID,
+		AuthURL:     idp.URL + "/authorize",
+		TokenURL:    idp.URL + "/token",
+		UserInfoURL: idp.URL + "/userinfo",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap, _ := NewAuthParams()
+	qs := idp.authorize(t, p, ap)
+	if qs.Get("nonce") != "" || qs.Get("scope") != "read:user user:email" {
+		t.Errorf("oauth2 auth url: nonce %q scope %q", qs.Get("nonce"), qs.Get("scope"))
+	}
+	ident, err := p.Exchange(testRedirectURL, "code-1", ap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ident.ID != "12345" || ident.Name != "octocat" || ident.Avatar != "https://img.example/o.png" {
+		t.Errorf("identity %+v", *ident)
+	}
+	if _, err := p.Exchange(testRedirectURL, "", ap); err == nil {
+		t.Error("empty code accepted")
+	}
+}
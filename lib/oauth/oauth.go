@@ -0,0 +1,142 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// 第三方登录。通用的 OAuth2 / OpenID Connect 实现见 generic.go，
+// QQ、微博的接口不标准，用 lib/qqOAuth、lib/weiboOAuth 包装成 Provider
+
+// Identity 第三方帐号信息，Provider 内 ID 唯一
+type Identity struct {
+	ID     string
+	Name   string
+	Email  string
+	Avatar string
+	Gender string // male/female，不知道时为空
+	About  string
+	URL    string
+}
+
+// AuthParams 一次登录过程中的随机值，跳转前生成，回调时原样传回
+type AuthParams struct {
+	State    string
+	Nonce    string // OIDC id_token 里的 nonce
+	Verifier string // PKCE code_verifier
+}
+
+type Provider interface {
+	Name() string  // 路由和存储用的标识，eg: github
+	Title() string // 登录链接上显示的名字
+	AuthCodeURL(redirectURL string, p AuthParams) (string, error)
+	Exchange(redirectURL, code string, p AuthParams) (*Identity, error)
+}
+
+// Config 配置文件里的一个登录方式
+type Config struct {
+	Name         string
+	Title        string
+	Type         string // github/gitlab/google/oidc/oauth2
+	ClientID     string
+	ClientSecret string
+	Issuer       string // OIDC issuer，设置后从 /.well-known/openid-configuration 取其它地址
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	Scopes       []string
+	IDField      string // 用户信息 JSON 里的字段名，OIDC 默认 sub，否则默认 id
+	NameField    string
+	EmailField   string
+	AvatarField  string
+}
+
+var presets = map[string]Config{
+	"github": {
+		Title:       "GitHub",
+		AuthURL:     "https://github.com/login/oauth/authorize",
+		TokenURL:    "https://github.com/login/oauth/access_token",
+		UserInfoURL: "https://api.github.com/user",
+		Scopes:      []string{"read:user", "user:email"},
+		IDField:     "id",
+		NameField:   "login",
+		AvatarField: "avatar_url",
+	},
+	"gitlab": {
+		Title:  "GitLab",
+		Issuer: "https://gitlab.com",
+	},
+	"google": {
+		Title:  "Google",
+		Issuer: "https://accounts.google.com",
+	},
+}
+
+// New 按配置生成 Provider，Type 为 github/gitlab/google 时未填的项使用预设值，
+// 自建的 GitLab 把 Issuer 改成自己的地址即可
+func New(conf Config) (Provider, error) {
+	if len(conf.Name) == 0 {
+		conf.Name = conf.Type
+	}
+	if preset, ok := presets[conf.Type]; ok {
+		if len(conf.Title) == 0 {
+			conf.Title = preset.Title
+		}
+		if len(conf.Issuer) == 0 && len(conf.AuthURL) == 0 {
+			conf.Issuer = preset.Issuer
+			conf.AuthURL = preset.AuthURL
+			conf.TokenURL = preset.TokenURL
+			conf.UserInfoURL = preset.UserInfoURL
+		}
+		if len(conf.Scopes) == 0 {
+			conf.Scopes = preset.Scopes
+		}
+		if len(conf.IDField) == 0 {
+			conf.IDField = preset.IDField
+		}
+		if len(conf.NameField) == 0 {
+			conf.NameField = preset.NameField
+		}
+		if len(conf.AvatarField) == 0 {
+			conf.AvatarField = preset.AvatarField
+		}
+	}
+	if len(conf.Name) == 0 {
+		return nil, errors.New("name cannot be empty")
+	}
+	if len(conf.ClientID) == 0 {
+		return nil, errors.New("clientID cannot be empty")
+	}
+	if len(conf.Issuer) == 0 && (len(conf.AuthURL) == 0 || len(conf.TokenURL) == 0) {
+		return nil, errors.New("issuer or authURL/tokenURL required")
+	}
+	if len(conf.Title) == 0 {
+		conf.Title = conf.Name
+	}
+	return newGeneric(conf), nil
+}
+
+// NewAuthParams 生成跳转前的随机值
+func NewAuthParams() (AuthParams, error) {
+	var p AuthParams
+	for _, s := range []*string{&p.State, &p.Nonce, &p.Verifier} {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return p, err
+		}
+		*s = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return p, nil
+}
+
+// pkceChallenge S256 方式的 code_challenge
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
@@ -0,0 +1,64 @@
+package oauth
+
+import (
+	"errors"
+
+	"github.com/missdeer/kani/lib/qqOAuth"
+)
+
+// qq QQ 互联，token 接口返回的不是 JSON，openid 要另外取，不支持 PKCE
+type qq struct {
+	clientID     string
+	clientSecret string
+}
+
+func NewQQ(clientID, clientSecret string) Provider {
+	return &qq{clientID: clientID, clientSecret: clientSecret}
+}
+
+func (p *qq) Name() string  { return "qq" }
+func (p *qq) Title() string { return "QQ" }
+
+func (p *qq) AuthCodeURL(redirectURL string, ap AuthParams) (string, error) {
+	o, err := qqOAuth.NewQQOAuth(p.clientID, p.clientSecret, redirectURL)
+	if err != nil {
+		return "", err
+	}
+	return o.GetAuthorizationURL(ap.State)
+}
+
+func (p *qq) Exchange(redirectURL, code string, ap AuthParams) (*Identity, error) {
+	o, err := qqOAuth.NewQQOAuth(p.clientID, p.clientSecret, redirectURL)
+	if err != nil {
+		return nil, err
+	}
+	token, err := o.GetAccessToken(code)
+	if err != nil {
+		return nil, err
+	}
+	if len(token.AccessToken) == 0 {
+		return nil, errors.New("qq token error: " + token.Msg)
+	}
+	openid, err := o.GetOpenID(token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := o.GetUserInfo(token.AccessToken, openid.OpenID)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Ret != 0 {
+		return nil, errors.New(profile.Message)
+	}
+
+	gender := "female"
+	if profile.Gender == "男" {
+		gender = "male"
+	}
+	return &Identity{
+		ID:     openid.OpenID,
+		Name:   profile.Nickname,
+		Avatar: profile.Avatar,
+		Gender: gender,
+	}, nil
+}
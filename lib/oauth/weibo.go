@@ -0,0 +1,63 @@
+package oauth
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/missdeer/kani/lib/weiboOAuth"
+)
+
+// weibo 新浪微博，token 接口直接返回 uid，不支持 PKCE
+type weibo struct {
+	clientID     string
+	clientSecret string
+}
+
+func NewWeibo(clientID, clientSecret string) Provider {
+	return &weibo{clientID: clientID, clientSecret: clientSecret}
+}
+
+func (p *weibo) Name() string  { return "weibo" }
+func (p *weibo) Title() string { return "微博" }
+
+func (p *weibo) AuthCodeURL(redirectURL string, ap AuthParams) (string, error) {
+	o, err := weiboOAuth.NewWeiboOAuth(p.clientID, p.clientSecret, redirectURL)
+	if err != nil {
+		return "", err
+	}
+	return o.GetAuthorizationURL(ap.State)
+}
+
+func (p *weibo) Exchange(redirectURL, code string, ap AuthParams) (*Identity, error) {
+	o, err := weiboOAuth.NewWeiboOAuth(p.clientID, p.clientSecret, redirectURL)
+	if err != nil {
+		return nil, err
+	}
+	token, err := o.GetAccessToken(code)
+	if err != nil {
+		return nil, err
+	}
+	if len(token.UIDString) == 0 {
+		return nil, errors.New("weibo token error: " + token.ErrorMessage)
+	}
+	profile, err := o.GetUserInfo(token.AccessToken, token.UIDString)
+	if err != nil {
+		return nil, err
+	}
+	if profile.UID != 0 && strconv.FormatInt(profile.UID, 10) != token.UIDString {
+		return nil, errors.New("weibo uid mismatch")
+	}
+
+	gender := "female"
+	if profile.Gender == "m" {
+		gender = "male"
+	}
+	return &Identity{
+		ID:     token.UIDString,
+		Name:   profile.Name,
+		Avatar: profile.Avatar,
+		Gender: gender,
+		About:  profile.Description,
+		URL:    profile.URL,
+	}, nil
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/missdeer/kani/cronjob"
 	"github.com/missdeer/kani/getold"
+	"github.com/missdeer/kani/model"
 	"github.com/missdeer/kani/router"
 	"github.com/missdeer/kani/system"
 	"github.com/xi2/httpgzip"
@@ -27,6 +28,8 @@ import (
 func main() {
 	configFile := flag.String("config", "config/config.yaml", "full path of config.yaml file")
 	getOldSite := flag.String("getoldsite", "0", "get or not old site, 0 or 1, 2")
+	rebuildIndex := flag.Bool("rebuildindex", false, "rebuild the full-text search index and exit, only while the server is stopped (admins can rebuild from the article list when running)")
+	rotateCookieKey := flag.Bool("rotatecookiekey", false, "add a new cookie key, keep the old ones for decoding, and exit")
 	flag.Parse()
 
 	c := system.LoadConfig(*configFile)
@@ -46,6 +49,35 @@ func main() {
 		return
 	}
 
+	if *rebuildIndex {
+		log.Println("Rebuilding search index...")
+		num := model.SearchRebuildIndex(app.Db)
+		log.Println("Search index rebuilt, articles:", num)
+		app.Close()
+		return
+	}
+
+	if *rotateCookieKey {
+		if err := system.RotateCookieKeys(app.Cf.Main); err != nil {
+			log.Fatal("rotate cookie key err ", err)
+		}
+		log.Println("Cookie key rotated, restart the server to use it:", app.Cf.Main.CookieKeyFile)
+		app.Close()
+		return
+	}
+
+	if app.Cf.Site.NoticeMaxNum > 0 {
+		model.NotificationMaxNum = app.Cf.Site.NoticeMaxNum
+	}
+	// 旧的站内提醒数据迁移
+	if num := model.NotificationMigrate(app.Db); num > 0 {
+		log.Println("Notifications migrated, users:", num)
+	}
+	// 旧的 QQ、微博登录数据迁移
+	if num := model.OAuthIdentityMigrate(app.Db); num > 0 {
+		log.Println("OAuth identities migrated:", num)
+	}
+
 	// cron job
 	cr := cronjob.BaseHandler{App: app}
 	go cr.MainCronJob()
@@ -116,13 +148,15 @@ func main() {
 
 		srv = &http.Server{
 			Addr:           ":" + strconv.Itoa(mcf.HttpsPort),
-			Handler:        httpgzip.NewHandler(root, nil),
+			Handler:        noGzipStream(root, httpgzip.NewHandler(root, nil)),
 			TLSConfig:      tlsCf,
 			MaxHeaderBytes: int(app.Cf.Site.UploadMaxSizeByte),
 		}
 
 		go func() {
-			log.Fatal(srv.ListenAndServeTLS(mcf.TLSCrtFile, mcf.TLSKeyFile))
+			if err := srv.ListenAndServeTLS(mcf.TLSCrtFile, mcf.TLSKeyFile); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
 		}()
 
 		log.Println("Web server Listen port", mcf.HttpsPort)
@@ -131,18 +165,23 @@ func main() {
 		// http
 		srv = &http.Server{Addr: ":" + strconv.Itoa(mcf.HttpPort), Handler: root}
 		go func() {
-			log.Fatal(srv.ListenAndServe())
+			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
 		}()
 
 		log.Println("Web server Listen port", mcf.HttpPort)
 	}
 
+	// 关闭时先结束 SSE 推送，否则长连接会让 Shutdown 一直等待
+	srv.RegisterOnShutdown(app.Hub.Close)
+
 	<-stopChan // wait for SIGINT
 	log.Println("Shutting down server...")
 
 	// shut down gracefully, but wait no longer than 10 seconds before halting
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	cancel()
+	defer cancel()
 	srv.Shutdown(ctx)
 	app.Close()
 
@@ -159,6 +198,18 @@ func redirectHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, target, 301)
 }
 
+// noGzipStream SSE 不经过 gzip，避免推送被缓冲
+func noGzipStream(root, gz http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/notification/stream" {
+			root.ServeHTTP(w, r)
+			return
+		}
+		gz.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
 func stlAge(h http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		// add max-age to get A+
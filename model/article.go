@@ -1,9 +1,12 @@
 package model
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,10 +21,12 @@ type Article struct {
 	RUID         uint64 `json:"ruid"`
 	Title        string `json:"title"`
 	Content      string `json:"content"`
+	Format       string `json:"format,omitempty"` // 内容格式，见 util.ContentFormatMarkdown
 	ClientIP     string `json:"clientip"`
 	Tags         string `json:"tags"`
 	AddTime      uint64 `json:"addtime"`
 	EditTime     uint64 `json:"edittime"`
+	Edited       uint64 `json:"edited"` // 最后一次修改内容的时间
 	Comments     uint64 `json:"comments"`
 	CloseComment bool   `json:"closecomment"`
 	Hidden       bool   `json:"hidden"`
@@ -97,6 +102,76 @@ func ArticleGetByID(db *youdb.DB, aid string) (Article, error) {
 	return obj, nil
 }
 
+// ArticleUpdate 保存修改后的文章，同步分类、标题、标签和全文索引
+func ArticleUpdate(db *youdb.DB, oldObj, aobj Article) {
+	aidB := youdb.I2b(aobj.ID)
+
+	jb, _ := json.Marshal(aobj)
+	db.Hset("article", aidB, jb)
+
+	if oldObj.CID != aobj.CID {
+		db.Zincr("category_article_num", youdb.I2b(aobj.CID), 1)
+		db.Zincr("category_article_num", youdb.I2b(oldObj.CID), -1)
+
+		db.Zset("category_article_timeline:"+strconv.FormatUint(aobj.CID, 10), aidB, aobj.EditTime)
+		db.Zdel("category_article_timeline:"+strconv.FormatUint(oldObj.CID, 10), aidB)
+	}
+
+	if oldObj.Title != aobj.Title {
+		hash0 := md5.Sum([]byte(oldObj.Title))
+		db.Hdel("title_md5", []byte(hex.EncodeToString(hash0[:])))
+		hash := md5.Sum([]byte(aobj.Title))
+		db.Hset("title_md5", []byte(hex.EncodeToString(hash[:])), aidB)
+	}
+
+	if oldObj.Tags != aobj.Tags {
+		oldTag := strings.Split(oldObj.Tags, ",")
+		newTag := strings.Split(aobj.Tags, ",")
+
+		// remove
+		for _, tag1 := range oldTag {
+			contains := false
+			for _, tag2 := range newTag {
+				if tag1 == tag2 {
+					contains = true
+					break
+				}
+			}
+			if contains == false {
+				tagLower := strings.ToLower(tag1)
+				db.Hdel("tag", []byte(tagLower))
+				db.Hdel("tag:"+tagLower, aidB)
+				db.Zincr("tag_article_num", []byte(tagLower), -1)
+			}
+		}
+		// add
+		for _, tag1 := range newTag {
+			contains := false
+			for _, tag2 := range oldTag {
+				if tag1 == tag2 {
+					contains = true
+					break
+				}
+			}
+			if contains == false {
+				tagLower := strings.ToLower(tag1)
+				if db.Hget("tag", []byte(tagLower)).State != "ok" {
+					db.Hset("tag", []byte(tagLower), []byte(""))
+					db.HnextSequence("tag")
+				}
+				// check if not exist !important
+				if db.Hget("tag:"+tagLower, aidB).State != "ok" {
+					db.Hset("tag:"+tagLower, aidB, []byte(""))
+					db.Zincr("tag_article_num", []byte(tagLower), 1)
+				}
+			}
+		}
+	}
+
+	// 全文索引
+	SearchIndexArticle(db, aobj.ID)
+}
+
 func ArticleList(db *youdb.DB, cmd, tb, key, score string, limit, tz int) ArticlePageInfo {
 	var items []ArticleListItem
 	var keys [][]byte
@@ -418,180 +493,6 @@ func UserArticleList(db *youdb.DB, cmd, tb, key string, limit, tz int) ArticlePa
 	}
 }
 
-func ArticleNotificationList(db *youdb.DB, ids string, tz int) ArticlePageInfo {
-	var items []ArticleListItem
-	var keys [][]byte
-
-	for _, v := range strings.Split(ids, ",") {
-		keys = append(keys, youdb.DS2b(v))
-	}
-
-	if len(keys) > 0 {
-		var aitems []ArticleMini
-		userMap := map[uint64]UserMini{}
-		categoryMap := map[uint64]CategoryMini{}
-
-		rs := db.Hmget("article", keys)
-		if rs.State == "ok" {
-			for i := 0; i < (len(rs.Data) - 1); i += 2 {
-				item := ArticleMini{}
-				json.Unmarshal(rs.Data[i+1], &item)
-				aitems = append(aitems, item)
-				userMap[item.UID] = UserMini{}
-				if item.RUID > 0 {
-					userMap[item.RUID] = UserMini{}
-				}
-				categoryMap[item.CID] = CategoryMini{}
-			}
-		}
-
-		userKeys := make([][]byte, 0, len(userMap))
-		for k := range userMap {
-			userKeys = append(userKeys, youdb.I2b(k))
-		}
-		rs = db.Hmget("user", userKeys)
-		if rs.State == "ok" {
-			for i := 0; i < (len(rs.Data) - 1); i += 2 {
-				item := UserMini{}
-				json.Unmarshal(rs.Data[i+1], &item)
-				userMap[item.ID] = item
-			}
-		}
-
-		categoryKeys := make([][]byte, 0, len(categoryMap))
-		for k := range categoryMap {
-			categoryKeys = append(categoryKeys, youdb.I2b(k))
-		}
-		rs = db.Hmget("category", categoryKeys)
-		if rs.State == "ok" {
-			for i := 0; i < (len(rs.Data) - 1); i += 2 {
-				item := CategoryMini{}
-				json.Unmarshal(rs.Data[i+1], &item)
-				categoryMap[item.ID] = item
-			}
-		}
-
-		for _, article := range aitems {
-			user := userMap[article.UID]
-			category := categoryMap[article.CID]
-			item := ArticleListItem{
-				ID:          article.ID,
-				UID:         article.UID,
-				Name:        user.Name,
-				Avatar:      user.Avatar,
-				CID:         article.CID,
-				Cname:       category.Name,
-				RUID:        article.RUID,
-				Title:       article.Title,
-				EditTime:    article.EditTime,
-				EditTimeFmt: util.TimeFmt(article.EditTime, "2006-01-02 15:04", tz),
-				Comments:    article.Comments,
-			}
-			if article.RUID > 0 {
-				item.Rname = userMap[article.RUID].Name
-			}
-			items = append(items, item)
-		}
-	}
-
-	return ArticlePageInfo{Items: items}
-}
-
-func ArticleSearchList(db *youdb.DB, where, kw string, limit, tz int) ArticlePageInfo {
-	var items []ArticleListItem
-
-	var aitems []Article
-	userMap := map[uint64]UserMini{}
-	categoryMap := map[uint64]CategoryMini{}
-
-	startKey := []byte("")
-	for rs := db.Hrscan("article", startKey, limit); rs.State == "ok"; rs = db.Hrscan("article", startKey, limit) {
-		for i := 0; i < (len(rs.Data) - 1); i += 2 {
-			startKey = rs.Data[i]
-			aitem := Article{}
-			json.Unmarshal(rs.Data[i+1], &aitem)
-			if !aitem.Hidden {
-				var getIt bool
-				if where == "title" {
-					if strings.Index(strings.ToLower(aitem.Title), kw) >= 0 {
-						getIt = true
-					}
-				} else {
-					if strings.Index(strings.ToLower(aitem.Content), kw) >= 0 {
-						getIt = true
-					}
-				}
-				if getIt {
-					aitems = append(aitems, aitem)
-					userMap[aitem.UID] = UserMini{}
-					if aitem.RUID > 0 {
-						userMap[aitem.RUID] = UserMini{}
-					}
-					categoryMap[aitem.CID] = CategoryMini{}
-					if len(aitems) == limit {
-						break
-					}
-				}
-			}
-		}
-		if len(aitems) == limit {
-			break
-		}
-	}
-
-	if len(aitems) > 0 {
-		userKeys := make([][]byte, 0, len(userMap))
-		for k := range userMap {
-			userKeys = append(userKeys, youdb.I2b(k))
-		}
-		rs := db.Hmget("user", userKeys)
-		if rs.State == "ok" {
-			for i := 0; i < (len(rs.Data) - 1); i += 2 {
-				item := UserMini{}
-				json.Unmarshal(rs.Data[i+1], &item)
-				userMap[item.ID] = item
-			}
-		}
-
-		categoryKeys := make([][]byte, 0, len(categoryMap))
-		for k := range categoryMap {
-			categoryKeys = append(categoryKeys, youdb.I2b(k))
-		}
-		rs = db.Hmget("category", categoryKeys)
-		if rs.State == "ok" {
-			for i := 0; i < (len(rs.Data) - 1); i += 2 {
-				item := CategoryMini{}
-				json.Unmarshal(rs.Data[i+1], &item)
-				categoryMap[item.ID] = item
-			}
-		}
-
-		for _, article := range aitems {
-			user := userMap[article.UID]
-			category := categoryMap[article.CID]
-			item := ArticleListItem{
-				ID:          article.ID,
-				UID:         article.UID,
-				Name:        user.Name,
-				Avatar:      user.Avatar,
-				CID:         article.CID,
-				Cname:       category.Name,
-				RUID:        article.RUID,
-				Title:       article.Title,
-				EditTime:    article.EditTime,
-				EditTimeFmt: util.TimeFmt(article.EditTime, "2006-01-02 15:04", tz),
-				Comments:    article.Comments,
-			}
-			if article.RUID > 0 {
-				item.Rname = userMap[article.RUID].Name
-			}
-			items = append(items, item)
-		}
-	}
-
-	return ArticlePageInfo{Items: items}
-}
-
 func ArticleFeedList(db *youdb.DB, limit, tz int) []ArticleFeedListItem {
 	var items []ArticleFeedListItem
 	var keys [][]byte
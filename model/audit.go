@@ -0,0 +1,81 @@
+package model
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/util"
+)
+
+// 管理员操作记录
+// admin_audit            hash  id -> AdminAudit
+// admin_audit_user:<uid> hash  id -> ""，按被操作的用户索引
+
+type AdminAudit struct {
+	ID        uint64 `json:"id"`
+	AdminUID  uint64 `json:"adminuid"`
+	Act       string `json:"act"`
+	TargetUID uint64 `json:"targetuid"`
+	Content   string `json:"content"`
+	IP        string `json:"ip"`
+	AddTime   uint64 `json:"addtime"`
+}
+
+type AdminAuditListItem struct {
+	AdminAudit
+	AdminName  string
+	AddTimeFmt string
+}
+
+func adminAuditUserTb(uid uint64) string {
+	return "admin_audit_user:" + strconv.FormatUint(uid, 10)
+}
+
+func AdminAuditAdd(db *youdb.DB, obj AdminAudit) {
+	obj.ID, _ = db.HnextSequence("admin_audit")
+	obj.AddTime = uint64(time.Now().UTC().Unix())
+	jb, _ := json.Marshal(obj)
+	db.Hset("admin_audit", youdb.I2b(obj.ID), jb)
+	if obj.TargetUID > 0 {
+		db.Hset(adminAuditUserTb(obj.TargetUID), youdb.I2b(obj.ID), []byte(""))
+	}
+}
+
+// AdminAuditListByUser 对某个用户的最近操作记录
+func AdminAuditListByUser(db *youdb.DB, uid uint64, limit, tz int) []AdminAuditListItem {
+	var items []AdminAuditListItem
+	var keys [][]byte
+	rs := db.Hrscan(adminAuditUserTb(uid), []byte(""), limit)
+	if rs.State == "ok" {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			keys = append(keys, rs.Data[i])
+		}
+	}
+	if len(keys) == 0 {
+		return items
+	}
+
+	userMap := map[uint64]string{}
+	rs = db.Hmget("admin_audit", keys)
+	if rs.State == "ok" {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			obj := AdminAudit{}
+			json.Unmarshal(rs.Data[i+1], &obj)
+			name, ok := userMap[obj.AdminUID]
+			if !ok {
+				if uobj, err := UserGetByID(db, obj.AdminUID); err == nil {
+					name = uobj.Name
+				}
+				userMap[obj.AdminUID] = name
+			}
+			items = append(items, AdminAuditListItem{
+				AdminAudit: obj,
+				AdminName:  name,
+				AddTimeFmt: util.TimeFmt(obj.AddTime, "2006-01-02 15:04", tz),
+			})
+		}
+	}
+	return items
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/util"
+)
+
+// 图片验证码
+// captcha         hash  id -> Captcha，校验一次后作废
+// captcha_expire  zset  id -> 过期时间，cronjob 清除
+// 页面只带一个随机 ID，第一次请求图片时才保存答案，光看页面不会往 captcha 里写数据
+
+const (
+	captchaExpire = 600 // 验证码有效期（秒）
+	captchaLen    = 4
+)
+
+type Captcha struct {
+	Answer string `json:"answer"`
+	Expire int64  `json:"expire"`
+}
+
+// CaptchaNew 生成新的验证码 ID，答案在 CaptchaAnswer 第一次请求图片时生成
+func CaptchaNew() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+var captchaIDRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func captchaSet(db *youdb.DB, id string) Captcha {
+	obj := Captcha{
+		Answer: util.CaptchaDigits(captchaLen),
+		Expire: time.Now().UTC().Unix() + captchaExpire,
+	}
+	jb, _ := json.Marshal(obj)
+	db.Hset("captcha", []byte(id), jb)
+	db.Zset("captcha_expire", []byte(id), uint64(obj.Expire))
+	return obj
+}
+
+// CaptchaAnswer 取得验证码的答案用来画图，还没有答案或已过期时生成一个，
+// reload 为 true 时换一个答案（看不清时刷新）
+func CaptchaAnswer(db *youdb.DB, id string, reload bool) (string, bool) {
+	if !captchaIDRegexp.MatchString(id) {
+		return "", false
+	}
+	rs := db.Hget("captcha", []byte(id))
+	obj := Captcha{}
+	if rs.State == "ok" {
+		json.Unmarshal(rs.Data[0], &obj)
+	}
+	if rs.State != "ok" || reload || obj.Expire < time.Now().UTC().Unix() {
+		obj = captchaSet(db, id)
+	}
+	return obj.Answer, true
+}
+
+// CaptchaCheck 校验答案，不论对错验证码都作废
+func CaptchaCheck(db *youdb.DB, id, answer string) bool {
+	if len(id) == 0 || len(answer) == 0 {
+		return false
+	}
+	rs := db.Hget("captcha", []byte(id))
+	if rs.State != "ok" {
+		return false
+	}
+	db.Hdel("captcha", []byte(id))
+	db.Zdel("captcha_expire", []byte(id))
+
+	obj := Captcha{}
+	json.Unmarshal(rs.Data[0], &obj)
+	if obj.Expire < time.Now().UTC().Unix() {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(obj.Answer), []byte(answer)) == 1
+}
+
+// CaptchaExpire 清除过期的验证码，返回清除的条数
+func CaptchaExpire(db *youdb.DB, limit int) int {
+	rs := db.Zrscan("captcha_expire", []byte(""), youdb.I2b(uint64(time.Now().UTC().Unix())), limit)
+	if rs.State != "ok" {
+		return 0
+	}
+	n := 0
+	for i := 0; i < (len(rs.Data) - 1); i += 2 {
+		db.Hdel("captcha", rs.Data[i])
+		db.Zdel("captcha_expire", rs.Data[i])
+		n++
+	}
+	return n
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"html/template"
+	"strconv"
 
 	"github.com/ego008/youdb"
 	"github.com/missdeer/kani/util"
@@ -13,9 +14,12 @@ type Comment struct {
 	ID       uint64 `json:"id"`
 	AID      uint64 `json:"aid"`
 	UID      uint64 `json:"uid"`
+	PID      uint64 `json:"pid"` // 回复的评论，0 为直接回复文章
 	Content  string `json:"content"`
+	Format   string `json:"format,omitempty"` // 内容格式，见 util.ContentFormatMarkdown
 	ClientIP string `json:"clientip"`
 	AddTime  uint64 `json:"addtime"`
+	Edited   uint64 `json:"edited"` // 最后一次修改的时间
 }
 
 type CommentListItem struct {
@@ -24,10 +28,16 @@ type CommentListItem struct {
 	UID        uint64 `json:"uid"`
 	Name       string `json:"name"`
 	Avatar     string `json:"avatar"`
+	PID        uint64 `json:"pid"`
+	PName      string `json:"pname"`
+	Level      int    `json:"level"` // 在当前页的嵌套层数
 	Content    string `json:"content"`
 	ContentFmt template.HTML
 	AddTime    uint64 `json:"addtime"`
 	AddTimeFmt string `json:"addtimefmt"`
+	Edited     uint64 `json:"edited"`
+	EditedFmt  string `json:"editedfmt"`
+	Editable   bool   `json:"editable"`
 }
 
 type CommentPageInfo struct {
@@ -36,6 +46,10 @@ type CommentPageInfo struct {
 	HasNext  bool              `json:"hasnext"`
 	FirstKey uint64            `json:"firstkey"`
 	LastKey  uint64            `json:"lastkey"`
+	Page     int               `json:"page"`
+	PageNum  int               `json:"pagenum"`
+	PrevPage int               `json:"prevpage"`
+	NextPage int               `json:"nextpage"`
 }
 
 func CommentGetByKey(db *youdb.DB, aid string, cid uint64) (Comment, error) {
@@ -62,6 +76,62 @@ func CommentDelByKey(db *youdb.DB, aid string, cid uint64) error {
 	return db.Hdel("article_comment:"+aid, youdb.I2b(cid))
 }
 
+// CommentPageOf 评论所在的页，按评论 ID 计算，删除评论后页码不变
+func CommentPageOf(cid uint64, limit int) int {
+	if cid == 0 || limit <= 0 {
+		return 1
+	}
+	return int((cid-1)/uint64(limit)) + 1
+}
+
+// CommentListPage 文章第 page 页的评论，每页为 ID 在 ((page-1)*limit, page*limit] 内的评论
+func CommentListPage(db *youdb.DB, aid string, page, limit, tz int) CommentPageInfo {
+	tb := "article_comment:" + aid
+	pageNum := CommentPageOf(db.Hsequence(tb), limit)
+	if page < 1 {
+		page = 1
+	}
+
+	pageInfo := CommentList(db, "hscan", tb, strconv.Itoa((page-1)*limit), limit, tz)
+	maxID := uint64(page * limit)
+	items := pageInfo.Items[:0]
+	for _, item := range pageInfo.Items {
+		if item.ID <= maxID {
+			items = append(items, item)
+		}
+	}
+	pageInfo.Items = items
+	pageInfo.FirstKey, pageInfo.LastKey = 0, 0
+	if len(items) > 0 {
+		pageInfo.FirstKey = items[0].ID
+		pageInfo.LastKey = items[len(items)-1].ID
+	}
+
+	pageInfo.Page = page
+	pageInfo.PageNum = pageNum
+	pageInfo.HasPrev = page > 1
+	pageInfo.HasNext = page < pageNum
+	pageInfo.PrevPage = page - 1
+	pageInfo.NextPage = page + 1
+	return pageInfo
+}
+
+// CommentReadGet 用户在文章里读到的最后一条评论
+func CommentReadGet(db *youdb.DB, uid, aid uint64) uint64 {
+	rs := db.Hget("user_article_read:"+strconv.FormatUint(uid, 10), youdb.I2b(aid))
+	if rs.State != "ok" {
+		return 0
+	}
+	return youdb.B2i(rs.Data[0])
+}
+
+// CommentReadSet 记录用户读到的最后一条评论，只往后记
+func CommentReadSet(db *youdb.DB, uid, aid, cid uint64) {
+	if cid > CommentReadGet(db, uid, aid) {
+		db.Hset("user_article_read:"+strconv.FormatUint(uid, 10), youdb.I2b(aid), youdb.I2b(cid))
+	}
+}
+
 func CommentList(db *youdb.DB, cmd, tb, key string, limit, tz int) CommentPageInfo {
 	var items []CommentListItem
 	var citems []Comment
@@ -96,6 +166,33 @@ func CommentList(db *youdb.DB, cmd, tb, key string, limit, tz int) CommentPageIn
 	}
 
 	if len(citems) > 0 {
+		// 父评论不在当前页时，取父评论作者
+		pidMap := map[uint64]uint64{}
+		for _, citem := range citems {
+			pidMap[citem.ID] = citem.UID
+		}
+		var pidKeys [][]byte
+		for _, citem := range citems {
+			if _, ok := pidMap[citem.PID]; citem.PID > 0 && !ok {
+				pidMap[citem.PID] = 0
+				pidKeys = append(pidKeys, youdb.I2b(citem.PID))
+			}
+		}
+		if len(pidKeys) > 0 {
+			rs := db.Hmget(tb, pidKeys)
+			if rs.State == "ok" {
+				for i := 0; i < (len(rs.Data) - 1); i += 2 {
+					item := Comment{}
+					json.Unmarshal(rs.Data[i+1], &item)
+					pidMap[item.ID] = item.UID
+					if _, ok := userMap[item.UID]; !ok {
+						userMap[item.UID] = UserMini{}
+						userKeys = append(userKeys, youdb.I2b(item.UID))
+					}
+				}
+			}
+		}
+
 		rs := db.Hmget("user", userKeys)
 		if rs.State == "ok" {
 			for i := 0; i < (len(rs.Data) - 1); i += 2 {
@@ -113,9 +210,18 @@ func CommentList(db *youdb.DB, cmd, tb, key string, limit, tz int) CommentPageIn
 				UID:        citem.UID,
 				Name:       user.Name,
 				Avatar:     user.Avatar,
+				PID:        citem.PID,
+				Content:    citem.Content,
 				AddTime:    citem.AddTime,
 				AddTimeFmt: util.TimeFmt(citem.AddTime, "2006-01-02 15:04", tz),
-				ContentFmt: template.HTML(util.ContentFmt(db, citem.Content)),
+				ContentFmt: template.HTML(util.ContentFmt(db, citem.Format, citem.Content)),
+				Edited:     citem.Edited,
+			}
+			if citem.Edited > 0 {
+				item.EditedFmt = util.TimeFmt(citem.Edited, "2006-01-02 15:04", tz)
+			}
+			if citem.PID > 0 {
+				item.PName = userMap[pidMap[citem.PID]].Name
 			}
 			items = append(items, item)
 			if firstKey == 0 {
@@ -142,3 +248,38 @@ func CommentList(db *youdb.DB, cmd, tb, key string, limit, tz int) CommentPageIn
 		LastKey:  lastKey,
 	}
 }
+
+// CommentThread 按回复关系排列当前页的评论，子评论紧跟在父评论之后
+// 父评论不在当前页的作为顶层显示，嵌套层数不超过 maxDepth
+func CommentThread(items []CommentListItem, maxDepth int) []CommentListItem {
+	onPage := map[uint64]bool{}
+	for _, item := range items {
+		onPage[item.ID] = true
+	}
+	children := map[uint64][]CommentListItem{}
+	var roots []CommentListItem
+	for _, item := range items {
+		if item.PID > 0 && onPage[item.PID] && item.PID < item.ID {
+			children[item.PID] = append(children[item.PID], item)
+		} else {
+			roots = append(roots, item)
+		}
+	}
+
+	threaded := make([]CommentListItem, 0, len(items))
+	var walk func(item CommentListItem, level int)
+	walk = func(item CommentListItem, level int) {
+		if level > maxDepth {
+			level = maxDepth
+		}
+		item.Level = level
+		threaded = append(threaded, item)
+		for _, child := range children[item.ID] {
+			walk(child, level+1)
+		}
+	}
+	for _, item := range roots {
+		walk(item, 0)
+	}
+	return threaded
+}
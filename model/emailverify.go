@@ -0,0 +1,86 @@
+package model
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/util"
+)
+
+// 邮箱验证
+// user_email2uid     hash  已验证的邮箱(小写) -> uid，一个地址只能被一个账号验证
+// email_verify_send  hash  uid -> 上次发送验证邮件的时间
+
+// EmailVerifySign 验证链接的签名，包含邮箱地址，修改邮箱后旧链接失效
+func EmailVerifySign(db *youdb.DB, uid uint64, email string, expire int64) string {
+	return util.Sign(SecretKey(db, "email"), "verify", strconv.FormatUint(uid, 10),
+		strings.ToLower(email), strconv.FormatInt(expire, 10))
+}
+
+// EmailVerifyURL 生成 hours 小时内有效的验证链接
+func EmailVerifyURL(db *youdb.DB, mainDomain string, obj User, hours int) string {
+	expire := time.Now().UTC().Unix() + int64(hours)*3600
+	return mainDomain + "/verifyemail/confirm?uid=" + strconv.FormatUint(obj.ID, 10) +
+		"&expire=" + strconv.FormatInt(expire, 10) + "&sign=" + EmailVerifySign(db, obj.ID, obj.Email, expire)
+}
+
+// EmailVerifySendCheck 距上次发送不足 interval 秒时返回还需等待的秒数
+func EmailVerifySendCheck(db *youdb.DB, uid uint64, interval int) int {
+	rs := db.Hget("email_verify_send", youdb.I2b(uid))
+	if rs.State != "ok" {
+		return 0
+	}
+	wait := int64(youdb.B2i(rs.Data[0])) + int64(interval) - time.Now().UTC().Unix()
+	if wait > 0 {
+		return int(wait)
+	}
+	return 0
+}
+
+func EmailVerifySendSet(db *youdb.DB, uid uint64) {
+	db.Hset("email_verify_send", youdb.I2b(uid), youdb.I2b(uint64(time.Now().UTC().Unix())))
+}
+
+// EmailVerifiedUID 已验证该邮箱的用户，没有时返回 0
+func EmailVerifiedUID(db *youdb.DB, email string) uint64 {
+	rs := db.Hget("user_email2uid", []byte(strings.ToLower(email)))
+	if rs.State == "ok" {
+		return youdb.B2i(rs.Data[0])
+	}
+	return 0
+}
+
+// EmailVerifyRelease 修改邮箱时释放原来验证过的地址，调用方负责重置 EmailVerified 并保存
+func EmailVerifyRelease(db *youdb.DB, obj User) {
+	if obj.EmailVerified && EmailVerifiedUID(db, obj.Email) == obj.ID {
+		db.Hdel("user_email2uid", []byte(strings.ToLower(obj.Email)))
+	}
+}
+
+// EmailVerifyConfirm 校验链接，通过后标记邮箱已验证
+func EmailVerifyConfirm(db *youdb.DB, uid uint64, expire int64, sign string) (User, error) {
+	obj, err := UserGetByID(db, uid)
+	if err != nil {
+		return obj, errors.New("用户不存在")
+	}
+	if len(obj.Email) == 0 || !util.SignCheck(SecretKey(db, "email"), sign, "verify",
+		strconv.FormatUint(uid, 10), strings.ToLower(obj.Email), strconv.FormatInt(expire, 10)) {
+		return obj, errors.New("验证链接无效")
+	}
+	if expire < time.Now().UTC().Unix() {
+		return obj, errors.New("验证链接已过期")
+	}
+	if other := EmailVerifiedUID(db, obj.Email); other > 0 && other != uid {
+		return obj, errors.New("该邮箱已被其他账号验证")
+	}
+	if obj.EmailVerified {
+		return obj, nil
+	}
+
+	db.Hset("user_email2uid", []byte(strings.ToLower(obj.Email)), youdb.I2b(uid))
+	obj.EmailVerified = true
+	return obj, UserUpdate(db, obj)
+}
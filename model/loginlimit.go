@@ -0,0 +1,179 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/util"
+)
+
+// 登录失败限制，按 IP 和帐号分别统计
+// login_fail       hash  "ip:<ip>" / "user:<name>" -> LoginFail
+// login_fail_seen  zset  同上 -> 最后一次失败的时间，cronjob 清除长期没有再失败的记录
+// login_lock       zset  同上 -> 锁定到期时间，后台据此列出被锁定的帐号和 IP
+
+const loginLockMax = 86400 // 锁定时长翻倍的上限（秒）
+
+type LoginFail struct {
+	Times     []int64 `json:"times"` // 时间窗口内每次失败的时间
+	Locks     int     `json:"locks"` // 已被锁定的次数，下次锁定时长翻倍
+	LockUntil int64   `json:"lockuntil"`
+}
+
+type LoginLockItem struct {
+	Key          string
+	Type         string // ip 或 user
+	Name         string
+	Locks        int
+	LockUntilFmt string
+}
+
+func LoginFailKeyIP(ip string) string {
+	return "ip:" + ip
+}
+
+func LoginFailKeyUser(name string) string {
+	return "user:" + strings.ToLower(name)
+}
+
+func loginFailGet(db *youdb.DB, key string) LoginFail {
+	obj := LoginFail{}
+	rs := db.Hget("login_fail", []byte(key))
+	if rs.State == "ok" {
+		json.Unmarshal(rs.Data[0], &obj)
+	}
+	return obj
+}
+
+// recent 去掉时间窗口以外的失败记录，返回窗口内的次数
+func (obj *LoginFail) recent(window int, now int64) int {
+	times := obj.Times[:0]
+	for _, t := range obj.Times {
+		if t > now-int64(window) {
+			times = append(times, t)
+		}
+	}
+	obj.Times = times
+	return len(times)
+}
+
+// LoginLocked 返回 keys 中最长的剩余锁定秒数，0 为没有锁定
+func LoginLocked(db *youdb.DB, keys ...string) int64 {
+	now := time.Now().UTC().Unix()
+	var remain int64
+	for _, key := range keys {
+		rs := db.Zget("login_lock", []byte(key))
+		if rs.State != "ok" {
+			continue
+		}
+		if n := int64(youdb.B2i(rs.Data[0])) - now; n > remain {
+			remain = n
+		}
+	}
+	return remain
+}
+
+// LoginFailCount 返回 keys 中时间窗口内失败次数的最大值
+func LoginFailCount(db *youdb.DB, window int, keys ...string) int {
+	now := time.Now().UTC().Unix()
+	max := 0
+	for _, key := range keys {
+		obj := loginFailGet(db, key)
+		if n := obj.recent(window, now); n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// add 在 now 记一次失败，时间窗口内达到 max 次时锁定，
+// 第一次锁定 lockTime 秒，之后每次翻倍，最长 loginLockMax；返回锁定的秒数，0 为没有锁定
+func (obj *LoginFail) add(now int64, window, max, lockTime int) int64 {
+	obj.recent(window, now)
+	obj.Times = append(obj.Times, now)
+	if max <= 0 || len(obj.Times) < max {
+		return 0
+	}
+
+	lock := int64(lockTime)
+	for i := 0; i < obj.Locks && lock < loginLockMax; i++ {
+		lock *= 2
+	}
+	if lock > loginLockMax {
+		lock = loginLockMax
+	}
+	obj.Locks++
+	obj.LockUntil = now + lock
+	obj.Times = obj.Times[:0]
+	return lock
+}
+
+// LoginFailAdd 记一次失败，见 LoginFail.add；返回锁定的秒数，0 为没有锁定
+func LoginFailAdd(db *youdb.DB, key string, window, max, lockTime int) int64 {
+	now := time.Now().UTC().Unix()
+	obj := loginFailGet(db, key)
+	lock := obj.add(now, window, max, lockTime)
+	if lock > 0 {
+		db.Zset("login_lock", []byte(key), uint64(obj.LockUntil))
+	}
+
+	jb, _ := json.Marshal(obj)
+	db.Hset("login_fail", []byte(key), jb)
+	db.Zset("login_fail_seen", []byte(key), uint64(now))
+	return lock
+}
+
+// LoginFailClear 清除失败记录和锁定，登录成功或管理员解锁时调用
+func LoginFailClear(db *youdb.DB, key string) {
+	db.Hdel("login_fail", []byte(key))
+	db.Zdel("login_fail_seen", []byte(key))
+	db.Zdel("login_lock", []byte(key))
+}
+
+// LoginLockList 当前被锁定的帐号和 IP，最晚解锁的在前
+func LoginLockList(db *youdb.DB, limit, tz int) []LoginLockItem {
+	var items []LoginLockItem
+	now := uint64(time.Now().UTC().Unix())
+	rs := db.Zrscan("login_lock", []byte(""), []byte(""), limit)
+	if rs.State != "ok" {
+		return items
+	}
+	for i := 0; i < (len(rs.Data) - 1); i += 2 {
+		until := youdb.B2i(rs.Data[i+1])
+		if until <= now {
+			break
+		}
+		key := string(rs.Data[i])
+		item := LoginLockItem{
+			Key:          key,
+			Locks:        loginFailGet(db, key).Locks,
+			LockUntilFmt: util.TimeFmt(until, "2006-01-02 15:04", tz),
+		}
+		if idx := strings.Index(key, ":"); idx > 0 {
+			item.Type, item.Name = key[:idx], key[idx+1:]
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// LoginFailExpire 清除 keep 秒内没有再失败、也没有在锁定中的记录，锁定次数随之归零
+func LoginFailExpire(db *youdb.DB, keep int, limit int) int {
+	now := time.Now().UTC().Unix()
+	rs := db.Zrscan("login_fail_seen", []byte(""), youdb.I2b(uint64(now-int64(keep))), limit)
+	if rs.State != "ok" {
+		return 0
+	}
+	n := 0
+	for i := 0; i < (len(rs.Data) - 1); i += 2 {
+		key := string(rs.Data[i])
+		if LoginLocked(db, key) > 0 {
+			continue
+		}
+		LoginFailClear(db, key)
+		n++
+	}
+	return n
+}
@@ -0,0 +1,84 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoginFailRecent(t *testing.T) {
+	tests := []struct {
+		name   string
+		times  []int64
+		window int
+		now    int64
+		want   []int64
+	}{
+		{"empty", nil, 60, 1000, []int64{}},
+		{"all inside", []int64{950, 990}, 60, 1000, []int64{950, 990}},
+		{"drop old", []int64{900, 940, 941, 999}, 60, 1000, []int64{941, 999}},
+		{"edge is outside", []int64{940}, 60, 1000, []int64{}},
+		{"all outside", []int64{1, 2, 3}, 60, 1000, []int64{}},
+	}
+	for _, tt := range tests {
+		obj := LoginFail{Times: append([]int64(nil), tt.times...)}
+		n := obj.recent(tt.window, tt.now)
+		if n != len(tt.want) || !reflect.DeepEqual(append([]int64{}, obj.Times...), tt.want) {
+			t.Errorf("%s: recent = %d %v, want %v", tt.name, n, obj.Times, tt.want)
+		}
+	}
+}
+
+func TestLoginFailAdd(t *testing.T) {
+	const (
+		window   = 60
+		max      = 3
+		lockTime = 300
+	)
+	tests := []struct {
+		name      string
+		obj       LoginFail
+		now       int64
+		max       int
+		lock      int64
+		locks     int
+		times     int
+		lockUntil int64
+	}{
+		{"first failure", LoginFail{}, 1000, max, 0, 0, 1, 0},
+		{"below max", LoginFail{Times: []int64{990}}, 1000, max, 0, 0, 2, 0},
+		{"reach max", LoginFail{Times: []int64{980, 990}}, 1000, max, 300, 1, 0, 1300},
+		{"old failures expire", LoginFail{Times: []int64{900, 930}}, 1000, max, 0, 0, 1, 0},
+		{"second lock doubles", LoginFail{Times: []int64{980, 990}, Locks: 1}, 1000, max, 600, 2, 0, 1600},
+		{"third lock doubles again", LoginFail{Times: []int64{980, 990}, Locks: 2}, 1000, max, 1200, 3, 0, 2200},
+		{"capped at a day", LoginFail{Times: []int64{980, 990}, Locks: 20}, 1000, max, loginLockMax, 21, 0, 1000 + loginLockMax},
+		{"no limit", LoginFail{Times: []int64{980, 990, 995}}, 1000, 0, 0, 0, 4, 0},
+	}
+	for _, tt := range tests {
+		obj := tt.obj
+		lock := obj.add(tt.now, window, tt.max, lockTime)
+		if lock != tt.lock || obj.Locks != tt.locks || len(obj.Times) != tt.times || obj.LockUntil != tt.lockUntil {
+			t.Errorf("%s: add = %d, locks %d, times %d, until %d; want %d, %d, %d, %d",
+				tt.name, lock, obj.Locks, len(obj.Times), obj.LockUntil, tt.lock, tt.locks, tt.times, tt.lockUntil)
+		}
+	}
+}
+
+// 连续锁定时，每轮失败都从零开始计数，锁定时长逐次翻倍
+func TestLoginFailBackoff(t *testing.T) {
+	obj := LoginFail{}
+	now := int64(1000)
+	var locks []int64
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 2; i++ {
+			if lock := obj.add(now, 60, 3, 300); lock != 0 {
+				t.Fatalf("round %d: locked after %d failures", round, i+1)
+			}
+			now++
+		}
+		locks = append(locks, obj.add(now, 60, 3, 300))
+		now = obj.LockUntil
+	}
+	if want := []int64{300, 600, 1200, 2400, 4800}; !reflect.DeepEqual(locks, want) {
+		t.Errorf("lock durations %v, want %v", locks, want)
+	}
+}
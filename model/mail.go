@@ -0,0 +1,145 @@
+package model
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/util"
+)
+
+// 邮件提醒
+// User.EmailMention、User.EmailReply 为提醒方式，只发给 EmailVerified 的地址
+// email_digest_queue  hash  uid -> 入队时间，有待汇总的提醒
+// email_digest_time   hash  uid -> 上次发送汇总的时间
+
+const (
+	EmailModeOff       = "off"
+	EmailModeImmediate = "immediate" // 立即发送
+	EmailModeDigest    = "digest"    // 每日汇总
+)
+
+// EmailUnsubscribeDigest 退订每日汇总，其它退订类型为提醒类型
+const EmailUnsubscribeDigest = "digest"
+
+// UserEmailMode 用户对某类提醒的邮件方式，未设置为不发送
+func UserEmailMode(obj User, typ string) string {
+	var mode string
+	switch typ {
+	case NotificationMention:
+		mode = obj.EmailMention
+	case NotificationReply:
+		mode = obj.EmailReply
+	}
+	if mode == EmailModeImmediate || mode == EmailModeDigest {
+		return mode
+	}
+	return EmailModeOff
+}
+
+// UserEmailSendable 邮箱已验证才发送
+func UserEmailSendable(obj User) bool {
+	return obj.EmailVerified && util.IsMail(obj.Email)
+}
+
+func EmailDigestQueueAdd(db *youdb.DB, uid, now uint64) {
+	if db.Hget("email_digest_queue", youdb.I2b(uid)).State != "ok" {
+		db.Hset("email_digest_queue", youdb.I2b(uid), youdb.I2b(now))
+	}
+}
+
+// EmailDigestQueueList 取待发送汇总的用户
+func EmailDigestQueueList(db *youdb.DB, limit int) []uint64 {
+	var uids []uint64
+	rs := db.Hscan("email_digest_queue", []byte(""), limit)
+	if rs.State == "ok" {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			uids = append(uids, youdb.B2i(rs.Data[i]))
+		}
+	}
+	return uids
+}
+
+// EmailDigestDone 出队并记录发送时间
+func EmailDigestDone(db *youdb.DB, uid, now uint64) {
+	db.Hdel("email_digest_queue", youdb.I2b(uid))
+	db.Hset("email_digest_time", youdb.I2b(uid), youdb.I2b(now))
+}
+
+func EmailDigestLastTime(db *youdb.DB, uid uint64) uint64 {
+	rs := db.Hget("email_digest_time", youdb.I2b(uid))
+	if rs.State == "ok" {
+		return youdb.B2i(rs.Data[0])
+	}
+	return 0
+}
+
+// EmailDigestItems 取上次汇总之后、仍未读且用户选择了汇总方式的提醒
+func EmailDigestItems(db *youdb.DB, uobj User, limit int) []Notification {
+	since := EmailDigestLastTime(db, uobj.ID)
+	var items []Notification
+	notificationScan(db, uobj.ID, func(obj Notification) bool {
+		if obj.AddTime <= since || len(items) >= limit {
+			return false
+		}
+		if !obj.Read && UserEmailMode(uobj, obj.Type) == EmailModeDigest {
+			items = append(items, obj)
+		}
+		return true
+	})
+	return items
+}
+
+// EmailUnsubscribeSign 一键退订链接的签名，typ 为提醒类型或 EmailUnsubscribeDigest
+func EmailUnsubscribeSign(db *youdb.DB, uid uint64, typ string) string {
+	return util.Sign(SecretKey(db, "email"), "unsubscribe", strconv.FormatUint(uid, 10), typ)
+}
+
+// EmailUnsubscribeCheck 校验退订链接的签名和类型，返回对应的用户
+func EmailUnsubscribeCheck(db *youdb.DB, uid uint64, typ, sig string) (User, error) {
+	if !util.SignCheck(SecretKey(db, "email"), sig, "unsubscribe", strconv.FormatUint(uid, 10), typ) {
+		return User{}, errors.New("sign err")
+	}
+	switch typ {
+	case NotificationMention, NotificationReply, EmailUnsubscribeDigest:
+	default:
+		return User{}, errors.New("unknown type")
+	}
+	return UserGetByID(db, uid)
+}
+
+// EmailUnsubscribe 校验签名后关闭对应的邮件提醒
+func EmailUnsubscribe(db *youdb.DB, uid uint64, typ, sig string) (User, error) {
+	uobj, err := EmailUnsubscribeCheck(db, uid, typ, sig)
+	if err != nil {
+		return uobj, err
+	}
+	switch typ {
+	case NotificationMention:
+		uobj.EmailMention = EmailModeOff
+	case NotificationReply:
+		uobj.EmailReply = EmailModeOff
+	case EmailUnsubscribeDigest:
+		if uobj.EmailMention == EmailModeDigest {
+			uobj.EmailMention = EmailModeOff
+		}
+		if uobj.EmailReply == EmailModeDigest {
+			uobj.EmailReply = EmailModeOff
+		}
+	}
+	return uobj, UserUpdate(db, uobj)
+}
+
+// EmailUnsubscribeURL 一键退订链接
+func EmailUnsubscribeURL(db *youdb.DB, mainDomain string, uid uint64, typ string) string {
+	return mainDomain + "/unsubscribe?uid=" + strconv.FormatUint(uid, 10) + "&type=" + typ +
+		"&sign=" + EmailUnsubscribeSign(db, uid, typ)
+}
+
+// EmailUnsubscribeHeader 支持邮件客户端一键退订（RFC 8058）
+func EmailUnsubscribeHeader(unsubscribeURL string) map[string]string {
+	return map[string]string{
+		"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
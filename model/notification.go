@@ -0,0 +1,427 @@
+package model
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/util"
+)
+
+// 站内提醒
+// notification:<uid>        hash  nid -> Notification
+// notification_num          hash  uid -> 提醒条数，超出 NotificationMaxNum 时从最旧的删起
+// notification_dedup:<uid>  hash  "aid:type:fromuid" -> 未读提醒的 nid，用来合并同类提醒
+// User.NoticeNum 为未读数；User.Notice 是旧的逗号分隔文章 ID，启动时迁移到 notification:<uid>
+
+const (
+	NotificationMention = "mention" // 在文章或评论里 @ 了你
+	NotificationReply   = "reply"   // 回复了你的文章或评论
+	NotificationAdmin   = "admin"   // 管理员操作
+	NotificationSystem  = "system"  // 系统消息
+	NotificationLegacy  = "legacy"  // 从旧的 User.Notice 迁移来的，不知道是回复还是提到
+)
+
+// NotificationMaxNum 每个用户保留的提醒条数，超出的旧提醒会被清除
+var NotificationMaxNum = 100
+
+type Notification struct {
+	ID      uint64 `json:"id"`
+	UID     uint64 `json:"uid"` // 接收者
+	Type    string `json:"type"`
+	FromUID uint64 `json:"fromuid"`
+	AID     uint64 `json:"aid"`
+	CID     uint64 `json:"cid"`     // 评论 ID，0 为文章本身
+	Content string `json:"content"` // 管理员操作、系统消息的内容
+	Read    bool   `json:"read"`
+	AddTime uint64 `json:"addtime"`
+}
+
+type NotificationListItem struct {
+	Notification
+	FromName   string
+	FromAvatar string
+	Title      string
+	AddTimeFmt string
+}
+
+type NotificationPageInfo struct {
+	Items    []NotificationListItem `json:"items"`
+	HasPrev  bool                   `json:"hasprev"`
+	HasNext  bool                   `json:"hasnext"`
+	FirstKey uint64                 `json:"firstkey"`
+	LastKey  uint64                 `json:"lastkey"`
+}
+
+func notificationTb(uid uint64) string {
+	return "notification:" + strconv.FormatUint(uid, 10)
+}
+
+// notificationScan 从新到旧遍历用户的提醒，fn 返回 false 时停止
+func notificationScan(db *youdb.DB, uid uint64, fn func(obj Notification) bool) {
+	tb := notificationTb(uid)
+	startKey := []byte("")
+	for rs := db.Hrscan(tb, startKey, 100); rs.State == "ok"; rs = db.Hrscan(tb, startKey, 100) {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			startKey = rs.Data[i]
+			obj := Notification{}
+			json.Unmarshal(rs.Data[i+1], &obj)
+			if !fn(obj) {
+				return
+			}
+		}
+	}
+}
+
+func notificationDedupTb(uid uint64) string {
+	return "notification_dedup:" + strconv.FormatUint(uid, 10)
+}
+
+func notificationDedupKey(obj Notification) []byte {
+	return []byte(strconv.FormatUint(obj.AID, 10) + ":" + obj.Type + ":" + strconv.FormatUint(obj.FromUID, 10))
+}
+
+func notificationGet(db *youdb.DB, uid, nid uint64) (Notification, bool) {
+	obj := Notification{}
+	rs := db.Hget(notificationTb(uid), youdb.I2b(nid))
+	if rs.State != "ok" {
+		return obj, false
+	}
+	json.Unmarshal(rs.Data[0], &obj)
+	return obj, true
+}
+
+// notificationNum 取用户的提醒条数，没有计数的旧数据遍历一次，同时重建去重索引和未读数
+func notificationNum(db *youdb.DB, uid uint64) int {
+	rs := db.Hget("notification_num", youdb.I2b(uid))
+	if rs.State == "ok" {
+		return int(youdb.B2i(rs.Data[0]))
+	}
+
+	dedupTb := notificationDedupTb(uid)
+	db.HdelBucket(dedupTb)
+	num, unread := 0, 0
+	notificationScan(db, uid, func(obj Notification) bool {
+		num++
+		if !obj.Read {
+			unread++
+			// 从新到旧，同类的只记最新一条
+			if key := notificationDedupKey(obj); obj.AID > 0 && db.Hget(dedupTb, key).State != "ok" {
+				db.Hset(dedupTb, key, youdb.I2b(obj.ID))
+			}
+		}
+		return true
+	})
+	notificationNumSet(db, uid, num)
+	notificationUnreadSet(db, uid, unread)
+	return num
+}
+
+func notificationNumSet(db *youdb.DB, uid uint64, num int) {
+	if num < 0 {
+		num = 0
+	}
+	db.Hset("notification_num", youdb.I2b(uid), youdb.I2b(uint64(num)))
+}
+
+// notificationUnreadSet 把未读数写回 User.NoticeNum
+func notificationUnreadSet(db *youdb.DB, uid uint64, num int) int {
+	uobj, err := UserGetByID(db, uid)
+	if err != nil {
+		return 0
+	}
+	return notificationUnreadSave(db, uobj, num)
+}
+
+// notificationUnreadAdd 未读数加上 delta，返回新的未读数
+func notificationUnreadAdd(db *youdb.DB, uid uint64, delta int) int {
+	uobj, err := UserGetByID(db, uid)
+	if err != nil {
+		return 0
+	}
+	return notificationUnreadSave(db, uobj, uobj.NoticeNum+delta)
+}
+
+func notificationUnreadSave(db *youdb.DB, uobj User, num int) int {
+	if num < 0 {
+		num = 0
+	}
+	if uobj.NoticeNum != num {
+		uobj.NoticeNum = num
+		jb, _ := json.Marshal(uobj)
+		db.Hset("user", youdb.I2b(uobj.ID), jb)
+	}
+	return num
+}
+
+// notificationRemove 删除一条提醒和它的去重索引，返回删掉的未读条数
+func notificationRemove(db *youdb.DB, uid uint64, obj Notification) int {
+	db.Hdel(notificationTb(uid), youdb.I2b(obj.ID))
+	if obj.Read {
+		return 0
+	}
+	if obj.AID > 0 {
+		dedupTb := notificationDedupTb(uid)
+		key := notificationDedupKey(obj)
+		if rs := db.Hget(dedupTb, key); rs.State == "ok" && youdb.B2i(rs.Data[0]) == obj.ID {
+			db.Hdel(dedupTb, key)
+		}
+	}
+	return 1
+}
+
+// notificationPrune 从最旧的开始删除超出 NotificationMaxNum 的提醒，返回删掉的未读条数和剩余条数
+func notificationPrune(db *youdb.DB, uid uint64, num int) (int, int) {
+	removed := 0
+	over := num - NotificationMaxNum
+	if over <= 0 {
+		return 0, num
+	}
+	rs := db.Hscan(notificationTb(uid), []byte(""), over)
+	if rs.State == "ok" {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			obj := Notification{}
+			json.Unmarshal(rs.Data[i+1], &obj)
+			obj.ID = youdb.B2i(rs.Data[i])
+			removed += notificationRemove(db, uid, obj)
+			num--
+		}
+	}
+	return removed, num
+}
+
+// NotificationAdd 给 obj.UID 添加一条提醒，同一篇文章未读的同类提醒只保留最新一条
+// 去重查 notification_dedup:<uid>，条数记在 notification_num，不用遍历全部提醒
+// 返回接收者的未读数，没有添加时返回 -1
+func NotificationAdd(db *youdb.DB, obj Notification) int {
+	if obj.UID == 0 || obj.UID == obj.FromUID {
+		return -1
+	}
+	tb := notificationTb(obj.UID)
+	dedupTb := notificationDedupTb(obj.UID)
+	num := notificationNum(db, obj.UID)
+	unread := 0
+
+	if obj.AID > 0 {
+		if rs := db.Hget(dedupTb, notificationDedupKey(obj)); rs.State == "ok" {
+			if old, ok := notificationGet(db, obj.UID, youdb.B2i(rs.Data[0])); ok {
+				unread -= notificationRemove(db, obj.UID, old)
+				num--
+			}
+		}
+	}
+
+	obj.ID, _ = db.HnextSequence(tb)
+	obj.Read = false
+	if obj.AddTime == 0 {
+		obj.AddTime = uint64(time.Now().UTC().Unix())
+	}
+	jb, _ := json.Marshal(obj)
+	db.Hset(tb, youdb.I2b(obj.ID), jb)
+	if obj.AID > 0 {
+		db.Hset(dedupTb, notificationDedupKey(obj), youdb.I2b(obj.ID))
+	}
+	num++
+	unread++
+
+	removed, num := notificationPrune(db, obj.UID, num)
+	notificationNumSet(db, obj.UID, num)
+	return notificationUnreadAdd(db, obj.UID, unread-removed)
+}
+
+// NotificationPrune 只保留最新的 NotificationMaxNum 条提醒，返回未读数
+func NotificationPrune(db *youdb.DB, uid uint64) int {
+	removed, num := notificationPrune(db, uid, notificationNum(db, uid))
+	notificationNumSet(db, uid, num)
+	return notificationUnreadAdd(db, uid, -removed)
+}
+
+// NotificationMarkRead 标记已读，aid 为 0 时全部标记已读，否则只标记该文章的提醒，返回剩余未读数
+func NotificationMarkRead(db *youdb.DB, uid, aid uint64) int {
+	notificationNum(db, uid)
+	tb := notificationTb(uid)
+	dedupTb := notificationDedupTb(uid)
+	num := 0
+	notificationScan(db, uid, func(obj Notification) bool {
+		if obj.Read {
+			return true
+		}
+		if aid == 0 || obj.AID == aid {
+			if obj.AID > 0 {
+				db.Hdel(dedupTb, notificationDedupKey(obj))
+			}
+			obj.Read = true
+			jb, _ := json.Marshal(obj)
+			db.Hset(tb, youdb.I2b(obj.ID), jb)
+		} else {
+			num++
+		}
+		return true
+	})
+	return notificationUnreadSet(db, uid, num)
+}
+
+// NotificationDel 删除一条提醒，返回未读数
+func NotificationDel(db *youdb.DB, uid, nid uint64) int {
+	num := notificationNum(db, uid)
+	obj, ok := notificationGet(db, uid, nid)
+	if !ok {
+		return notificationUnreadAdd(db, uid, 0)
+	}
+	removed := notificationRemove(db, uid, obj)
+	notificationNumSet(db, uid, num-1)
+	return notificationUnreadAdd(db, uid, -removed)
+}
+
+// NotificationItems 补上提醒的发送者和文章标题
+func NotificationItems(db *youdb.DB, nitems []Notification, tz int) []NotificationListItem {
+	var items []NotificationListItem
+	userMap := map[uint64]UserMini{}
+	articleMap := map[uint64]ArticleMini{}
+	var userKeys, articleKeys [][]byte
+	for _, item := range nitems {
+		if _, ok := userMap[item.FromUID]; item.FromUID > 0 && !ok {
+			userMap[item.FromUID] = UserMini{}
+			userKeys = append(userKeys, youdb.I2b(item.FromUID))
+		}
+		if _, ok := articleMap[item.AID]; item.AID > 0 && !ok {
+			articleMap[item.AID] = ArticleMini{}
+			articleKeys = append(articleKeys, youdb.I2b(item.AID))
+		}
+	}
+	if len(userKeys) > 0 {
+		rs := db.Hmget("user", userKeys)
+		if rs.State == "ok" {
+			for i := 0; i < (len(rs.Data) - 1); i += 2 {
+				item := UserMini{}
+				json.Unmarshal(rs.Data[i+1], &item)
+				userMap[item.ID] = item
+			}
+		}
+	}
+	if len(articleKeys) > 0 {
+		rs := db.Hmget("article", articleKeys)
+		if rs.State == "ok" {
+			for i := 0; i < (len(rs.Data) - 1); i += 2 {
+				item := ArticleMini{}
+				json.Unmarshal(rs.Data[i+1], &item)
+				articleMap[item.ID] = item
+			}
+		}
+	}
+
+	for _, nitem := range nitems {
+		user := userMap[nitem.FromUID]
+		items = append(items, NotificationListItem{
+			Notification: nitem,
+			FromName:     user.Name,
+			FromAvatar:   user.Avatar,
+			Title:        articleMap[nitem.AID].Title,
+			AddTimeFmt:   util.TimeFmt(nitem.AddTime, "2006-01-02 15:04", tz),
+		})
+	}
+	return items
+}
+
+func NotificationList(db *youdb.DB, cmd string, uid uint64, key string, limit, tz int) NotificationPageInfo {
+	var items []NotificationListItem
+	var hasPrev, hasNext bool
+	var firstKey, lastKey uint64
+	tb := notificationTb(uid)
+
+	keyStart := youdb.DS2b(key)
+	var nitems []Notification
+	if cmd == "hrscan" {
+		rs := db.Hrscan(tb, keyStart, limit)
+		if rs.State == "ok" {
+			for i := 0; i < (len(rs.Data) - 1); i += 2 {
+				item := Notification{}
+				json.Unmarshal(rs.Data[i+1], &item)
+				nitems = append(nitems, item)
+			}
+		}
+	} else if cmd == "hscan" {
+		rs := db.Hscan(tb, keyStart, limit)
+		if rs.State == "ok" {
+			for i := len(rs.Data) - 2; i >= 0; i -= 2 {
+				item := Notification{}
+				json.Unmarshal(rs.Data[i+1], &item)
+				nitems = append(nitems, item)
+			}
+		}
+	}
+
+	if len(nitems) > 0 {
+		items = NotificationItems(db, nitems, tz)
+		firstKey = items[0].ID
+		lastKey = items[len(items)-1].ID
+
+		rs := db.Hscan(tb, youdb.I2b(firstKey), 1)
+		if rs.State == "ok" {
+			hasPrev = true
+		}
+		rs = db.Hrscan(tb, youdb.I2b(lastKey), 1)
+		if rs.State == "ok" {
+			hasNext = true
+		}
+	}
+
+	return NotificationPageInfo{
+		Items:    items,
+		HasPrev:  hasPrev,
+		HasNext:  hasNext,
+		FirstKey: firstKey,
+		LastKey:  lastKey,
+	}
+}
+
+// NotificationMigrate 把旧的 User.Notice 迁移为提醒记录，只执行一次，返回迁移的用户数
+func NotificationMigrate(db *youdb.DB) int {
+	if db.Hget("migration", []byte("notification")).State == "ok" {
+		return 0
+	}
+
+	n := 0
+	now := uint64(time.Now().UTC().Unix())
+	startKey := []byte("")
+	for rs := db.Hscan("user", startKey, 100); rs.State == "ok"; rs = db.Hscan("user", startKey, 100) {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			startKey = rs.Data[i]
+			uobj := User{}
+			json.Unmarshal(rs.Data[i+1], &uobj)
+			if len(uobj.Notice) == 0 {
+				continue
+			}
+
+			// 旧数据新的在前，不知道是回复还是提到
+			aids := strings.Split(uobj.Notice, ",")
+			tb := notificationTb(uobj.ID)
+			for j := len(aids) - 1; j >= 0; j-- {
+				aid, err := strconv.ParseUint(aids[j], 10, 64)
+				if err != nil {
+					continue
+				}
+				obj := Notification{
+					UID:     uobj.ID,
+					Type:    NotificationLegacy,
+					AID:     aid,
+					AddTime: now,
+				}
+				obj.ID, _ = db.HnextSequence(tb)
+				jb, _ := json.Marshal(obj)
+				db.Hset(tb, youdb.I2b(obj.ID), jb)
+			}
+
+			uobj.Notice = ""
+			jb, _ := json.Marshal(uobj)
+			db.Hset("user", youdb.I2b(uobj.ID), jb)
+			NotificationPrune(db, uobj.ID)
+			n++
+		}
+	}
+
+	db.Hset("migration", []byte("notification"), youdb.I2b(now))
+	return n
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/ego008/youdb"
+)
+
+// 第三方登录帐号
+// oauth_identity   hash  "<provider>:<openid>" -> OAuthIdentity
+// user_oauth:<uid> hash  provider -> openid，一个用户每种登录方式只绑定一个帐号
+
+type OAuthIdentity struct {
+	Uid      uint64 `json:"uid"`
+	Provider string `json:"provider"`
+	Name     string `json:"name"`
+	Openid   string `json:"openid"`
+	AddTime  uint64 `json:"addtime"`
+}
+
+func oauthIdentityKey(provider, openid string) []byte {
+	return []byte(provider + ":" + openid)
+}
+
+func OAuthIdentityGet(db *youdb.DB, provider, openid string) (OAuthIdentity, bool) {
+	obj := OAuthIdentity{}
+	rs := db.Hget("oauth_identity", oauthIdentityKey(provider, openid))
+	if rs.State != "ok" {
+		return obj, false
+	}
+	json.Unmarshal(rs.Data[0], &obj)
+	return obj, true
+}
+
+// OAuthIdentitySet 保存第三方帐号和用户的对应关系，用户原来绑定的同类帐号被替换
+func OAuthIdentitySet(db *youdb.DB, obj OAuthIdentity) {
+	if obj.AddTime == 0 {
+		obj.AddTime = uint64(time.Now().UTC().Unix())
+	}
+	tb := "user_oauth:" + strconv.FormatUint(obj.Uid, 10)
+	if rs := db.Hget(tb, []byte(obj.Provider)); rs.State == "ok" && string(rs.Data[0]) != obj.Openid {
+		db.Hdel("oauth_identity", oauthIdentityKey(obj.Provider, string(rs.Data[0])))
+	}
+	jb, _ := json.Marshal(obj)
+	db.Hset("oauth_identity", oauthIdentityKey(obj.Provider, obj.Openid), jb)
+	db.Hset(tb, []byte(obj.Provider), []byte(obj.Openid))
+}
+
+// OAuthIdentityMigrate 把旧的 oauth_qq、oauth_weibo 数据复制到 oauth_identity，返回转移的条数
+// 旧表原样保留以便回退，keyValue 里的 oauth_identity_migrated 标记已经迁移过
+func OAuthIdentityMigrate(db *youdb.DB) int {
+	if db.Hget("keyValue", []byte("oauth_identity_migrated")).State == "ok" {
+		return 0
+	}
+
+	n := 0
+	olds := []struct {
+		provider, tb string
+	}{
+		{"qq", "oauth_qq"},
+		{"weibo", "oauth_weibo"},
+	}
+	for _, old := range olds {
+		startKey := []byte("")
+		for rs := db.Hscan(old.tb, startKey, 100); rs.State == "ok"; rs = db.Hscan(old.tb, startKey, 100) {
+			for i := 0; i < (len(rs.Data) - 1); i += 2 {
+				startKey = rs.Data[i]
+				obj := OAuthIdentity{}
+				json.Unmarshal(rs.Data[i+1], &obj)
+				if obj.Uid == 0 {
+					continue
+				}
+				obj.Provider = old.provider
+				obj.Openid = string(rs.Data[i])
+				OAuthIdentitySet(db, obj)
+				n++
+			}
+		}
+	}
+
+	db.Hset("keyValue", []byte("oauth_identity_migrated"), youdb.I2b(uint64(time.Now().UTC().Unix())))
+	return n
+}
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ego008/youdb"
+)
+
+// 找回密码
+// password_reset         hash  sha256(token) -> PasswordReset，库里不保存明文 token
+// password_reset_uid     hash  uid -> sha256(token)，每个用户只保留最新的一个
+// password_reset_expire  zset  sha256(token) -> 过期时间，cronjob 清除
+
+type PasswordReset struct {
+	UID      uint64 `json:"uid"`
+	SendTime int64  `json:"sendtime"`
+	Expire   int64  `json:"expire"`
+}
+
+func passwordResetKey(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+func passwordResetDel(db *youdb.DB, key []byte, uid uint64) {
+	db.Hdel("password_reset", key)
+	db.Zdel("password_reset_expire", key)
+	rs := db.Hget("password_reset_uid", youdb.I2b(uid))
+	if rs.State == "ok" && string(rs.Data[0]) == string(key) {
+		db.Hdel("password_reset_uid", youdb.I2b(uid))
+	}
+}
+
+// PasswordResetNew 生成一次性 token，ttl 秒后过期，旧 token 作废；距上次发送不足 interval 秒时返回错误
+func PasswordResetNew(db *youdb.DB, uid uint64, ttl, interval int) (string, error) {
+	now := time.Now().UTC().Unix()
+	rs := db.Hget("password_reset_uid", youdb.I2b(uid))
+	if rs.State == "ok" {
+		oldKey := rs.Data[0]
+		rs2 := db.Hget("password_reset", oldKey)
+		if rs2.State == "ok" {
+			old := PasswordReset{}
+			json.Unmarshal(rs2.Data[0], &old)
+			if old.SendTime+int64(interval) > now {
+				return "", errors.New("send too frequently")
+			}
+		}
+		passwordResetDel(db, oldKey, uid)
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	key := passwordResetKey(token)
+
+	obj := PasswordReset{
+		UID:      uid,
+		SendTime: now,
+		Expire:   now + int64(ttl),
+	}
+	jb, _ := json.Marshal(obj)
+	db.Hset("password_reset", key, jb)
+	db.Hset("password_reset_uid", youdb.I2b(uid), key)
+	db.Zset("password_reset_expire", key, uint64(obj.Expire))
+	return token, nil
+}
+
+// PasswordResetGet 查看 token 是否有效，不消耗 token
+func PasswordResetGet(db *youdb.DB, token string) (User, error) {
+	rs := db.Hget("password_reset", passwordResetKey(token))
+	if rs.State != "ok" {
+		return User{}, errors.New("链接无效或已使用")
+	}
+	obj := PasswordReset{}
+	json.Unmarshal(rs.Data[0], &obj)
+	if obj.Expire < time.Now().UTC().Unix() {
+		return User{}, errors.New("链接已过期")
+	}
+	return UserGetByID(db, obj.UID)
+}
+
+// PasswordResetUse 校验并作废 token，返回对应用户
+func PasswordResetUse(db *youdb.DB, token string) (User, error) {
+	uobj, err := PasswordResetGet(db, token)
+	if err != nil {
+		return uobj, err
+	}
+	passwordResetDel(db, passwordResetKey(token), uobj.ID)
+	return uobj, nil
+}
+
+// PasswordResetExpire 清除过期的 token，返回清除的条数
+func PasswordResetExpire(db *youdb.DB, limit int) int {
+	rs := db.Zrscan("password_reset_expire", []byte(""), youdb.I2b(uint64(time.Now().UTC().Unix())), limit)
+	if rs.State != "ok" {
+		return 0
+	}
+	n := 0
+	for i := 0; i < (len(rs.Data) - 1); i += 2 {
+		key := rs.Data[i]
+		obj := PasswordReset{}
+		if rs2 := db.Hget("password_reset", key); rs2.State == "ok" {
+			json.Unmarshal(rs2.Data[0], &obj)
+		}
+		passwordResetDel(db, key, obj.UID)
+		n++
+	}
+	return n
+}
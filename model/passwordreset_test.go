@@ -0,0 +1,98 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/ego008/youdb"
+)
+
+func TestPasswordResetUse(t *testing.T) {
+	db := testDB(t)
+	testHset(t, db, "user", youdb.I2b(1), User{ID: 1, Name: "alice"})
+
+	token, err := PasswordResetNew(db, 1, 600, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uobj, err := PasswordResetGet(db, token); err != nil || uobj.ID != 1 {
+		t.Fatalf("PasswordResetGet = %d, %v", uobj.ID, err)
+	}
+	// 查看不消耗 token
+	if uobj, err := PasswordResetUse(db, token); err != nil || uobj.ID != 1 {
+		t.Fatalf("PasswordResetUse = %d, %v", uobj.ID, err)
+	}
+	if _, err := PasswordResetUse(db, token); err == nil {
+		t.Error("token used twice")
+	}
+	if _, err := PasswordResetGet(db, token); err == nil {
+		t.Error("used token still valid")
+	}
+	if db.Hget("password_reset_uid", youdb.I2b(1)).State == "ok" {
+		t.Error("password_reset_uid left after use")
+	}
+	if db.Zget("password_reset_expire", passwordResetKey(token)).State == "ok" {
+		t.Error("password_reset_expire left after use")
+	}
+
+	// 新 token 让旧的作废
+	old, _ := PasswordResetNew(db, 1, 600, 0)
+	token, _ = PasswordResetNew(db, 1, 600, 0)
+	if _, err := PasswordResetUse(db, old); err == nil {
+		t.Error("replaced token accepted")
+	}
+	if _, err := PasswordResetUse(db, token); err != nil {
+		t.Errorf("latest token rejected: %v", err)
+	}
+
+	if _, err := PasswordResetUse(db, "not-a-token"); err == nil {
+		t.Error("unknown token accepted")
+	}
+}
+
+func TestPasswordResetInterval(t *testing.T) {
+	db := testDB(t)
+	testHset(t, db, "user", youdb.I2b(1), User{ID: 1, Name: "alice"})
+
+	token, err := PasswordResetNew(db, 1, 600, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PasswordResetNew(db, 1, 600, 60); err == nil {
+		t.Error("second token within interval")
+	}
+	if _, err := PasswordResetGet(db, token); err != nil {
+		t.Errorf("first token invalidated by a refused request: %v", err)
+	}
+}
+
+func TestPasswordResetExpire(t *testing.T) {
+	db := testDB(t)
+	testHset(t, db, "user", youdb.I2b(1), User{ID: 1, Name: "alice"})
+	testHset(t, db, "user", youdb.I2b(2), User{ID: 2, Name: "bob"})
+
+	expired, err := PasswordResetNew(db, 1, -10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, _ := PasswordResetNew(db, 2, 600, 0)
+
+	if _, err := PasswordResetGet(db, expired); err == nil {
+		t.Error("expired token valid")
+	}
+	if _, err := PasswordResetUse(db, expired); err == nil {
+		t.Error("expired token used")
+	}
+
+	if n := PasswordResetExpire(db, 100); n != 1 {
+		t.Errorf("PasswordResetExpire = %d, want 1", n)
+	}
+	if db.Hget("password_reset", passwordResetKey(expired)).State == "ok" {
+		t.Error("expired token not cleared")
+	}
+	if db.Hget("password_reset_uid", youdb.I2b(1)).State == "ok" {
+		t.Error("password_reset_uid of expired token not cleared")
+	}
+	if uobj, err := PasswordResetUse(db, live); err != nil || uobj.ID != 2 {
+		t.Errorf("live token after cleanup = %d, %v", uobj.ID, err)
+	}
+}
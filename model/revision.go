@@ -0,0 +1,148 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/util"
+)
+
+// 修订历史
+// article_revision:<aid>        hash  rid -> Revision
+// comment_revision:<aid>:<cid>  hash  rid -> Revision
+// 第一次修改时先保存原始版本，之后每次修改保存修改后的版本
+
+type Revision struct {
+	ID           uint64 `json:"id"`
+	UID          uint64 `json:"uid"` // 编辑者
+	CategoryID   uint64 `json:"cid"` // 文章所在分类，评论的修订没有
+	Title        string `json:"title"`
+	Content      string `json:"content"`
+	Tags         string `json:"tags"`
+	CloseComment bool   `json:"closecomment"`
+	ClientIP     string `json:"clientip"`
+	AddTime      uint64 `json:"addtime"`
+	Rollback     uint64 `json:"rollback"` // 回滚自哪个版本
+}
+
+type RevisionListItem struct {
+	Revision
+	Name       string
+	AddTimeFmt string
+}
+
+func ArticleRevisionTb(aid uint64) string {
+	return "article_revision:" + strconv.FormatUint(aid, 10)
+}
+
+func CommentRevisionTb(aid, cid uint64) string {
+	return "comment_revision:" + strconv.FormatUint(aid, 10) + ":" + strconv.FormatUint(cid, 10)
+}
+
+func revisionAdd(db *youdb.DB, tb string, obj Revision) Revision {
+	obj.ID, _ = db.HnextSequence(tb)
+	jb, _ := json.Marshal(obj)
+	db.Hset(tb, youdb.I2b(obj.ID), jb)
+	return obj
+}
+
+// ArticleRevisionAdd 保存文章修改后的版本，oldObj 为修改前的文章
+func ArticleRevisionAdd(db *youdb.DB, oldObj, aobj Article, uid uint64, clientIP string, rollback uint64) {
+	tb := ArticleRevisionTb(aobj.ID)
+	if db.Hsequence(tb) == 0 {
+		revisionAdd(db, tb, Revision{
+			UID:          oldObj.UID,
+			CategoryID:   oldObj.CID,
+			Title:        oldObj.Title,
+			Content:      oldObj.Content,
+			Tags:         oldObj.Tags,
+			CloseComment: oldObj.CloseComment,
+			ClientIP:     oldObj.ClientIP,
+			AddTime:      oldObj.AddTime,
+		})
+	}
+	revisionAdd(db, tb, Revision{
+		UID:          uid,
+		CategoryID:   aobj.CID,
+		Title:        aobj.Title,
+		Content:      aobj.Content,
+		Tags:         aobj.Tags,
+		CloseComment: aobj.CloseComment,
+		ClientIP:     clientIP,
+		AddTime:      uint64(time.Now().UTC().Unix()),
+		Rollback:     rollback,
+	})
+}
+
+// CommentRevisionAdd 保存评论修改后的版本，oldObj 为修改前的评论
+func CommentRevisionAdd(db *youdb.DB, oldObj, cobj Comment, uid uint64, clientIP string, rollback uint64) {
+	tb := CommentRevisionTb(cobj.AID, cobj.ID)
+	if db.Hsequence(tb) == 0 {
+		revisionAdd(db, tb, Revision{
+			UID:      oldObj.UID,
+			Content:  oldObj.Content,
+			ClientIP: oldObj.ClientIP,
+			AddTime:  oldObj.AddTime,
+		})
+	}
+	revisionAdd(db, tb, Revision{
+		UID:      uid,
+		Content:  cobj.Content,
+		ClientIP: clientIP,
+		AddTime:  uint64(time.Now().UTC().Unix()),
+		Rollback: rollback,
+	})
+}
+
+func RevisionGet(db *youdb.DB, tb string, rid uint64) (Revision, error) {
+	obj := Revision{}
+	rs := db.Hget(tb, youdb.I2b(rid))
+	if rs.State != "ok" {
+		return obj, errors.New(rs.State)
+	}
+	if err := json.Unmarshal(rs.Data[0], &obj); err != nil {
+		return obj, err
+	}
+	return obj, nil
+}
+
+// RevisionList 全部版本，新的在前
+func RevisionList(db *youdb.DB, tb string, tz int) []RevisionListItem {
+	var items []RevisionListItem
+	userMap := map[uint64]UserMini{}
+	var userKeys [][]byte
+
+	startKey := []byte("")
+	for rs := db.Hrscan(tb, startKey, 100); rs.State == "ok"; rs = db.Hrscan(tb, startKey, 100) {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			startKey = rs.Data[i]
+			item := RevisionListItem{}
+			json.Unmarshal(rs.Data[i+1], &item.Revision)
+			item.AddTimeFmt = util.TimeFmt(item.AddTime, "2006-01-02 15:04", tz)
+			items = append(items, item)
+			if _, ok := userMap[item.UID]; !ok {
+				userMap[item.UID] = UserMini{}
+				userKeys = append(userKeys, youdb.I2b(item.UID))
+			}
+		}
+	}
+
+	if len(userKeys) > 0 {
+		rs := db.Hmget("user", userKeys)
+		if rs.State == "ok" {
+			for i := 0; i < (len(rs.Data) - 1); i += 2 {
+				item := UserMini{}
+				json.Unmarshal(rs.Data[i+1], &item)
+				userMap[item.ID] = item
+			}
+		}
+		for i, item := range items {
+			items[i].Name = userMap[item.UID].Name
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,454 @@
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/util"
+)
+
+// 全文索引
+// search_term:<term>   hash  aid -> 权重
+// search_term_num      zset  term -> 包含该词的文章数
+// search_article_term  hash  aid -> 文章已索引的词及权重 (json)
+
+const (
+	searchTitleWeight   = 5
+	searchBatch         = 1000
+	searchMaxCandidates = 100000 // 求交集时最多扫描的文章数
+	searchBM25K1        = 1.2
+	searchCacheTTL      = 300
+	searchCacheMax      = 200
+)
+
+type searchHit struct {
+	ID    uint64
+	Score uint64
+}
+
+func searchArticleTermGet(db *youdb.DB, aidB []byte) map[string]uint64 {
+	terms := map[string]uint64{}
+	rs := db.Hget("search_article_term", aidB)
+	if rs.State == "ok" {
+		json.Unmarshal(rs.Data[0], &terms)
+	}
+	return terms
+}
+
+// searchIndexSet 用 terms 替换文章的索引，调用方须持有 searchIndexMu
+func searchIndexSet(db *youdb.DB, aid uint64, terms map[string]uint64) {
+	atomic.AddUint64(&searchGen, 1)
+	aidB := youdb.I2b(aid)
+	oldTerms := searchArticleTermGet(db, aidB)
+
+	for term := range oldTerms {
+		if _, ok := terms[term]; !ok {
+			db.Hdel("search_term:"+term, aidB)
+			if n, _ := db.Zincr("search_term_num", []byte(term), -1); n == 0 {
+				db.Zdel("search_term_num", []byte(term))
+			}
+		}
+	}
+	for term, weight := range terms {
+		oldWeight, ok := oldTerms[term]
+		if !ok {
+			db.Zincr("search_term_num", []byte(term), 1)
+		}
+		if oldWeight != weight {
+			db.Hset("search_term:"+term, aidB, youdb.I2b(weight))
+		}
+	}
+
+	if len(terms) == 0 {
+		db.Hdel("search_article_term", aidB)
+		return
+	}
+	jb, _ := json.Marshal(terms)
+	db.Hset("search_article_term", aidB, jb)
+}
+
+func searchTermsMerge(dst map[string]uint64, input string, weight uint64) {
+	for term, n := range util.SearchTerms(input) {
+		dst[term] += n * weight
+	}
+}
+
+// SearchIndexArticle 重建一篇文章的索引，包括标题、内容和全部评论
+func SearchIndexArticle(db *youdb.DB, aid uint64) {
+	searchIndexMu.Lock()
+	defer searchIndexMu.Unlock()
+	searchIndexArticle(db, aid)
+}
+
+func searchIndexArticle(db *youdb.DB, aid uint64) {
+	aobj, err := ArticleGetByID(db, strconv.FormatUint(aid, 10))
+	if err != nil || aobj.Hidden {
+		searchIndexSet(db, aid, nil)
+		return
+	}
+
+	terms := map[string]uint64{}
+	searchTermsMerge(terms, aobj.Title, searchTitleWeight)
+	searchTermsMerge(terms, aobj.Content, 1)
+
+	tb := "article_comment:" + strconv.FormatUint(aid, 10)
+	startKey := []byte("")
+	for rs := db.Hscan(tb, startKey, 100); rs.State == "ok"; rs = db.Hscan(tb, startKey, 100) {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			startKey = rs.Data[i]
+			item := Comment{}
+			json.Unmarshal(rs.Data[i+1], &item)
+			searchTermsMerge(terms, item.Content, 1)
+		}
+	}
+
+	searchIndexSet(db, aid, terms)
+}
+
+// SearchIndexComment 把一条新评论追加到文章的索引
+func SearchIndexComment(db *youdb.DB, aid uint64, content string) {
+	searchIndexMu.Lock()
+	defer searchIndexMu.Unlock()
+	terms := searchArticleTermGet(db, youdb.I2b(aid))
+	if len(terms) == 0 {
+		searchIndexArticle(db, aid)
+		return
+	}
+	searchTermsMerge(terms, content, 1)
+	searchIndexSet(db, aid, terms)
+}
+
+// SearchIndexRemove 从索引中移除文章
+func SearchIndexRemove(db *youdb.DB, aid uint64) {
+	searchIndexMu.Lock()
+	defer searchIndexMu.Unlock()
+	searchIndexSet(db, aid, nil)
+}
+
+// SearchRebuildIndex 重建全部索引，返回已索引的文章数。
+// 逐篇覆盖旧索引，最后删去已不存在的文章，重建过程中搜索仍然可用
+func SearchRebuildIndex(db *youdb.DB) int {
+	if !atomic.CompareAndSwapInt32(&searchRebuilding, 0, 1) {
+		return -1
+	}
+	defer atomic.StoreInt32(&searchRebuilding, 0)
+
+	indexed := map[uint64]bool{}
+	startKey := []byte("")
+	for rs := db.Hscan("article", startKey, 100); rs.State == "ok"; rs = db.Hscan("article", startKey, 100) {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			startKey = rs.Data[i]
+			item := ArticleMini{}
+			json.Unmarshal(rs.Data[i+1], &item)
+			if item.Hidden {
+				continue
+			}
+			SearchIndexArticle(db, item.ID)
+			indexed[item.ID] = true
+		}
+	}
+
+	var stale []uint64
+	startKey = []byte("")
+	for rs := db.Hscan("search_article_term", startKey, 100); rs.State == "ok"; rs = db.Hscan("search_article_term", startKey, 100) {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			startKey = rs.Data[i]
+			if aid := youdb.B2i(rs.Data[i]); !indexed[aid] {
+				stale = append(stale, aid)
+			}
+		}
+	}
+	// 扫描文章时已经过去的位置上可能又有文章发表或恢复，按文章现在的状态重建，不存在或隐藏的才删掉
+	searchIndexMu.Lock()
+	defer searchIndexMu.Unlock()
+	for _, aid := range stale {
+		searchIndexArticle(db, aid)
+	}
+	return len(indexed)
+}
+
+// SearchRebuildRunning 是否正在重建索引
+func SearchRebuildRunning() bool {
+	return atomic.LoadInt32(&searchRebuilding) == 1
+}
+
+// searchRank 返回同时包含全部查询词的文章，按相关度从高到低排序。
+// 从最少见的词的全部文章里求交集，超过 searchMaxCandidates 篇时只取最新的，truncated 为 true。
+// 结果缓存 searchCacheTTL 秒，翻页时不用重新计算，索引有变化时作废
+func searchRank(db *youdb.DB, terms []string) (hits []searchHit, truncated bool) {
+	if len(terms) == 0 {
+		return nil, false
+	}
+
+	cacheKey := strings.Join(terms, " ")
+	if item, ok := searchCacheGet(cacheKey); ok {
+		return item.hits, item.truncated
+	}
+	gen := atomic.LoadUint64(&searchGen)
+
+	docNum := float64(db.Hsequence("article"))
+	idf := map[string]float64{}
+	rareTerm := ""
+	var rareNum uint64
+	for _, term := range terms {
+		df := db.Zget("search_term_num", []byte(term)).Uint64()
+		if df == 0 {
+			return nil, false
+		}
+		idf[term] = math.Log(1 + docNum/float64(df))
+		if rareTerm == "" || df < rareNum {
+			rareTerm = term
+			rareNum = df
+		}
+	}
+
+	scoreMap := map[uint64]float64{}
+	scanned := 0
+	keyStart := []byte("")
+	for scanned < searchMaxCandidates {
+		rs := db.Hrscan("search_term:"+rareTerm, keyStart, searchBatch)
+		if rs.State != "ok" || len(rs.Data) < 2 {
+			break
+		}
+		batch := map[uint64]float64{}
+		var keys [][]byte
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			keyStart = rs.Data[i]
+			keys = append(keys, rs.Data[i])
+			batch[youdb.B2i(rs.Data[i])] = searchTermScore(youdb.B2i(rs.Data[i+1]), idf[rareTerm])
+		}
+		scanned += len(keys)
+		searchIntersect(db, batch, keys, terms, rareTerm, idf)
+		for aid, score := range batch {
+			scoreMap[aid] = score
+		}
+		if len(keys) < searchBatch {
+			break
+		}
+	}
+	truncated = uint64(scanned) < rareNum && scanned >= searchMaxCandidates
+
+	hits = make([]searchHit, 0, len(scoreMap))
+	for aid, score := range scoreMap {
+		hits = append(hits, searchHit{ID: aid, Score: uint64(score * 1000)})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID > hits[j].ID
+	})
+
+	searchCacheSet(cacheKey, gen, hits, truncated)
+	return hits, truncated
+}
+
+// searchIntersect 在 scoreMap 里去掉不含其它查询词的文章，并累加得分
+func searchIntersect(db *youdb.DB, scoreMap map[uint64]float64, keys [][]byte, terms []string, rareTerm string, idf map[string]float64) {
+	for _, term := range terms {
+		if term == rareTerm || len(scoreMap) == 0 {
+			continue
+		}
+		found := map[uint64]float64{}
+		rs := db.Hmget("search_term:"+term, keys)
+		if rs.State == "ok" {
+			for i := 0; i < (len(rs.Data) - 1); i += 2 {
+				found[youdb.B2i(rs.Data[i])] = searchTermScore(youdb.B2i(rs.Data[i+1]), idf[term])
+			}
+		}
+		keys = keys[:0]
+		for aid, score := range scoreMap {
+			if s, ok := found[aid]; ok {
+				scoreMap[aid] = score + s
+				keys = append(keys, youdb.I2b(aid))
+			} else {
+				delete(scoreMap, aid)
+			}
+		}
+	}
+}
+
+type searchCacheItem struct {
+	gen       uint64
+	expire    int64
+	hits      []searchHit
+	truncated bool
+}
+
+var (
+	searchIndexMu    sync.Mutex // 修改索引是先读后写，同一时间只能有一个
+	searchRebuilding int32
+	searchGen        uint64 // 索引每次变化加一
+	searchCacheMu    sync.Mutex
+	searchCache      = map[string]searchCacheItem{}
+)
+
+func searchCacheGet(key string) (searchCacheItem, bool) {
+	searchCacheMu.Lock()
+	defer searchCacheMu.Unlock()
+	item, ok := searchCache[key]
+	if !ok || item.gen != atomic.LoadUint64(&searchGen) || item.expire < time.Now().Unix() {
+		return item, false
+	}
+	return item, true
+}
+
+func searchCacheSet(key string, gen uint64, hits []searchHit, truncated bool) {
+	searchCacheMu.Lock()
+	defer searchCacheMu.Unlock()
+	if len(searchCache) >= searchCacheMax {
+		searchCache = map[string]searchCacheItem{}
+	}
+	searchCache[key] = searchCacheItem{
+		gen:       gen,
+		expire:    time.Now().Unix() + searchCacheTTL,
+		hits:      hits,
+		truncated: truncated,
+	}
+}
+
+func searchTermScore(tf uint64, idf float64) float64 {
+	f := float64(tf)
+	return idf * f * (searchBM25K1 + 1) / (f + searchBM25K1)
+}
+
+// searchHitAfter hits 中排在游标 (key, score) 之后的第一个位置
+func searchHitAfter(hits []searchHit, key, score uint64) int {
+	return sort.Search(len(hits), func(i int) bool {
+		return hits[i].Score < score || (hits[i].Score == score && hits[i].ID < key)
+	})
+}
+
+// ArticleSearchList 搜索结果的一页，第二个返回值为结果是否不完整
+func ArticleSearchList(db *youdb.DB, cmd, q, key, score string, limit, tz int) (ArticlePageInfo, bool) {
+	var items []ArticleListItem
+	var hasPrev, hasNext bool
+	var firstKey, firstScore, lastKey, lastScore uint64
+
+	hits, truncated := searchRank(db, util.SearchQueryTerms(q))
+
+	start, end := 0, len(hits)
+	if len(key) > 0 {
+		keyI, _ := strconv.ParseUint(key, 10, 64)
+		scoreI, _ := strconv.ParseUint(score, 10, 64)
+		pos := searchHitAfter(hits, keyI, scoreI)
+		if cmd == "prev" {
+			// 游标本身及之后的不要
+			for pos > 0 && hits[pos-1].ID == keyI && hits[pos-1].Score == scoreI {
+				pos--
+			}
+			end = pos
+			start = end - limit
+			if start < 0 {
+				start = 0
+			}
+		} else {
+			start = pos
+		}
+	}
+	if end-start > limit {
+		end = start + limit
+	}
+	hasPrev = start > 0
+	hasNext = end < len(hits)
+	hits = hits[start:end]
+
+	if len(hits) > 0 {
+		keys := make([][]byte, len(hits))
+		for i, hit := range hits {
+			keys[i] = youdb.I2b(hit.ID)
+		}
+
+		articleMap := map[uint64]ArticleMini{}
+		userMap := map[uint64]UserMini{}
+		categoryMap := map[uint64]CategoryMini{}
+
+		rs := db.Hmget("article", keys)
+		if rs.State == "ok" {
+			for i := 0; i < (len(rs.Data) - 1); i += 2 {
+				item := ArticleMini{}
+				json.Unmarshal(rs.Data[i+1], &item)
+				if !item.Hidden {
+					articleMap[item.ID] = item
+					userMap[item.UID] = UserMini{}
+					if item.RUID > 0 {
+						userMap[item.RUID] = UserMini{}
+					}
+					categoryMap[item.CID] = CategoryMini{}
+				}
+			}
+		}
+
+		userKeys := make([][]byte, 0, len(userMap))
+		for k := range userMap {
+			userKeys = append(userKeys, youdb.I2b(k))
+		}
+		rs = db.Hmget("user", userKeys)
+		if rs.State == "ok" {
+			for i := 0; i < (len(rs.Data) - 1); i += 2 {
+				item := UserMini{}
+				json.Unmarshal(rs.Data[i+1], &item)
+				userMap[item.ID] = item
+			}
+		}
+
+		categoryKeys := make([][]byte, 0, len(categoryMap))
+		for k := range categoryMap {
+			categoryKeys = append(categoryKeys, youdb.I2b(k))
+		}
+		rs = db.Hmget("category", categoryKeys)
+		if rs.State == "ok" {
+			for i := 0; i < (len(rs.Data) - 1); i += 2 {
+				item := CategoryMini{}
+				json.Unmarshal(rs.Data[i+1], &item)
+				categoryMap[item.ID] = item
+			}
+		}
+
+		for _, hit := range hits {
+			article, ok := articleMap[hit.ID]
+			if !ok {
+				continue
+			}
+			user := userMap[article.UID]
+			category := categoryMap[article.CID]
+			item := ArticleListItem{
+				ID:          article.ID,
+				UID:         article.UID,
+				Name:        user.Name,
+				Avatar:      user.Avatar,
+				CID:         article.CID,
+				Cname:       category.Name,
+				RUID:        article.RUID,
+				Title:       article.Title,
+				EditTime:    article.EditTime,
+				EditTimeFmt: util.TimeFmt(article.EditTime, "2006-01-02 15:04", tz),
+				Comments:    article.Comments,
+			}
+			if article.RUID > 0 {
+				item.Rname = userMap[article.RUID].Name
+			}
+			items = append(items, item)
+		}
+
+		firstKey, firstScore = hits[0].ID, hits[0].Score
+		lastKey, lastScore = hits[len(hits)-1].ID, hits[len(hits)-1].Score
+	}
+
+	return ArticlePageInfo{
+		Items:      items,
+		HasPrev:    hasPrev,
+		HasNext:    hasNext,
+		FirstKey:   firstKey,
+		FirstScore: firstScore,
+		LastKey:    lastKey,
+		LastScore:  lastScore,
+	}, truncated
+}
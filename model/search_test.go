@@ -0,0 +1,134 @@
+package model
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/ego008/youdb"
+)
+
+func TestSearchHitAfter(t *testing.T) {
+	// 按分数从高到低、同分时 ID 从大到小排好的结果
+	hits := []searchHit{{9, 50}, {7, 50}, {8, 30}, {3, 30}, {5, 10}, {2, 10}, {1, 10}}
+	tests := []struct {
+		name       string
+		key, score uint64
+		want       int
+	}{
+		{"first", 9, 50, 1},
+		{"same score", 7, 50, 2},
+		{"score boundary", 3, 30, 4},
+		{"last", 1, 10, 7},
+		{"missing key between same score", 6, 50, 2},
+		{"missing key lower score", 4, 30, 3},
+		{"above all", 100, 100, 0},
+		{"below all", 0, 0, 7},
+		{"missing score", 10, 20, 4},
+	}
+	for _, tt := range tests {
+		if got := searchHitAfter(hits, tt.key, tt.score); got != tt.want {
+			t.Errorf("%s: searchHitAfter(%d, %d) = %d, want %d", tt.name, tt.key, tt.score, got, tt.want)
+		}
+	}
+	if got := searchHitAfter(nil, 1, 1); got != 0 {
+		t.Errorf("searchHitAfter(nil) = %d, want 0", got)
+	}
+
+	// 用每页最后一条作游标逐页往后翻，每条结果正好出现一次
+	for limit := 1; limit <= len(hits); limit++ {
+		var got []searchHit
+		pos := 0
+		for pos < len(hits) {
+			end := pos + limit
+			if end > len(hits) {
+				end = len(hits)
+			}
+			page := hits[pos:end]
+			got = append(got, page...)
+			last := page[len(page)-1]
+			pos = searchHitAfter(hits, last.ID, last.Score)
+		}
+		if len(got) != len(hits) {
+			t.Errorf("limit %d: paged %d hits, want %d", limit, len(got), len(hits))
+			continue
+		}
+		for i := range hits {
+			if got[i] != hits[i] {
+				t.Errorf("limit %d: hit %d = %v, want %v", limit, i, got[i], hits[i])
+			}
+		}
+	}
+}
+
+func searchTestArticle(t *testing.T, db *youdb.DB, title string) uint64 {
+	aid, _ := db.HnextSequence("article")
+	testHset(t, db, "article", youdb.I2b(aid), Article{ID: aid, Title: title})
+	return aid
+}
+
+// 同一篇文章并发追加评论，索引和词的文章数不能错乱
+func TestSearchIndexConcurrent(t *testing.T) {
+	db := testDB(t)
+	aid := searchTestArticle(t, db, "kani")
+	SearchIndexArticle(db, aid)
+
+	const n = 50
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			SearchIndexComment(db, aid, "reply w"+strconv.Itoa(i))
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	terms := searchArticleTermGet(db, youdb.I2b(aid))
+	if terms["reply"] != n {
+		t.Errorf("reply weight %d, want %d", terms["reply"], n)
+	}
+	for i := 0; i < n; i++ {
+		term := "w" + strconv.Itoa(i)
+		if terms[term] != 1 {
+			t.Errorf("%s missing from article terms", term)
+		}
+		if rs := db.Zget("search_term_num", []byte(term)); rs.State != "ok" || youdb.B2i(rs.Data[0]) != 1 {
+			t.Errorf("search_term_num %s not 1", term)
+		}
+	}
+	if rs := db.Zget("search_term_num", []byte("reply")); rs.State != "ok" || youdb.B2i(rs.Data[0]) != 1 {
+		t.Error("search_term_num reply not 1")
+	}
+}
+
+// 重建索引时删去不存在和隐藏的文章，还在的文章保留
+func TestSearchRebuildIndex(t *testing.T) {
+	db := testDB(t)
+	live := searchTestArticle(t, db, "live kani")
+	gone := searchTestArticle(t, db, "gone kani")
+	hidden := searchTestArticle(t, db, "hidden kani")
+	for _, aid := range []uint64{live, gone, hidden} {
+		SearchIndexArticle(db, aid)
+	}
+	db.Hdel("article", youdb.I2b(gone))
+	testHset(t, db, "article", youdb.I2b(hidden), Article{ID: hidden, Title: "hidden kani", Hidden: true})
+
+	if n := SearchRebuildIndex(db); n != 1 {
+		t.Errorf("SearchRebuildIndex = %d, want 1", n)
+	}
+	for aid, want := range map[uint64]bool{live: true, gone: false, hidden: false} {
+		if got := db.Hget("search_term:kani", youdb.I2b(aid)).State == "ok"; got != want {
+			t.Errorf("aid %d indexed %v, want %v", aid, got, want)
+		}
+	}
+	if rs := db.Zget("search_term_num", []byte("kani")); rs.State != "ok" || youdb.B2i(rs.Data[0]) != 1 {
+		t.Error("search_term_num kani not 1")
+	}
+	if db.Zget("search_term_num", []byte("gone")).State == "ok" {
+		t.Error("search_term_num gone left after rebuild")
+	}
+}
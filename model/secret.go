@@ -0,0 +1,21 @@
+package model
+
+import (
+	"crypto/rand"
+
+	"github.com/ego008/youdb"
+)
+
+// SecretKey 取持久化的随机密钥，不存在时生成，用于邮件退订、验证链接等签名
+// 保存在 keyValue 的 secret_<name>，重启后链接仍然有效
+func SecretKey(db *youdb.DB, name string) []byte {
+	key := []byte("secret_" + name)
+	rs := db.Hget("keyValue", key)
+	if rs.State == "ok" && len(rs.Data[0]) > 0 {
+		return rs.Data[0]
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	db.Hset("keyValue", key, b)
+	return b
+}
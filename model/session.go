@@ -0,0 +1,207 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/util"
+)
+
+// 登录会话，每个设备一个
+// session           hash  sid -> Session
+// user_session:<uid> hash  sid -> ""
+// session_seen      zset  sid -> 最后活动时间，cronjob 清除长期不活动的会话
+// Cookie SessionID 为 uid:sid；User.Session 是旧的单会话字段，首次访问时转为会话记录
+
+type Session struct {
+	ID       string `json:"id"`
+	UID      uint64 `json:"uid"`
+	UA       string `json:"ua"`
+	IP       string `json:"ip"`
+	AddTime  uint64 `json:"addtime"`
+	LastTime uint64 `json:"lasttime"`
+}
+
+type SessionListItem struct {
+	Session
+	Key         string // 页面上用来代替 sid，不暴露 cookie 的值
+	Current     bool
+	AddTimeFmt  string
+	LastTimeFmt string
+}
+
+func sessionKey(sid string) string {
+	sum := sha256.Sum256([]byte(sid))
+	return hex.EncodeToString(sum[:8])
+}
+
+func userSessionTb(uid uint64) string {
+	return "user_session:" + strconv.FormatUint(uid, 10)
+}
+
+func sessionSave(db *youdb.DB, obj Session) {
+	jb, _ := json.Marshal(obj)
+	db.Hset("session", []byte(obj.ID), jb)
+	db.Hset(userSessionTb(obj.UID), []byte(obj.ID), []byte(""))
+	db.Zset("session_seen", []byte(obj.ID), obj.LastTime)
+}
+
+// SessionNew 登录时创建会话；sid 为空时随机生成，旧的 User.Session 迁移时传入原来的值
+func SessionNew(db *youdb.DB, uid uint64, sid, ua, ip string) Session {
+	if len(sid) == 0 {
+		b := make([]byte, 16)
+		rand.Read(b)
+		sid = hex.EncodeToString(b)
+	}
+	if len(ua) > 200 {
+		ua = ua[:200]
+	}
+	now := uint64(time.Now().UTC().Unix())
+	obj := Session{
+		ID:       sid,
+		UID:      uid,
+		UA:       ua,
+		IP:       ip,
+		AddTime:  now,
+		LastTime: now,
+	}
+	sessionSave(db, obj)
+	return obj
+}
+
+func SessionGet(db *youdb.DB, sid string) (Session, error) {
+	obj := Session{}
+	if len(sid) == 0 {
+		return obj, errors.New("empty sid")
+	}
+	rs := db.Hget("session", []byte(sid))
+	if rs.State != "ok" {
+		return obj, errors.New(rs.State)
+	}
+	err := json.Unmarshal(rs.Data[0], &obj)
+	return obj, err
+}
+
+// SessionTouch 更新最后活动时间和 IP
+func SessionTouch(db *youdb.DB, obj Session, ip string) {
+	obj.LastTime = uint64(time.Now().UTC().Unix())
+	obj.IP = ip
+	sessionSave(db, obj)
+}
+
+func SessionDel(db *youdb.DB, uid uint64, sid string) {
+	db.Hdel("session", []byte(sid))
+	db.Hdel(userSessionTb(uid), []byte(sid))
+	db.Zdel("session_seen", []byte(sid))
+}
+
+// SessionDelByUser 退出用户的所有会话，except 不为空时保留该会话，返回退出的个数
+func SessionDelByUser(db *youdb.DB, uid uint64, except string) int {
+	var sids []string
+	tb := userSessionTb(uid)
+	startKey := []byte("")
+	for rs := db.Hscan(tb, startKey, 100); rs.State == "ok"; rs = db.Hscan(tb, startKey, 100) {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			startKey = rs.Data[i]
+			if string(rs.Data[i]) != except {
+				sids = append(sids, string(rs.Data[i]))
+			}
+		}
+	}
+	for _, sid := range sids {
+		SessionDel(db, uid, sid)
+	}
+	return len(sids)
+}
+
+// SessionDelByKey 按列表里的 Key 退出一个会话
+func SessionDelByKey(db *youdb.DB, uid uint64, key string) bool {
+	var sid string
+	tb := userSessionTb(uid)
+	startKey := []byte("")
+	for rs := db.Hscan(tb, startKey, 100); rs.State == "ok" && len(sid) == 0; rs = db.Hscan(tb, startKey, 100) {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			startKey = rs.Data[i]
+			if sessionKey(string(rs.Data[i])) == key {
+				sid = string(rs.Data[i])
+				break
+			}
+		}
+	}
+	if len(sid) == 0 {
+		return false
+	}
+	SessionDel(db, uid, sid)
+	return true
+}
+
+// SessionListByUser 按最后活动时间从新到旧，current 为当前请求的 sid
+func SessionListByUser(db *youdb.DB, uid uint64, current string, tz int) []SessionListItem {
+	var items []SessionListItem
+	var keys [][]byte
+	tb := userSessionTb(uid)
+	startKey := []byte("")
+	for rs := db.Hscan(tb, startKey, 100); rs.State == "ok"; rs = db.Hscan(tb, startKey, 100) {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			startKey = rs.Data[i]
+			keys = append(keys, rs.Data[i])
+		}
+	}
+	if len(keys) == 0 {
+		return items
+	}
+
+	rs := db.Hmget("session", keys)
+	if rs.State == "ok" {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			obj := Session{}
+			json.Unmarshal(rs.Data[i+1], &obj)
+			item := SessionListItem{
+				Session:     obj,
+				Key:         sessionKey(obj.ID),
+				Current:     obj.ID == current,
+				AddTimeFmt:  util.TimeFmt(obj.AddTime, "2006-01-02 15:04", tz),
+				LastTimeFmt: util.TimeFmt(obj.LastTime, "2006-01-02 15:04", tz),
+			}
+			// 插入排序，会话数很少
+			j := len(items)
+			items = append(items, item)
+			for j > 0 && items[j-1].LastTime < item.LastTime {
+				items[j] = items[j-1]
+				j--
+			}
+			items[j] = item
+		}
+	}
+	return items
+}
+
+// SessionExpire 清除超过 keepDays 天没有活动的会话，返回清除的个数
+func SessionExpire(db *youdb.DB, keepDays, limit int) int {
+	if keepDays < 1 {
+		return 0
+	}
+	timeBefore := uint64(time.Now().UTC().Unix() - int64(keepDays)*86400)
+	rs := db.Zrscan("session_seen", []byte(""), youdb.I2b(timeBefore), limit)
+	if rs.State != "ok" {
+		return 0
+	}
+	n := 0
+	for i := 0; i < (len(rs.Data) - 1); i += 2 {
+		sid := string(rs.Data[i])
+		obj, err := SessionGet(db, sid)
+		if err == nil {
+			SessionDel(db, obj.UID, sid)
+		} else {
+			db.Zdel("session_seen", rs.Data[i])
+		}
+		n++
+	}
+	return n
+}
@@ -0,0 +1,55 @@
+package model
+
+import "testing"
+
+func TestSessionDelByUser(t *testing.T) {
+	db := testDB(t)
+	var sids []string
+	for i := 0; i < 3; i++ {
+		sids = append(sids, SessionNew(db, 1, "", "ua", "1.2.3.4").ID)
+	}
+	other := SessionNew(db, 2, "", "ua", "1.2.3.4").ID
+
+	// 保留当前会话
+	if n := SessionDelByUser(db, 1, sids[1]); n != 2 {
+		t.Errorf("SessionDelByUser(except) = %d, want 2", n)
+	}
+	for i, sid := range sids {
+		_, err := SessionGet(db, sid)
+		if (err == nil) != (i == 1) {
+			t.Errorf("session %d exists %v", i, err == nil)
+		}
+		if got := db.Hget(userSessionTb(1), []byte(sid)).State == "ok"; got != (i == 1) {
+			t.Errorf("user_session entry %d exists %v", i, got)
+		}
+		if got := db.Zget("session_seen", []byte(sid)).State == "ok"; got != (i == 1) {
+			t.Errorf("session_seen entry %d exists %v", i, got)
+		}
+	}
+	if items := SessionListByUser(db, 1, sids[1], 0); len(items) != 1 || !items[0].Current {
+		t.Errorf("SessionListByUser after except = %+v", items)
+	}
+
+	// except 不是该用户的会话时全部退出
+	if n := SessionDelByUser(db, 1, other); n != 1 {
+		t.Errorf("SessionDelByUser(other user's sid) = %d, want 1", n)
+	}
+	if _, err := SessionGet(db, sids[1]); err == nil {
+		t.Error("session left after deleting all")
+	}
+	if _, err := SessionGet(db, other); err != nil {
+		t.Errorf("other user's session removed: %v", err)
+	}
+
+	if n := SessionDelByUser(db, 1, ""); n != 0 {
+		t.Errorf("SessionDelByUser(no sessions) = %d, want 0", n)
+	}
+	SessionNew(db, 1, "", "ua", "1.2.3.4")
+	SessionNew(db, 1, "", "ua", "1.2.3.4")
+	if n := SessionDelByUser(db, 1, ""); n != 2 {
+		t.Errorf("SessionDelByUser(\"\") = %d, want 2", n)
+	}
+	if items := SessionListByUser(db, 1, "", 0); len(items) != 0 {
+		t.Errorf("%d sessions left", len(items))
+	}
+}
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ego008/youdb"
+)
+
+// 短信验证码
+// sms_code         hash  uid -> SmsCode
+// sms_code_expire  zset  uid -> 过期时间，cronjob 据此清除过期的验证码
+
+type SmsCode struct {
+	Telephone string `json:"telephone"`
+	Code      string `json:"code"`
+	Attempts  int    `json:"attempts"`
+	SendTime  int64  `json:"sendtime"`
+	Expire    int64  `json:"expire"`
+}
+
+func smsCodeGet(db *youdb.DB, uid uint64) (SmsCode, bool) {
+	obj := SmsCode{}
+	rs := db.Hget("sms_code", youdb.I2b(uid))
+	if rs.State != "ok" {
+		return obj, false
+	}
+	json.Unmarshal(rs.Data[0], &obj)
+	return obj, true
+}
+
+func smsCodeDel(db *youdb.DB, uid uint64) {
+	db.Hdel("sms_code", youdb.I2b(uid))
+	db.Zdel("sms_code_expire", youdb.I2b(uid))
+}
+
+// SmsCodeNew 生成 6 位验证码，ttl 秒后过期；距上次发送不足 interval 秒时返回错误
+func SmsCodeNew(db *youdb.DB, uid uint64, telephone string, ttl, interval int) (string, error) {
+	now := time.Now().UTC().Unix()
+	if old, ok := smsCodeGet(db, uid); ok && old.SendTime+int64(interval) > now {
+		return "", fmt.Errorf("发送太频繁，请 %d 秒后再试", old.SendTime+int64(interval)-now)
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	obj := SmsCode{
+		Telephone: telephone,
+		Code:      fmt.Sprintf("%06d", n.Int64()),
+		SendTime:  now,
+		Expire:    now + int64(ttl),
+	}
+	jb, _ := json.Marshal(obj)
+	db.Hset("sms_code", youdb.I2b(uid), jb)
+	db.Zset("sms_code_expire", youdb.I2b(uid), uint64(obj.Expire))
+	return obj.Code, nil
+}
+
+// SmsCodeCheck 校验验证码，成功或超过 maxAttempts 次后验证码作废
+func SmsCodeCheck(db *youdb.DB, uid uint64, telephone, code string, maxAttempts int) error {
+	obj, ok := smsCodeGet(db, uid)
+	if !ok || obj.Expire < time.Now().UTC().Unix() {
+		smsCodeDel(db, uid)
+		return errors.New("验证码已过期，请重新获取")
+	}
+	if obj.Telephone != telephone {
+		return errors.New("手机号码已修改，请重新获取验证码")
+	}
+	if obj.Code != code {
+		obj.Attempts++
+		if obj.Attempts >= maxAttempts {
+			smsCodeDel(db, uid)
+			return errors.New("验证码错误次数过多，请重新获取")
+		}
+		jb, _ := json.Marshal(obj)
+		db.Hset("sms_code", youdb.I2b(uid), jb)
+		return errors.New("验证码不正确")
+	}
+	smsCodeDel(db, uid)
+	return nil
+}
+
+// SmsCodeExpire 清除已过期的验证码，返回清除的条数
+func SmsCodeExpire(db *youdb.DB, limit int) int {
+	rs := db.Zrscan("sms_code_expire", []byte(""), youdb.I2b(uint64(time.Now().UTC().Unix())), limit)
+	if rs.State != "ok" {
+		return 0
+	}
+	n := 0
+	for i := 0; i < (len(rs.Data) - 1); i += 2 {
+		db.Hdel("sms_code", rs.Data[i])
+		db.Zdel("sms_code_expire", rs.Data[i])
+		n++
+	}
+	return n
+}
@@ -0,0 +1,167 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/util"
+)
+
+// 两步验证
+// totp_recovery:<uid>  hash  sha256(恢复码) -> ""，每个恢复码只能用一次
+// totp_login           hash  token -> TotpLogin，密码验证通过、等待输入验证码的登录
+// totp_login_expire    zset  token -> 过期时间，cronjob 清除
+
+type TotpLogin struct {
+	UID      uint64 `json:"uid"`
+	Attempts int    `json:"attempts"`
+	Expire   int64  `json:"expire"`
+}
+
+func totpRecoveryTb(uid uint64) string {
+	return "totp_recovery:" + strconv.FormatUint(uid, 10)
+}
+
+// 恢复码不区分大小写，忽略空格和 -
+func totpRecoveryKey(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+// TotpRecoveryNew 生成 n 个恢复码，旧的全部作废；明文只在这里返回一次
+func TotpRecoveryNew(db *youdb.DB, uid uint64, n int) ([]string, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(enc.EncodeToString(b))
+		codes = append(codes, s[:4]+"-"+s[4:])
+	}
+
+	TotpRecoveryClear(db, uid)
+	tb := totpRecoveryTb(uid)
+	for _, code := range codes {
+		db.Hset(tb, totpRecoveryKey(code), []byte(""))
+	}
+	return codes, nil
+}
+
+// TotpRecoveryClear 删除用户的全部恢复码
+func TotpRecoveryClear(db *youdb.DB, uid uint64) {
+	db.HdelBucket(totpRecoveryTb(uid))
+}
+
+// TotpRecoveryCount 剩余可用的恢复码个数
+func TotpRecoveryCount(db *youdb.DB, uid uint64) int {
+	rs := db.Hscan(totpRecoveryTb(uid), []byte(""), 100)
+	if rs.State != "ok" {
+		return 0
+	}
+	return len(rs.Data) / 2
+}
+
+// TotpVerify 校验验证码或恢复码，成功时更新 uobj 并保存；recovery 表示用掉了一个恢复码
+func TotpVerify(db *youdb.DB, uobj *User, code string) (ok, recovery bool) {
+	code = strings.TrimSpace(code)
+	if step, ok := util.TotpCheck(uobj.TotpSecret, code, time.Now().UTC()); ok {
+		if step <= uobj.TotpLastStep {
+			return false, false
+		}
+		uobj.TotpLastStep = step
+		jb, _ := json.Marshal(uobj)
+		db.Hset("user", youdb.I2b(uobj.ID), jb)
+		return true, false
+	}
+
+	tb := totpRecoveryTb(uobj.ID)
+	key := totpRecoveryKey(code)
+	if len(code) > 0 && db.Hget(tb, key).State == "ok" {
+		db.Hdel(tb, key)
+		return true, true
+	}
+	return false, false
+}
+
+// TotpDisable 关闭两步验证并删除恢复码，调用方负责保存 uobj
+func TotpDisable(db *youdb.DB, uobj *User) {
+	uobj.TotpEnabled = false
+	uobj.TotpSecret = ""
+	uobj.TotpLastStep = 0
+	TotpRecoveryClear(db, uobj.ID)
+}
+
+// TotpLoginNew 记录一个等待输入验证码的登录，ttl 秒后过期，返回放在 cookie 里的 token
+func TotpLoginNew(db *youdb.DB, uid uint64, ttl int) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	obj := TotpLogin{
+		UID:    uid,
+		Expire: time.Now().UTC().Unix() + int64(ttl),
+	}
+	jb, _ := json.Marshal(obj)
+	db.Hset("totp_login", []byte(token), jb)
+	db.Zset("totp_login_expire", []byte(token), uint64(obj.Expire))
+	return token, nil
+}
+
+// TotpLoginGet 取得未过期的登录记录
+func TotpLoginGet(db *youdb.DB, token string) (TotpLogin, error) {
+	obj := TotpLogin{}
+	rs := db.Hget("totp_login", []byte(token))
+	if len(token) == 0 || rs.State != "ok" {
+		return obj, errors.New("登录已过期，请重新输入密码")
+	}
+	json.Unmarshal(rs.Data[0], &obj)
+	if obj.Expire < time.Now().UTC().Unix() {
+		TotpLoginDel(db, token)
+		return obj, errors.New("登录已过期，请重新输入密码")
+	}
+	return obj, nil
+}
+
+// TotpLoginFail 记一次输错，超过 maxAttempts 次后作废，需要重新输入密码
+func TotpLoginFail(db *youdb.DB, token string, obj TotpLogin, maxAttempts int) error {
+	obj.Attempts++
+	if obj.Attempts >= maxAttempts {
+		TotpLoginDel(db, token)
+		return errors.New("验证码错误次数过多，请重新登录")
+	}
+	jb, _ := json.Marshal(obj)
+	db.Hset("totp_login", []byte(token), jb)
+	return errors.New("验证码不正确")
+}
+
+func TotpLoginDel(db *youdb.DB, token string) {
+	db.Hdel("totp_login", []byte(token))
+	db.Zdel("totp_login_expire", []byte(token))
+}
+
+// TotpLoginExpire 清除过期的登录记录，返回清除的条数
+func TotpLoginExpire(db *youdb.DB, limit int) int {
+	rs := db.Zrscan("totp_login_expire", []byte(""), youdb.I2b(uint64(time.Now().UTC().Unix())), limit)
+	if rs.State != "ok" {
+		return 0
+	}
+	n := 0
+	for i := 0; i < (len(rs.Data) - 1); i += 2 {
+		db.Hdel("totp_login", rs.Data[i])
+		db.Zdel("totp_login_expire", rs.Data[i])
+		n++
+	}
+	return n
+}
@@ -0,0 +1,486 @@
+package model
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/missdeer/kani/util"
+)
+
+// 回收站
+// article_trash       hash  aid -> ArticleTrash
+// article_trash_time  zset  aid -> 删除时间
+// comment_trash       hash  aid:cid -> CommentTrash
+// comment_trash_time  zset  aid:cid -> 删除时间
+// 删除时记下文章在各列表、索引中的位置，恢复时原样写回；超过保留天数由定时任务彻底清除
+
+// ArticleTrashIndex 删除前文章所在的各列表、索引
+type ArticleTrashIndex struct {
+	Timeline         uint64            `json:"timeline"`         // article_timeline
+	CategoryTimeline uint64            `json:"categorytimeline"` // category_article_timeline:<cid>
+	UserTimeline     bool              `json:"usertimeline"`     // user_article_timeline:<uid>
+	Hidden           bool              `json:"hidden"`           // article_hidden
+	TitleMd5         string            `json:"titlemd5"`
+	Tags             []string          `json:"tags"`      // tag:<tag>
+	Replies          map[uint64]uint64 `json:"replies"`   // 评论者 uid -> 评论数
+	UserReply        map[uint64]uint64 `json:"userreply"` // user_article_reply:<uid>
+}
+
+type ArticleTrash struct {
+	Article Article           `json:"article"`
+	Index   ArticleTrashIndex `json:"index"`
+	DelUID  uint64            `json:"deluid"`
+	DelTime uint64            `json:"deltime"`
+}
+
+type CommentTrash struct {
+	Comment Comment `json:"comment"`
+	DelUID  uint64  `json:"deluid"`
+	DelTime uint64  `json:"deltime"`
+}
+
+type TrashListItem struct {
+	Key        string
+	AID        uint64
+	CID        uint64
+	UID        uint64
+	Name       string
+	DelUID     uint64
+	Title      string
+	Content    string
+	DelName    string
+	DelTimeFmt string
+}
+
+func commentTrashKey(aid, cid uint64) []byte {
+	return []byte(strconv.FormatUint(aid, 10) + ":" + strconv.FormatUint(cid, 10))
+}
+
+func counterAdd(n uint64, step int64) uint64 {
+	if step < 0 && n < uint64(-step) {
+		return 0
+	}
+	return uint64(int64(n) + step)
+}
+
+// userCounterIncr 修改用户的文章数、评论数
+func userCounterIncr(db *youdb.DB, uid uint64, articles, replies int64) {
+	uobj, err := UserGetByID(db, uid)
+	if err != nil {
+		return
+	}
+	uobj.Articles = counterAdd(uobj.Articles, articles)
+	uobj.Replies = counterAdd(uobj.Replies, replies)
+	jb, _ := json.Marshal(uobj)
+	db.Hset("user", youdb.I2b(uobj.ID), jb)
+}
+
+// ArticleTrashAdd 删除文章到回收站，从各列表、索引中移除并更新计数
+func ArticleTrashAdd(db *youdb.DB, aobj Article, uid uint64) {
+	aidB := youdb.I2b(aobj.ID)
+	aid := strconv.FormatUint(aobj.ID, 10)
+	cid := strconv.FormatUint(aobj.CID, 10)
+	idx := ArticleTrashIndex{
+		Replies:   map[uint64]uint64{},
+		UserReply: map[uint64]uint64{},
+	}
+
+	if rs := db.Zget("article_timeline", aidB); rs.State == "ok" {
+		idx.Timeline = youdb.B2i(rs.Data[0])
+		db.Zdel("article_timeline", aidB)
+	}
+	if rs := db.Zget("category_article_timeline:"+cid, aidB); rs.State == "ok" {
+		idx.CategoryTimeline = youdb.B2i(rs.Data[0])
+		db.Zdel("category_article_timeline:"+cid, aidB)
+	}
+	if db.Hget("user_article_timeline:"+strconv.FormatUint(aobj.UID, 10), aidB).State == "ok" {
+		idx.UserTimeline = true
+		db.Hdel("user_article_timeline:"+strconv.FormatUint(aobj.UID, 10), aidB)
+	}
+	if db.Hget("article_hidden", aidB).State == "ok" {
+		idx.Hidden = true
+		db.Hdel("article_hidden", aidB)
+	}
+
+	hash := md5.Sum([]byte(aobj.Title))
+	titleMd5 := hex.EncodeToString(hash[:])
+	if rs := db.Hget("title_md5", []byte(titleMd5)); rs.State == "ok" && youdb.B2i(rs.Data[0]) == aobj.ID {
+		idx.TitleMd5 = titleMd5
+		db.Hdel("title_md5", []byte(titleMd5))
+	}
+
+	if len(aobj.Tags) > 0 {
+		for _, tag := range strings.Split(aobj.Tags, ",") {
+			tagLower := strings.ToLower(tag)
+			if db.Hget("tag:"+tagLower, aidB).State == "ok" {
+				idx.Tags = append(idx.Tags, tagLower)
+				db.Hdel("tag:"+tagLower, aidB)
+				db.Zincr("tag_article_num", []byte(tagLower), -1)
+			}
+		}
+	}
+
+	// 评论计数
+	var comments int64
+	startKey := []byte("")
+	for rs := db.Hscan("article_comment:"+aid, startKey, 100); rs.State == "ok"; rs = db.Hscan("article_comment:"+aid, startKey, 100) {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			startKey = rs.Data[i]
+			cobj := Comment{}
+			json.Unmarshal(rs.Data[i+1], &cobj)
+			idx.Replies[cobj.UID]++
+			comments++
+		}
+	}
+	for cuid, n := range idx.Replies {
+		userCounterIncr(db, cuid, 0, -int64(n))
+		replyTb := "user_article_reply:" + strconv.FormatUint(cuid, 10)
+		if rs := db.Zget(replyTb, aidB); rs.State == "ok" {
+			idx.UserReply[cuid] = youdb.B2i(rs.Data[0])
+			db.Zdel(replyTb, aidB)
+		}
+	}
+	if comments > 0 {
+		db.Hincr("count", []byte("comment_num"), -comments)
+	}
+
+	// 隐藏的文章已经减过计数
+	if !idx.Hidden {
+		db.Zincr("category_article_num", youdb.I2b(aobj.CID), -1)
+		userCounterIncr(db, aobj.UID, -1, 0)
+	}
+
+	now := uint64(time.Now().UTC().Unix())
+	jb, _ := json.Marshal(ArticleTrash{
+		Article: aobj,
+		Index:   idx,
+		DelUID:  uid,
+		DelTime: now,
+	})
+	db.Hset("article_trash", aidB, jb)
+	db.Zset("article_trash_time", aidB, now)
+	db.Hdel("article", aidB)
+
+	// 全文索引
+	SearchIndexRemove(db, aobj.ID)
+}
+
+func ArticleTrashGet(db *youdb.DB, aid uint64) (ArticleTrash, error) {
+	obj := ArticleTrash{}
+	rs := db.Hget("article_trash", youdb.I2b(aid))
+	if rs.State != "ok" {
+		return obj, errors.New(rs.State)
+	}
+	if err := json.Unmarshal(rs.Data[0], &obj); err != nil {
+		return obj, err
+	}
+	return obj, nil
+}
+
+// ArticleTrashRestore 从回收站恢复文章，写回删除前的列表、索引和计数
+func ArticleTrashRestore(db *youdb.DB, aid uint64) error {
+	tobj, err := ArticleTrashGet(db, aid)
+	if err != nil {
+		return err
+	}
+	aobj, idx := tobj.Article, tobj.Index
+	aidB := youdb.I2b(aobj.ID)
+	cid := strconv.FormatUint(aobj.CID, 10)
+
+	if len(idx.TitleMd5) > 0 && db.Hget("title_md5", []byte(idx.TitleMd5)).State == "ok" {
+		return errors.New("title has existed")
+	}
+	if _, err := CategoryGetByID(db, cid); err != nil {
+		return err
+	}
+
+	jb, _ := json.Marshal(aobj)
+	db.Hset("article", aidB, jb)
+
+	if idx.Timeline > 0 {
+		db.Zset("article_timeline", aidB, idx.Timeline)
+	}
+	if idx.CategoryTimeline > 0 {
+		db.Zset("category_article_timeline:"+cid, aidB, idx.CategoryTimeline)
+	}
+	if idx.UserTimeline {
+		db.Hset("user_article_timeline:"+strconv.FormatUint(aobj.UID, 10), aidB, []byte(""))
+	}
+	if idx.Hidden {
+		db.Hset("article_hidden", aidB, []byte(""))
+	}
+	if len(idx.TitleMd5) > 0 {
+		db.Hset("title_md5", []byte(idx.TitleMd5), aidB)
+	}
+	for _, tag := range idx.Tags {
+		if db.Hget("tag", []byte(tag)).State != "ok" {
+			db.Hset("tag", []byte(tag), []byte(""))
+			db.HnextSequence("tag")
+		}
+		db.Hset("tag:"+tag, aidB, []byte(""))
+		db.Zincr("tag_article_num", []byte(tag), 1)
+	}
+
+	var comments int64
+	for cuid, n := range idx.Replies {
+		userCounterIncr(db, cuid, 0, int64(n))
+		comments += int64(n)
+	}
+	for cuid, score := range idx.UserReply {
+		db.Zset("user_article_reply:"+strconv.FormatUint(cuid, 10), aidB, score)
+	}
+	if comments > 0 {
+		db.Hincr("count", []byte("comment_num"), comments)
+	}
+
+	if !idx.Hidden {
+		db.Zincr("category_article_num", youdb.I2b(aobj.CID), 1)
+		userCounterIncr(db, aobj.UID, 1, 0)
+	}
+
+	db.Hdel("article_trash", aidB)
+	db.Zdel("article_trash_time", aidB)
+
+	// 全文索引
+	SearchIndexArticle(db, aobj.ID)
+	return nil
+}
+
+// ArticleTrashPurge 彻底删除回收站里的文章及其评论、修订历史。
+// 文章不在回收站（已恢复或根本没删除）时返回错误，不动它的评论和修订历史
+func ArticleTrashPurge(db *youdb.DB, aid uint64) error {
+	if _, err := ArticleTrashGet(db, aid); err != nil {
+		return err
+	}
+	aidB := youdb.I2b(aid)
+	aidS := strconv.FormatUint(aid, 10)
+
+	startKey := []byte("")
+	for rs := db.Hscan("article_comment:"+aidS, startKey, 100); rs.State == "ok"; rs = db.Hscan("article_comment:"+aidS, startKey, 100) {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			startKey = rs.Data[i]
+			db.HdelBucket(CommentRevisionTb(aid, youdb.B2i(rs.Data[i])))
+		}
+	}
+	db.HdelBucket("article_comment:" + aidS)
+	db.HdelBucket(ArticleRevisionTb(aid))
+	db.Hdel("article_views", aidB)
+
+	db.Hdel("article_trash", aidB)
+	db.Zdel("article_trash_time", aidB)
+	return nil
+}
+
+// CommentTrashAdd 删除评论到回收站
+func CommentTrashAdd(db *youdb.DB, aobj Article, cobj Comment, uid uint64) {
+	aid := strconv.FormatUint(cobj.AID, 10)
+	key := commentTrashKey(cobj.AID, cobj.ID)
+	now := uint64(time.Now().UTC().Unix())
+
+	jb, _ := json.Marshal(CommentTrash{
+		Comment: cobj,
+		DelUID:  uid,
+		DelTime: now,
+	})
+	db.Hset("comment_trash", key, jb)
+	db.Zset("comment_trash_time", key, now)
+	CommentDelByKey(db, aid, cobj.ID)
+
+	db.Hincr("count", []byte("comment_num"), -1)
+	userCounterIncr(db, cobj.UID, 0, -1)
+	if aobj.ID > 0 && aobj.Comments > 0 {
+		aobj.Comments--
+		jb, _ = json.Marshal(aobj)
+		db.Hset("article", youdb.I2b(aobj.ID), jb)
+	}
+
+	// 全文索引
+	SearchIndexArticle(db, cobj.AID)
+}
+
+func CommentTrashGet(db *youdb.DB, aid, cid uint64) (CommentTrash, error) {
+	obj := CommentTrash{}
+	rs := db.Hget("comment_trash", commentTrashKey(aid, cid))
+	if rs.State != "ok" {
+		return obj, errors.New(rs.State)
+	}
+	if err := json.Unmarshal(rs.Data[0], &obj); err != nil {
+		return obj, err
+	}
+	return obj, nil
+}
+
+// CommentTrashRestore 从回收站恢复评论，所属文章须存在
+func CommentTrashRestore(db *youdb.DB, aid, cid uint64) error {
+	tobj, err := CommentTrashGet(db, aid, cid)
+	if err != nil {
+		return err
+	}
+	aidS := strconv.FormatUint(aid, 10)
+	aobj, err := ArticleGetByID(db, aidS)
+	if err != nil {
+		return errors.New("article not found")
+	}
+
+	CommentSetByKey(db, aidS, cid, tobj.Comment)
+
+	db.Hincr("count", []byte("comment_num"), 1)
+	userCounterIncr(db, tobj.Comment.UID, 0, 1)
+	aobj.Comments++
+	jb, _ := json.Marshal(aobj)
+	db.Hset("article", youdb.I2b(aobj.ID), jb)
+
+	key := commentTrashKey(aid, cid)
+	db.Hdel("comment_trash", key)
+	db.Zdel("comment_trash_time", key)
+
+	// 全文索引
+	SearchIndexArticle(db, aid)
+	return nil
+}
+
+// CommentTrashPurge 彻底删除回收站里的评论及其修订历史，评论不在回收站时返回错误
+func CommentTrashPurge(db *youdb.DB, aid, cid uint64) error {
+	if _, err := CommentTrashGet(db, aid, cid); err != nil {
+		return err
+	}
+	key := commentTrashKey(aid, cid)
+	db.HdelBucket(CommentRevisionTb(aid, cid))
+	db.Hdel("comment_trash", key)
+	db.Zdel("comment_trash_time", key)
+	return nil
+}
+
+// TrashPurgeExpired 清除删除超过 days 天的文章和评论，返回清除条数
+func TrashPurgeExpired(db *youdb.DB, days, limit int) int {
+	if days <= 0 {
+		return 0
+	}
+	n := 0
+	timeBefore := uint64(time.Now().UTC().Unix() - int64(days)*3600*24)
+	scoreStartB := youdb.I2b(timeBefore)
+
+	rs := db.Zrscan("article_trash_time", []byte(""), scoreStartB, limit)
+	if rs.State == "ok" {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			if ArticleTrashPurge(db, youdb.B2i(rs.Data[i])) != nil {
+				// 回收站里已经没有了，只清掉时间索引
+				db.Zdel("article_trash_time", rs.Data[i])
+			}
+			n++
+		}
+	}
+	rs = db.Zrscan("comment_trash_time", []byte(""), scoreStartB, limit)
+	if rs.State == "ok" {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			kv := strings.SplitN(string(rs.Data[i]), ":", 2)
+			if len(kv) != 2 || CommentTrashPurge(db, youdb.DS2i(kv[0]), youdb.DS2i(kv[1])) != nil {
+				db.Zdel("comment_trash_time", rs.Data[i])
+			}
+			n++
+		}
+	}
+	return n
+}
+
+// ArticleTrashList 回收站文章，最近删除的在前
+func ArticleTrashList(db *youdb.DB, limit, tz int) []TrashListItem {
+	var items []TrashListItem
+	keys := trashKeys(db, "article_trash_time", limit)
+	if len(keys) == 0 {
+		return items
+	}
+
+	rs := db.Hmget("article_trash", keys)
+	if rs.State == "ok" {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			obj := ArticleTrash{}
+			json.Unmarshal(rs.Data[i+1], &obj)
+			items = append(items, TrashListItem{
+				Key:        strconv.FormatUint(obj.Article.ID, 10),
+				AID:        obj.Article.ID,
+				UID:        obj.Article.UID,
+				DelUID:     obj.DelUID,
+				Title:      obj.Article.Title,
+				DelTimeFmt: util.TimeFmt(obj.DelTime, "2006-01-02 15:04", tz),
+			})
+		}
+	}
+	return trashListNames(db, items)
+}
+
+// CommentTrashList 回收站评论，最近删除的在前
+func CommentTrashList(db *youdb.DB, limit, tz int) []TrashListItem {
+	var items []TrashListItem
+	keys := trashKeys(db, "comment_trash_time", limit)
+	if len(keys) == 0 {
+		return items
+	}
+
+	rs := db.Hmget("comment_trash", keys)
+	if rs.State == "ok" {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			obj := CommentTrash{}
+			json.Unmarshal(rs.Data[i+1], &obj)
+			content := []rune(obj.Comment.Content)
+			if len(content) > 100 {
+				content = append(content[:100], []rune("...")...)
+			}
+			items = append(items, TrashListItem{
+				Key:        string(rs.Data[i]),
+				AID:        obj.Comment.AID,
+				CID:        obj.Comment.ID,
+				UID:        obj.Comment.UID,
+				DelUID:     obj.DelUID,
+				Content:    string(content),
+				DelTimeFmt: util.TimeFmt(obj.DelTime, "2006-01-02 15:04", tz),
+			})
+		}
+	}
+	return trashListNames(db, items)
+}
+
+func trashKeys(db *youdb.DB, tb string, limit int) [][]byte {
+	var keys [][]byte
+	rs := db.Zrscan(tb, []byte(""), []byte(""), limit)
+	if rs.State == "ok" {
+		for i := 0; i < (len(rs.Data) - 1); i += 2 {
+			keys = append(keys, rs.Data[i])
+		}
+	}
+	return keys
+}
+
+func trashListNames(db *youdb.DB, items []TrashListItem) []TrashListItem {
+	userMap := map[uint64]UserMini{}
+	var userKeys [][]byte
+	for _, item := range items {
+		for _, uid := range []uint64{item.UID, item.DelUID} {
+			if _, ok := userMap[uid]; !ok {
+				userMap[uid] = UserMini{}
+				userKeys = append(userKeys, youdb.I2b(uid))
+			}
+		}
+	}
+	if len(userKeys) > 0 {
+		rs := db.Hmget("user", userKeys)
+		if rs.State == "ok" {
+			for i := 0; i < (len(rs.Data) - 1); i += 2 {
+				item := UserMini{}
+				json.Unmarshal(rs.Data[i+1], &item)
+				userMap[item.ID] = item
+			}
+		}
+	}
+	for i, item := range items {
+		items[i].Name = userMap[item.UID].Name
+		items[i].DelName = userMap[item.DelUID].Name
+	}
+	return items
+}
@@ -0,0 +1,243 @@
+package model
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/ego008/youdb"
+)
+
+func testDB(t *testing.T) *youdb.DB {
+	db, err := youdb.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testHset(t *testing.T, db *youdb.DB, tb string, key []byte, v interface{}) {
+	t.Helper()
+	jb, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Hset(tb, key, jb)
+}
+
+// trashTestArticle 按发帖、评论的流程写一篇文章：作者 uid 1，评论者 uid 2 评论两次、uid 3 评论一次
+func trashTestArticle(t *testing.T, db *youdb.DB) Article {
+	testHset(t, db, "category", youdb.I2b(1), Category{ID: 1, Name: "cat"})
+	for uid := uint64(1); uid <= 3; uid++ {
+		testHset(t, db, "user", youdb.I2b(uid), User{ID: uid, Name: "u" + strconv.FormatUint(uid, 10)})
+	}
+
+	aid, _ := db.HnextSequence("article")
+	aidB := youdb.I2b(aid)
+	aobj := Article{ID: aid, UID: 1, CID: 1, Title: "trash title", Content: "hello world", Tags: "Go,Kani", AddTime: 100, EditTime: 200, Comments: 3}
+	testHset(t, db, "article", aidB, aobj)
+	db.Zset("article_timeline", aidB, 200)
+	db.Zset("category_article_timeline:1", aidB, 200)
+	db.Hset("user_article_timeline:1", aidB, []byte(""))
+	db.Zincr("category_article_num", youdb.I2b(1), 1)
+	userCounterIncr(db, 1, 1, 0)
+	hash := md5.Sum([]byte(aobj.Title))
+	db.Hset("title_md5", []byte(hex.EncodeToString(hash[:])), aidB)
+	for _, tag := range []string{"go", "kani"} {
+		db.Hset("tag", []byte(tag), []byte(""))
+		db.Hset("tag:"+tag, aidB, []byte(""))
+		db.Zincr("tag_article_num", []byte(tag), 1)
+	}
+
+	aidS := strconv.FormatUint(aid, 10)
+	for cid, uid := range []uint64{2, 2, 3} {
+		CommentSetByKey(db, aidS, uint64(cid+1), Comment{ID: uint64(cid + 1), AID: aid, UID: uid, Content: "reply", AddTime: 300})
+		db.Zset("user_article_reply:"+strconv.FormatUint(uid, 10), aidB, uint64(300+cid))
+		userCounterIncr(db, uid, 0, 1)
+	}
+	db.Hincr("count", []byte("comment_num"), 3)
+	db.Hset(ArticleRevisionTb(aid), youdb.I2b(1), []byte("{}"))
+	db.Hset(CommentRevisionTb(aid, 1), youdb.I2b(1), []byte("{}"))
+
+	SearchIndexArticle(db, aid)
+	return aobj
+}
+
+// trashTestState 文章在各列表、索引中的位置和相关计数
+func trashTestState(db *youdb.DB, aobj Article) map[string]string {
+	aidB := youdb.I2b(aobj.ID)
+	hash := md5.Sum([]byte(aobj.Title))
+	zget := func(tb string, key []byte) string {
+		if rs := db.Zget(tb, key); rs.State == "ok" {
+			return strconv.FormatUint(youdb.B2i(rs.Data[0]), 10)
+		}
+		return "-"
+	}
+	hget := func(tb string, key []byte) string {
+		if rs := db.Hget(tb, key); rs.State == "ok" {
+			return string(rs.Data[0])
+		}
+		return "-"
+	}
+	st := map[string]string{
+		"article":                     hget("article", aidB),
+		"article_timeline":            zget("article_timeline", aidB),
+		"category_article_timeline:1": zget("category_article_timeline:1", aidB),
+		"user_article_timeline:1":     hget("user_article_timeline:1", aidB),
+		"title_md5":                   hget("title_md5", []byte(hex.EncodeToString(hash[:]))),
+		"tag:go":                      hget("tag:go", aidB),
+		"tag:kani":                    hget("tag:kani", aidB),
+		"tag_article_num:go":          zget("tag_article_num", []byte("go")),
+		"tag_article_num:kani":        zget("tag_article_num", []byte("kani")),
+		"category_article_num":        zget("category_article_num", youdb.I2b(aobj.CID)),
+		"user_article_reply:2":        zget("user_article_reply:2", aidB),
+		"user_article_reply:3":        zget("user_article_reply:3", aidB),
+		"search_article_term":         hget("search_article_term", aidB),
+		"search_term:hello":           hget("search_term:hello", aidB),
+	}
+	if rs := db.Hget("count", []byte("comment_num")); rs.State == "ok" {
+		st["comment_num"] = strconv.FormatUint(youdb.B2i(rs.Data[0]), 10)
+	}
+	for uid := uint64(1); uid <= 3; uid++ {
+		uobj, _ := UserGetByID(db, uid)
+		st["user:"+strconv.FormatUint(uid, 10)] = strconv.FormatUint(uobj.Articles, 10) + "/" + strconv.FormatUint(uobj.Replies, 10)
+	}
+	return st
+}
+
+func TestArticleTrashRoundTrip(t *testing.T) {
+	db := testDB(t)
+	aobj := trashTestArticle(t, db)
+	before := trashTestState(db, aobj)
+	for k, v := range before {
+		if v == "-" {
+			t.Fatalf("setup: %s missing", k)
+		}
+	}
+
+	ArticleTrashAdd(db, aobj, 1)
+	after := trashTestState(db, aobj)
+	for k, v := range after {
+		switch k {
+		case "tag_article_num:go", "tag_article_num:kani", "category_article_num", "comment_num":
+			if v != "0" && v != "-" {
+				t.Errorf("after delete: %s = %s, want 0", k, v)
+			}
+		case "user:1", "user:2", "user:3":
+			if v != "0/0" {
+				t.Errorf("after delete: %s = %s, want 0/0", k, v)
+			}
+		default:
+			if v != "-" {
+				t.Errorf("after delete: %s still set", k)
+			}
+		}
+	}
+	if _, err := ArticleTrashGet(db, aobj.ID); err != nil {
+		t.Fatalf("article not in trash: %v", err)
+	}
+
+	if err := ArticleTrashRestore(db, aobj.ID); err != nil {
+		t.Fatal(err)
+	}
+	restored := trashTestState(db, aobj)
+	for k, v := range before {
+		if restored[k] != v {
+			t.Errorf("after restore: %s = %s, want %s", k, restored[k], v)
+		}
+	}
+	if _, err := ArticleTrashGet(db, aobj.ID); err == nil {
+		t.Error("article still in trash after restore")
+	}
+	if db.Zget("article_trash_time", youdb.I2b(aobj.ID)).State == "ok" {
+		t.Error("article_trash_time still set after restore")
+	}
+}
+
+// 不在回收站的文章、评论不能被彻底删除，评论和修订历史要留着
+func TestTrashPurgeNotInTrash(t *testing.T) {
+	db := testDB(t)
+	aobj := trashTestArticle(t, db)
+	aidS := strconv.FormatUint(aobj.ID, 10)
+	intact := func(when string) {
+		t.Helper()
+		if _, err := CommentGetByKey(db, aidS, 1); err != nil {
+			t.Errorf("%s: comment removed", when)
+		}
+		if db.Hget(ArticleRevisionTb(aobj.ID), youdb.I2b(1)).State != "ok" {
+			t.Errorf("%s: article revision removed", when)
+		}
+		if db.Hget(CommentRevisionTb(aobj.ID, 1), youdb.I2b(1)).State != "ok" {
+			t.Errorf("%s: comment revision removed", when)
+		}
+	}
+
+	if err := ArticleTrashPurge(db, aobj.ID); err == nil {
+		t.Error("purged a live article")
+	}
+	if err := CommentTrashPurge(db, aobj.ID, 1); err == nil {
+		t.Error("purged a live comment")
+	}
+	intact("live")
+
+	// 删除后恢复，再清除
+	ArticleTrashAdd(db, aobj, 1)
+	if err := ArticleTrashRestore(db, aobj.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := ArticleTrashPurge(db, aobj.ID); err == nil {
+		t.Error("purged a restored article")
+	}
+	intact("restored")
+
+	// 在回收站里的可以清除
+	ArticleTrashAdd(db, aobj, 1)
+	if err := ArticleTrashPurge(db, aobj.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CommentGetByKey(db, aidS, 1); err == nil {
+		t.Error("comment left after purge")
+	}
+	if db.Hget(ArticleRevisionTb(aobj.ID), youdb.I2b(1)).State == "ok" {
+		t.Error("article revision left after purge")
+	}
+	if err := ArticleTrashPurge(db, aobj.ID); err == nil {
+		t.Error("purged twice")
+	}
+}
+
+func TestCommentTrashPurge(t *testing.T) {
+	db := testDB(t)
+	aobj := trashTestArticle(t, db)
+	aidS := strconv.FormatUint(aobj.ID, 10)
+	cobj, err := CommentGetByKey(db, aidS, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	CommentTrashAdd(db, aobj, cobj, 1)
+	if err := CommentTrashRestore(db, aobj.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := CommentTrashPurge(db, aobj.ID, 1); err == nil {
+		t.Error("purged a restored comment")
+	}
+	if db.Hget(CommentRevisionTb(aobj.ID, 1), youdb.I2b(1)).State != "ok" {
+		t.Error("comment revision removed")
+	}
+
+	CommentTrashAdd(db, aobj, cobj, 1)
+	if err := CommentTrashPurge(db, aobj.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if db.Hget(CommentRevisionTb(aobj.ID, 1), youdb.I2b(1)).State == "ok" {
+		t.Error("comment revision left after purge")
+	}
+	if _, err := CommentTrashGet(db, aobj.ID, 1); err == nil {
+		t.Error("comment still in trash after purge")
+	}
+}
@@ -25,12 +25,17 @@ type User struct {
 	LastReplyTime     uint64 `json:"lastreplytime"`
 	LastLoginTime     uint64 `json:"lastlogintime"`
 	About             string `json:"about"`
-	Notice            string `json:"notice"`
-	NoticeNum         int    `json:"noticenum"`
+	Notice            string `json:"notice"`    // 旧的提醒数据，已迁移到 notification:<uid>
+	NoticeNum         int    `json:"noticenum"` // 未读提醒数
 	EmailVerified     bool   `json:"emailverified"`
+	EmailMention      string `json:"emailmention"` // 被 @ 时的邮件提醒方式，见 EmailMode*
+	EmailReply        string `json:"emailreply"`   // 被回复时的邮件提醒方式
 	TelephoneVerified bool   `json:"telephoneverified"`
+	TotpSecret        string `json:"totpsecret"`   // 两步验证密钥，TotpEnabled 为 false 时是正在绑定的密钥
+	TotpEnabled       bool   `json:"totpenabled"`  // 已开启两步验证
+	TotpLastStep      uint64 `json:"totplaststep"` // 上次通过的 TOTP 步数，防止验证码被重复使用
 	Hidden            bool   `json:"hidden"`
-	Session           string `json:"session"`
+	Session           string `json:"session"` // 旧的单会话，登录后转为 session 记录
 }
 
 type UserMini struct {
@@ -1,7 +0,0 @@
-package model
-
-type WeiBo struct {
-	Uid    uint64 `json:"uid"`
-	Name   string `json:"name"`
-	Openid string `json:"openid"`
-}
@@ -5,12 +5,13 @@ import (
 	"github.com/missdeer/kani/system"
 	"goji.io"
 	"goji.io/pat"
-	//"github.com/gorilla/csrf"
 )
 
 func NewRouter(app *system.Application) *goji.Mux {
 	sp := goji.SubMux()
 	h := controller.BaseHandler{App: app}
+	sp.Use(h.CsrfMiddleware)
+	sp.Use(h.AdminTotpMiddleware)
 
 	sp.HandleFunc(pat.Get("/"), h.ArticleHomeList)
 	sp.HandleFunc(pat.Get("/view"), h.ViewAtTpl)
@@ -24,29 +25,48 @@ func NewRouter(app *system.Application) *goji.Mux {
 
 	sp.HandleFunc(pat.Get("/logout"), h.UserLogout)
 	sp.HandleFunc(pat.Get("/notification"), h.UserNotification)
+	sp.HandleFunc(pat.Post("/notification"), h.UserNotificationPost)
+	sp.HandleFunc(pat.Get("/notification/stream"), h.NotificationStream)
 
 	sp.HandleFunc(pat.Get("/t/:aid"), h.ArticleDetail)
 	sp.HandleFunc(pat.Post("/t/:aid"), h.ArticleDetailPost)
+	sp.HandleFunc(pat.Get("/t/:aid/c/:cid"), h.ArticleCommentGoto)
+	sp.HandleFunc(pat.Get("/t/:aid/edit"), h.ArticleAuthorEdit)
+	sp.HandleFunc(pat.Get("/t/:aid/edit/:cid"), h.CommentAuthorEdit)
 
 	sp.HandleFunc(pat.Get("/setting"), h.UserSetting)
 	sp.HandleFunc(pat.Post("/setting"), h.UserSettingPost)
 
 	sp.HandleFunc(pat.Get("/charge"), h.UserCharge)
 	sp.HandleFunc(pat.Get("/verifyemail"), h.UserVerifyEmail)
+	sp.HandleFunc(pat.Post("/verifyemail"), h.UserVerifyEmailPost)
+	sp.HandleFunc(pat.Get("/verifyemail/confirm"), h.UserVerifyEmailConfirm)
 	sp.HandleFunc(pat.Get("/verifytelephone"), h.UserVerifyTelephone)
+	sp.HandleFunc(pat.Post("/verifytelephone"), h.UserVerifyTelephonePost)
+	sp.HandleFunc(pat.Get("/unsubscribe"), h.EmailUnsubscribe)
+	sp.HandleFunc(pat.Post("/unsubscribe"), h.EmailUnsubscribePost)
 
 	sp.HandleFunc(pat.Get("/newpost/:cid"), h.ArticleAdd)
 	sp.HandleFunc(pat.Post("/newpost/:cid"), h.ArticleAddPost)
 
 	sp.HandleFunc(pat.Get("/login"), h.UserLogin)
 	sp.HandleFunc(pat.Post("/login"), h.UserLoginPost)
+	sp.HandleFunc(pat.Get("/captcha/:id"), h.CaptchaImage)
+	sp.HandleFunc(pat.Get("/login/totp"), h.UserLoginTotp)
+	sp.HandleFunc(pat.Post("/login/totp"), h.UserLoginTotpPost)
 	sp.HandleFunc(pat.Get("/register"), h.UserLogin)
 	sp.HandleFunc(pat.Post("/register"), h.UserLoginPost)
+	sp.HandleFunc(pat.Get("/forgot"), h.UserForgot)
+	sp.HandleFunc(pat.Post("/forgot"), h.UserForgotPost)
+	sp.HandleFunc(pat.Get("/reset/:token"), h.UserReset)
+	sp.HandleFunc(pat.Post("/reset/:token"), h.UserResetPost)
 
 	sp.HandleFunc(pat.Get("/qqlogin"), h.QQOauthHandler)
 	sp.HandleFunc(pat.Get("/oauth/qq/callback"), h.QQOauthCallback)
 	sp.HandleFunc(pat.Get("/wblogin"), h.WeiboOauthHandler)
 	sp.HandleFunc(pat.Get("/oauth/wb/callback"), h.WeiboOauthCallback)
+	sp.HandleFunc(pat.Get("/oauth/:provider/login"), h.OAuthLogin)
+	sp.HandleFunc(pat.Get("/oauth/:provider/callback"), h.OAuthCallback)
 
 	sp.HandleFunc(pat.Post("/content/preview"), h.ContentPreviewPost)
 	sp.HandleFunc(pat.Post("/file/upload"), h.FileUpload)
@@ -55,10 +75,21 @@ func NewRouter(app *system.Application) *goji.Mux {
 	sp.HandleFunc(pat.Post("/admin/post/edit/:aid"), h.ArticleEditPost)
 	sp.HandleFunc(pat.Get("/admin/comment/edit/:aid/:cid"), h.CommentEdit)
 	sp.HandleFunc(pat.Post("/admin/comment/edit/:aid/:cid"), h.CommentEditPost)
+	sp.HandleFunc(pat.Get("/admin/post/list"), h.AdminArticleList)
+	sp.HandleFunc(pat.Post("/admin/post/list"), h.AdminArticleListPost)
+	sp.HandleFunc(pat.Get("/admin/trash"), h.AdminTrash)
+	sp.HandleFunc(pat.Post("/admin/trash"), h.AdminTrashPost)
+	sp.HandleFunc(pat.Get("/admin/post/revision/:aid"), h.ArticleRevision)
+	sp.HandleFunc(pat.Post("/admin/post/revision/:aid"), h.ArticleRevisionPost)
+	sp.HandleFunc(pat.Get("/admin/comment/revision/:aid/:cid"), h.CommentRevision)
+	sp.HandleFunc(pat.Post("/admin/comment/revision/:aid/:cid"), h.CommentRevisionPost)
 	sp.HandleFunc(pat.Get("/admin/user/edit/:uid"), h.UserEdit)
 	sp.HandleFunc(pat.Post("/admin/user/edit/:uid"), h.UserEditPost)
 	sp.HandleFunc(pat.Get("/admin/user/list"), h.AdminUserList)
 	sp.HandleFunc(pat.Post("/admin/user/list"), h.AdminUserListPost)
+	sp.HandleFunc(pat.Get("/admin/loginlock"), h.AdminLoginLock)
+	sp.HandleFunc(pat.Post("/admin/loginlock"), h.AdminLoginLockPost)
+	sp.HandleFunc(pat.Post("/admin/search/rebuild"), h.AdminSearchRebuildPost)
 	sp.HandleFunc(pat.Get("/admin/category/list"), h.AdminCategoryList)
 	sp.HandleFunc(pat.Post("/admin/category/list"), h.AdminCategoryListPost)
 	sp.HandleFunc(pat.Get("/admin/link/list"), h.AdminLinkList)
@@ -0,0 +1,99 @@
+package system
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// cookie 签名和加密密钥
+// 每行一对 "hashKey blockKey"（base64），第一行用于编码，其余是轮换前的旧密钥，只用于解码
+// 优先用配置里的 CookieKeys，否则读 CookieKeyFile，文件不存在时生成
+
+func newCookieKeyLine() string {
+	return base64.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(64)) + " " +
+		base64.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+}
+
+func readCookieKeyFile(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+func writeCookieKeyFile(path string, lines []string) error {
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+func parseCookieKeys(lines []string) ([][]byte, error) {
+	var pairs [][]byte
+	for _, line := range lines {
+		kv := strings.Fields(line)
+		if len(kv) != 2 {
+			return nil, errors.New("cookie key fmt err: want \"hashKey blockKey\"")
+		}
+		hashKey, err := base64.StdEncoding.DecodeString(kv[0])
+		if err != nil {
+			return nil, err
+		}
+		blockKey, err := base64.StdEncoding.DecodeString(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, hashKey, blockKey)
+	}
+	if len(pairs) == 0 {
+		return nil, errors.New("no cookie key")
+	}
+	return pairs, nil
+}
+
+// loadCookieKeys 返回 CodecsFromPairs 需要的密钥
+func loadCookieKeys(mcf *MainConf) ([][]byte, error) {
+	if len(mcf.CookieKeys) > 0 {
+		return parseCookieKeys(mcf.CookieKeys)
+	}
+
+	lines, err := readCookieKeyFile(mcf.CookieKeyFile)
+	if os.IsNotExist(err) {
+		lines = []string{newCookieKeyLine()}
+		err = writeCookieKeyFile(mcf.CookieKeyFile, lines)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseCookieKeys(lines)
+}
+
+// RotateCookieKeys 在密钥文件最前面加一对新密钥，最多保留 CookieKeepOld 对旧密钥
+// 重启后生效，旧密钥编码的 cookie 仍然可以解码；配置了 CookieKeys 时不读密钥文件，需要在配置里轮换
+func RotateCookieKeys(mcf *MainConf) error {
+	if len(mcf.CookieKeys) > 0 {
+		return errors.New("CookieKeys is set in config, rotate the keys there instead of " + mcf.CookieKeyFile)
+	}
+	lines, err := readCookieKeyFile(mcf.CookieKeyFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	keep := mcf.CookieKeepOld
+	if keep < 0 {
+		keep = 0
+	}
+	if len(lines) > keep {
+		lines = lines[:keep]
+	}
+	return writeCookieKeyFile(mcf.CookieKeyFile, append([]string{newCookieKeyLine()}, lines...))
+}
@@ -0,0 +1,108 @@
+package system
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseCookieKeys(t *testing.T) {
+	hashKey := base64.StdEncoding.EncodeToString([]byte("hash-key"))
+	blockKey := base64.StdEncoding.EncodeToString([]byte("block-key-16byte"))
+	tests := []struct {
+		name  string
+		lines []string
+		pairs int
+		err   bool
+	}{
+		{"one", []string{hashKey + " " + blockKey}, 1, false},
+		{"two", []string{hashKey + " " + blockKey, hashKey + "\t" + blockKey}, 2, false},
+		{"generated", []string{newCookieKeyLine()}, 1, false},
+		{"empty", nil, 0, true},
+		{"one field", []string{hashKey}, 0, true},
+		{"three fields", []string{hashKey + " " + blockKey + " x"}, 0, true},
+		{"bad base64", []string{"!!! " + blockKey}, 0, true},
+	}
+	for _, tt := range tests {
+		pairs, err := parseCookieKeys(tt.lines)
+		if (err != nil) != tt.err || len(pairs) != tt.pairs*2 {
+			t.Errorf("%s: parseCookieKeys = %d keys, err %v", tt.name, len(pairs), err)
+		}
+	}
+}
+
+func TestReadCookieKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookie.keys")
+	if err := os.WriteFile(path, []byte("# comment\n\n a b \nc d\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := readCookieKeyFile(path)
+	if err != nil || strings.Join(lines, "|") != "a b|c d" {
+		t.Errorf("readCookieKeyFile = %q, %v", lines, err)
+	}
+}
+
+func TestRotateCookieKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		old  int // 轮换前文件里的密钥数，-1 为文件不存在
+		keep int
+		want int
+	}{
+		{"no file", -1, 2, 1},
+		{"keep all", 1, 2, 2},
+		{"drop oldest", 3, 2, 3},
+		{"keep none", 2, 0, 1},
+		{"negative keep", 2, -1, 1},
+	}
+	for _, tt := range tests {
+		mcf := &MainConf{CookieKeyFile: filepath.Join(t.TempDir(), "cookie.keys"), CookieKeepOld: tt.keep}
+		var old []string
+		for i := 0; i < tt.old; i++ {
+			old = append(old, newCookieKeyLine())
+		}
+		if tt.old >= 0 {
+			if err := writeCookieKeyFile(mcf.CookieKeyFile, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := RotateCookieKeys(mcf); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		lines, err := readCookieKeyFile(mcf.CookieKeyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lines) != tt.want {
+			t.Errorf("%s: %d keys after rotation, want %d", tt.name, len(lines), tt.want)
+			continue
+		}
+		for i := 1; i < len(lines); i++ {
+			if lines[i] != old[i-1] {
+				t.Errorf("%s: key %d is not the old key %d", tt.name, i, i-1)
+			}
+		}
+		if len(old) > 0 && lines[0] == old[0] {
+			t.Errorf("%s: first key not replaced", tt.name)
+		}
+		if _, err := parseCookieKeys(lines); err != nil {
+			t.Errorf("%s: rotated file does not parse: %v", tt.name, err)
+		}
+	}
+}
+
+func TestRotateCookieKeysConfig(t *testing.T) {
+	mcf := &MainConf{
+		CookieKeyFile: filepath.Join(t.TempDir(), "cookie.keys"),
+		CookieKeys:    []string{newCookieKeyLine()},
+		CookieKeepOld: 2,
+	}
+	if err := RotateCookieKeys(mcf); err == nil {
+		t.Error("RotateCookieKeys with CookieKeys in config should fail")
+	}
+	if _, err := os.Stat(mcf.CookieKeyFile); !os.IsNotExist(err) {
+		t.Errorf("key file written while CookieKeys is set: %v", err)
+	}
+}
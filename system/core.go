@@ -2,12 +2,15 @@ package system
 
 import (
 	"log"
+	"net"
 	"net/url"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/ego008/youdb"
 	"github.com/gorilla/securecookie"
+	"github.com/missdeer/kani/lib/oauth"
 	"github.com/missdeer/kani/util"
 	"github.com/qiniu/api.v7/storage"
 	"github.com/weint/config"
@@ -26,56 +29,103 @@ type MainConf struct {
 	OldSiteDomain  string
 	TLSCrtFile     string
 	TLSKeyFile     string
+	CookieKeyFile  string   // cookie 密钥文件，不存在时自动生成
+	CookieKeys     []string // 不为空时代替密钥文件，每项为 "hashKey blockKey"（base64），第一项用于编码
+	CookieKeepOld  int      // 轮换密钥时保留的旧密钥个数
+	TrustedProxies []string // 可信的反向代理（IP 或 CIDR），为空时不看 X-Forwarded-For
 }
 
 type SiteConf struct {
-	GoVersion         string
-	MD5Sums           string
-	Name              string
-	Desc              string
-	AdminEmail        string
-	MainDomain        string // 上传图片后添加网址前缀, eg: http://domian.com 、http://234.21.35.89:8082
-	MainNodeIds       string
-	TimeZone          int
-	HomeShowNum       int
-	PageShowNum       int
-	TagShowNum        int
-	CategoryShowNum   int
-	TitleMaxLen       int
-	ContentMaxLen     int
-	PostInterval      int
-	CommentListNum    int
-	CommentInterval   int
-	Authorized        bool
-	RegReview         bool
-	CloseReg          bool
-	AutoDataBackup    bool
-	AutoGetTag        bool
-	GetTagApi         string
-	QQClientID        int
-	QQClientSecret    string
-	WeiboClientID     int
-	WeiboClientSecret string // eg: "jpg,jpeg,gif,zip,pdf"
-	UploadSuffix      string
-	UploadImgOnly     bool
-	UploadImgResize   bool
-	UploadMaxSize     int
-	UploadMaxSizeByte int64
-	QiniuAccessKey    string
-	QiniuSecretKey    string
-	QiniuDomain       string
-	QiniuBucket       string
-	UpyunDomain       string
-	UpyunBucket       string
-	UpyunUser         string
-	UpyunPw           string
-	SMTPServer        string
-	SMTPPort          int
-	SMTPUser          string
-	SMTPPassword      string
-	SMSURL            string
-	SMSAppKey         string
-	SMSAppSecret      string
+	GoVersion           string
+	MD5Sums             string
+	Name                string
+	Desc                string
+	AdminEmail          string
+	MainDomain          string // 上传图片后添加网址前缀, eg: http://domian.com 、http://234.21.35.89:8082
+	MainNodeIds         string
+	TimeZone            int
+	HomeShowNum         int
+	PageShowNum         int
+	TagShowNum          int
+	CategoryShowNum     int
+	TitleMaxLen         int
+	ContentMaxLen       int
+	PostInterval        int
+	CommentListNum      int
+	CommentInterval     int
+	Authorized          bool
+	RegReview           bool
+	CloseReg            bool
+	AutoDataBackup      bool
+	AutoGetTag          bool
+	GetTagApi           string
+	QQClientID          int
+	QQClientSecret      string
+	WeiboClientID       int
+	WeiboClientSecret   string // eg: "jpg,jpeg,gif,zip,pdf"
+	UploadSuffix        string
+	UploadImgOnly       bool
+	UploadImgResize     bool
+	UploadMaxSize       int
+	UploadMaxSizeByte   int64
+	QiniuAccessKey      string
+	QiniuSecretKey      string
+	QiniuDomain         string
+	QiniuBucket         string
+	UpyunDomain         string
+	UpyunBucket         string
+	UpyunUser           string
+	UpyunPw             string
+	SMTPServer          string
+	SMTPPort            int
+	SMTPUser            string
+	SMTPPassword        string
+	SMSURL              string
+	SMSAppKey           string
+	SMSAppSecret        string
+	LegacyContentFmt    bool   // 新发的内容不解析 Markdown，按旧的正则方式格式化；旧内容总是按旧方式
+	SanitizeTags        string // 允许的标签, eg: "p,br,a,img"，为空时使用默认值
+	SanitizeAttrs       string // 允许的属性, eg: "a:href title,img:src alt,*:class"
+	SanitizeSchemes     string // 允许的链接协议, eg: "http,https,mailto"
+	AuthorEditWindow    int    // 作者可修改、删除自己帖子和评论的时限（秒），0 为不允许
+	AuthorEditReplied   bool   // 有回复后作者是否还能修改、删除
+	TrashKeepDays       int    // 回收站保留天数，超过后彻底删除，0 为不自动清除
+	CommentMaxDepth     int    // 评论嵌套显示的最大层数，0 为不嵌套
+	NoticeMaxNum        int    // 每个用户保留的站内提醒条数
+	EmailDigestHour     int    // 每日汇总邮件的发送时间（按 TimeZone 的小时）
+	EmailVerifyInterval int    // 重发验证邮件的最短间隔（秒）
+	EmailVerifyExpire   int    // 验证链接有效期（小时）
+	SMSCodeExpire       int    // 短信验证码有效期（秒）
+	SMSCodeInterval     int    // 重发短信验证码的最短间隔（秒）
+	SMSCodeAttempts     int    // 验证码允许输错的次数
+	PasswordResetExpire int    // 找回密码链接有效期（分钟）
+	SessionKeepDays     int    // 登录会话超过这么多天不活动后失效
+	AdminRequireTotp    bool   // 管理员必须开启两步验证才能进入后台
+	LoginFailWindow     int    // 统计登录失败次数的时间窗口（秒）
+	LoginFailIPMax      int    // 同一 IP 在时间窗口内失败这么多次后锁定，0 为不锁定
+	LoginFailUserMax    int    // 同一帐号在时间窗口内失败这么多次后锁定，0 为不锁定
+	LoginLockTime       int    // 第一次锁定的时长（秒），之后每次翻倍，最长一天
+	LoginCaptchaAfter   int    // 登录失败这么多次后需要输入验证码
+	CaptchaLogin        bool   // 登录失败 LoginCaptchaAfter 次后需要验证码
+	CaptchaRegister     bool   // 注册需要验证码
+	CaptchaNewPostDays  int    // 注册不满这么多天的用户发帖需要验证码，0 为不需要
+	CaptchaComment      bool   // 评论需要验证码
+
+	OAuthLinks []OAuthLink // 启动时生成
+}
+
+// OAuthLink 配置文件 OAuth 里的登录方式，layout 用来显示登录链接
+type OAuthLink struct {
+	Name  string
+	Title string
+}
+
+type EmbedConf struct {
+	Providers []util.EmbedProvider
+}
+
+type OAuthConf struct {
+	Providers []oauth.Config
 }
 
 type AppConf struct {
@@ -86,8 +136,13 @@ type AppConf struct {
 type Application struct {
 	Cf     *AppConf
 	Db     *youdb.DB
-	Sc     *securecookie.SecureCookie
+	Sc     []securecookie.Codec // 第一个用于编码，其余为旧密钥
 	QnZone *storage.Zone
+	Hub    *util.Hub          // SSE 推送
+	Mailer *util.SmtpSendMail // 未配置 SMTP 时为 nil
+	Sms    util.SmsSender     // 未配置 SMSURL 时只写日志
+	OAuth  map[string]oauth.Provider
+	Proxy  []*net.IPNet // 解析后的 MainConf.TrustedProxies
 }
 
 func LoadConfig(filename string) *config.Engine {
@@ -111,6 +166,17 @@ func (app *Application) Init(c *config.Engine, currentFilePath string) {
 	} else {
 		mcf.Domain = strings.Trim(mcf.Domain, "/")
 	}
+	if len(mcf.CookieKeyFile) == 0 {
+		mcf.CookieKeyFile = "cookie.keys"
+	}
+	if mcf.CookieKeepOld < 1 {
+		mcf.CookieKeepOld = 2
+	}
+	proxy, err := util.ParseTrustedProxies(mcf.TrustedProxies)
+	if err != nil {
+		log.Fatal("TrustedProxies err ", err)
+	}
+	app.Proxy = proxy
 
 	scf := &SiteConf{}
 	c.GetStruct("Site", scf)
@@ -126,6 +192,40 @@ func (app *Application) Init(c *config.Engine, currentFilePath string) {
 		scf.UploadMaxSize = 1
 	}
 	scf.UploadMaxSizeByte = int64(scf.UploadMaxSize) << 20
+	if scf.EmailVerifyExpire < 1 {
+		scf.EmailVerifyExpire = 24
+	}
+	if scf.SessionKeepDays < 1 {
+		scf.SessionKeepDays = 30
+	}
+	if scf.PasswordResetExpire < 1 {
+		scf.PasswordResetExpire = 60
+	}
+	if scf.SMSCodeExpire < 60 {
+		scf.SMSCodeExpire = 300
+	}
+	if scf.SMSCodeAttempts < 1 {
+		scf.SMSCodeAttempts = 5
+	}
+	if scf.LoginFailWindow < 1 {
+		scf.LoginFailWindow = 900
+	}
+	if scf.LoginLockTime < 1 {
+		scf.LoginLockTime = 300
+	}
+	util.LegacyContentFmt = scf.LegacyContentFmt
+	util.SetSanitizePolicy(util.NewSanitizePolicy(scf.SanitizeTags, scf.SanitizeAttrs, scf.SanitizeSchemes))
+
+	// 视频等嵌入
+	ecf := &EmbedConf{}
+	c.GetStruct("Embed", ecf)
+	for _, p := range ecf.Providers {
+		if err := util.RegisterEmbedProvider(p); err != nil {
+			log.Println("embed provider", p.Name, "err", err)
+		}
+	}
+
+	app.initOAuth(c, scf)
 
 	app.Cf = &AppConf{mcf, scf}
 	db, err := youdb.Open(mcf.Youdb)
@@ -137,9 +237,21 @@ func (app *Application) Init(c *config.Engine, currentFilePath string) {
 	// set main node
 	db.Hset("keyValue", []byte("main_category"), []byte(scf.MainNodeIds))
 
-	app.Sc = securecookie.New(securecookie.GenerateRandomKey(64),
-		securecookie.GenerateRandomKey(32))
-	//app.Sc.SetSerializer(securecookie.JSONEncoder{})
+	// cookie 密钥持久化，重启后已登录的用户不会掉线
+	keyPairs, err := loadCookieKeys(mcf)
+	if err != nil {
+		log.Fatal("cookie key err ", err)
+	}
+	app.Sc = securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range app.Sc {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(scf.SessionKeepDays * 86400)
+		}
+	}
+
+	app.Hub = util.NewHub()
+	app.initMailer()
+	app.Sms = util.NewSmsSender(scf.SMSURL, scf.SMSAppKey, scf.SMSAppSecret)
 
 	log.Println("youdb Connect to", mcf.Youdb)
 }
@@ -148,3 +260,30 @@ func (app *Application) Close() {
 	app.Db.Close()
 	log.Println("db cloded")
 }
+
+// initOAuth 第三方登录，QQ、微博沿用 Site 里的设置，其它的在 OAuth.Providers 里配置
+func (app *Application) initOAuth(c *config.Engine, scf *SiteConf) {
+	app.OAuth = map[string]oauth.Provider{}
+	if scf.QQClientID > 0 {
+		app.OAuth["qq"] = oauth.NewQQ(strconv.Itoa(scf.QQClientID), scf.QQClientSecret)
+	}
+	if scf.WeiboClientID > 0 {
+		app.OAuth["weibo"] = oauth.NewWeibo(strconv.Itoa(scf.WeiboClientID), scf.WeiboClientSecret)
+	}
+
+	ocf := &OAuthConf{}
+	c.GetStruct("OAuth", ocf)
+	for _, pcf := range ocf.Providers {
+		p, err := oauth.New(pcf)
+		if err != nil {
+			log.Println("oauth provider", pcf.Name, "err", err)
+			continue
+		}
+		if _, ok := app.OAuth[p.Name()]; ok || p.Name() == "wb" {
+			log.Println("oauth provider", p.Name(), "err duplicate name")
+			continue
+		}
+		app.OAuth[p.Name()] = p
+		scf.OAuthLinks = append(scf.OAuthLinks, OAuthLink{p.Name(), p.Title()})
+	}
+}
@@ -0,0 +1,64 @@
+package system
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"strconv"
+
+	"github.com/missdeer/kani/util"
+)
+
+// initMailer 配置了 SMTPServer 才发送邮件
+func (app *Application) initMailer() {
+	scf := app.Cf.Site
+	if len(scf.SMTPServer) == 0 {
+		return
+	}
+	host := scf.SMTPServer
+	if scf.SMTPPort > 0 {
+		host += ":" + strconv.Itoa(scf.SMTPPort)
+	}
+	app.Mailer = &util.SmtpSendMail{
+		MailHost:     host,
+		MailAuthUser: scf.SMTPUser,
+		MailAuthPass: scf.SMTPPassword,
+	}
+}
+
+// SendMail 用 ViewDir/mail/ 下的模板渲染邮件并异步发送
+func (app *Application) SendMail(to, subject, tplName string, data interface{}, header map[string]string) error {
+	if app.Mailer == nil {
+		return errors.New("smtp not configured")
+	}
+
+	tmpl, err := template.ParseFiles(app.Cf.Main.ViewDir + "/mail/" + tplName)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return err
+	}
+
+	scf := app.Cf.Site
+	from := scf.SMTPUser
+	if !util.IsMail(from) {
+		from = scf.AdminEmail
+	}
+	msg := util.NewHTMLMessage([]string{to}, from, subject, body.String())
+	msg.User = scf.Name
+	msg.Header = header
+	msg.Info = tplName
+	app.Mailer.AsyncSendMail(msg)
+	return nil
+}
+
+// MailData 邮件模板数据
+type MailData struct {
+	SiteCf         *SiteConf
+	Name           string
+	Items          interface{}
+	URL            string // 验证等操作链接
+	UnsubscribeURL string
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// ParseTrustedProxies 解析可信反向代理列表，每项为 IP 或 CIDR
+func ParseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		if strings.Contains(s, "/") {
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, err
+			}
+			nets = append(nets, n)
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.New("invalid proxy address: " + s)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
+
+func ipTrusted(s string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP 取客户端 IP。默认用连接的对端地址；对端是可信代理时，
+// 从右往左查 X-Forwarded-For，返回第一个不是可信代理的地址，左边的值客户端可以随意伪造
+func ClientIP(remoteAddr string, xff []string, trusted []*net.IPNet) string {
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = host
+	}
+	if !ipTrusted(ip, trusted) {
+		return ip
+	}
+
+	var hops []string
+	for _, v := range xff {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); len(hop) > 0 {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			break
+		}
+		ip = hops[i]
+		if !ipTrusted(ip, trusted) {
+			break
+		}
+	}
+	return ip
+}
@@ -0,0 +1,58 @@
+package util
+
+import "testing"
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		remote  string
+		xff     []string
+		trusted bool
+		want    string
+	}{
+		{"no proxy", "1.2.3.4:5678", nil, false, "1.2.3.4"},
+		{"xff ignored without trusted proxies", "1.2.3.4:5678", []string{"9.9.9.9"}, false, "1.2.3.4"},
+		{"xff ignored from untrusted peer", "1.2.3.4:5678", []string{"9.9.9.9"}, true, "1.2.3.4"},
+		{"trusted peer", "127.0.0.1:5678", []string{"1.2.3.4"}, true, "1.2.3.4"},
+		{"spoofed left hop", "127.0.0.1:5678", []string{"9.9.9.9, 1.2.3.4"}, true, "1.2.3.4"},
+		{"proxy chain", "10.0.0.1:80", []string{"9.9.9.9, 1.2.3.4, 10.0.0.2"}, true, "1.2.3.4"},
+		{"multiple headers", "10.0.0.1:80", []string{"9.9.9.9", "1.2.3.4, 10.0.0.2"}, true, "1.2.3.4"},
+		{"all trusted", "10.0.0.1:80", []string{"10.0.0.3, 10.0.0.2"}, true, "10.0.0.3"},
+		{"invalid hop", "127.0.0.1:80", []string{"1.2.3.4, evil"}, true, "127.0.0.1"},
+		{"no xff", "127.0.0.1:80", nil, true, "127.0.0.1"},
+		{"ipv6 peer", "[::1]:80", []string{"2001:db8::1"}, true, "2001:db8::1"},
+		{"no port", "1.2.3.4", nil, false, "1.2.3.4"},
+	}
+	for _, tt := range tests {
+		list := trusted
+		if !tt.trusted {
+			list = nil
+		}
+		if got := ClientIP(tt.remote, tt.xff, list); got != tt.want {
+			t.Errorf("%s: ClientIP(%q, %q) = %q, want %q", tt.name, tt.remote, tt.xff, got, tt.want)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		list []string
+		n    int
+		err  bool
+	}{
+		{nil, 0, false},
+		{[]string{"127.0.0.1", " 10.0.0.0/8 ", "", "fd00::/8"}, 3, false},
+		{[]string{"localhost"}, 0, true},
+		{[]string{"10.0.0.0/33"}, 0, true},
+	}
+	for _, tt := range tests {
+		nets, err := ParseTrustedProxies(tt.list)
+		if (err != nil) != tt.err || len(nets) != tt.n {
+			t.Errorf("ParseTrustedProxies(%q) = %d nets, err %v", tt.list, len(nets), err)
+		}
+	}
+}
@@ -2,27 +2,104 @@ package util
 
 import (
 	"crypto/md5"
+	"crypto/rand"
 	"encoding/hex"
 	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/ego008/youdb"
+	"golang.org/x/net/html"
 )
 
 var (
 	codeRegexp    = regexp.MustCompile("(?s:```(.+?)```)")
+	langRegexp    = regexp.MustCompile(`^[\w+#.-]+\s*$`)
 	imgRegexp     = regexp.MustCompile(`(https?://[\w./:]+/[\w./]+\.(jpg|jpe|jpeg|gif|png))`)
 	gistRegexp    = regexp.MustCompile(`(https?://gist\.github\.com/([a-zA-Z0-9-]+/)?[\d]+)`)
 	mentionRegexp = regexp.MustCompile(`\B@([a-zA-Z0-9\p{Han}]{1,32})\s?`)
 	urlRegexp     = regexp.MustCompile(`([^;"='>])(https?://[^\s<]+[^\s<.)])`)
 	nlineRegexp   = regexp.MustCompile(`\s{2,}`)
-	youku1Regexp  = regexp.MustCompile(`https?://player\.youku\.com/player\.php/sid/([a-zA-Z0-9=]+)/v\.swf`)
-	youku2Regexp  = regexp.MustCompile(`https?://v\.youku\.com/v_show/id_([a-zA-Z0-9=]+)(/|\.html?)?`)
 )
 
-// 文本格式化
-func ContentFmt(db *youdb.DB, input string) string {
+// 内容格式，保存在文章和评论的 Format 里。改用 Markdown 之前的内容没有这个字段，
+// 仍按旧的正则方式格式化，免得旧帖里的下划线、星号和缩进变成格式
+const (
+	ContentFormatLegacy   = ""
+	ContentFormatMarkdown = "md"
+)
+
+// LegacyContentFmt 为 true 时新发的内容也使用旧的正则格式化，不解析 Markdown
+var LegacyContentFmt bool
+
+// ContentFormatNew 新发的文章、评论使用的格式
+func ContentFormatNew() string {
+	if LegacyContentFmt {
+		return ContentFormatLegacy
+	}
+	return ContentFormatMarkdown
+}
+
+// 文本格式化，format 为 ContentFormatLegacy 或 ContentFormatMarkdown，输出都经过 Sanitize 过滤
+func ContentFmt(db *youdb.DB, format, input string) string {
+	embeds := newContentEmbeds(input)
+	if format != ContentFormatMarkdown {
+		input = contentLegacyFmt(db, input, embeds)
+	} else {
+		input = markdownFmt(db, input, embeds)
+	}
+	return embeds.restore(Sanitize(input))
+}
+
+// contentEmbeds 可信的嵌入代码（gist、视频等），先用占位符替换，过滤后再还原。
+// 占位符带每次随机生成的 nonce，用户输入里猜不到；还原时只替换文本节点，
+// 占位符出现在属性值里（比如链接的 title）时不会被换成嵌入代码
+type contentEmbeds struct {
+	nonce string
+	html  map[string]string
+}
+
+func newContentEmbeds(input string) *contentEmbeds {
+	b := make([]byte, 16)
+	for {
+		rand.Read(b)
+		nonce := hex.EncodeToString(b)
+		if !strings.Contains(input, nonce) {
+			return &contentEmbeds{nonce: nonce, html: map[string]string{}}
+		}
+	}
+}
+
+func (e *contentEmbeds) add(code string) string {
+	tag := "[embed-" + e.nonce + "-" + strconv.Itoa(len(e.html)+1) + "]"
+	e.html[tag] = code
+	return tag
+}
+
+func (e *contentEmbeds) restore(input string) string {
+	if len(e.html) == 0 {
+		return input
+	}
+	var buf strings.Builder
+	z := html.NewTokenizer(strings.NewReader(input))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		raw := string(z.Raw())
+		if tt == html.TextToken && strings.Contains(raw, e.nonce) {
+			for k, v := range e.html {
+				raw = strings.Replace(raw, k, v, -1)
+			}
+		}
+		buf.WriteString(raw)
+	}
+	return buf.String()
+}
+
+// 旧的正则文本格式化
+func contentLegacyFmt(db *youdb.DB, input string, embeds *contentEmbeds) string {
 	if strings.Index(input, "```") >= 0 {
 		sepNum := strings.Count(input, "```")
 		if sepNum < 2 {
@@ -31,29 +108,31 @@ func ContentFmt(db *youdb.DB, input string) string {
 		codeMap := map[string]string{}
 		input = codeRegexp.ReplaceAllStringFunc(input, func(m string) string {
 			m = strings.Trim(m, "```")
+			// ```go 开头的语言标识
+			var lang string
+			if n := strings.Index(m, "\n"); n > 0 && langRegexp.MatchString(m[:n]) {
+				lang = strings.TrimSpace(m[:n])
+				m = m[n:]
+			}
 			m = strings.Trim(m, "\n")
 			m = strings.TrimSpace(m)
-			m = strings.Replace(m, "<", "&lt;", -1)
-			m = strings.Replace(m, ">", "&gt;", -1)
 
 			codeTag := "[mspctag_" + strconv.FormatInt(int64(len(codeMap)+1), 10) + "]"
-			codeMap[codeTag] = "<pre><code>" + m + "</code></pre>"
+			codeMap[codeTag] = HighlightCode(lang, m)
 			return codeTag
 		})
 
-		input = ContentRich(db, input)
+		input = contentRich(db, input, embeds)
 		// replace tmp code tag
 		if len(codeMap) > 0 {
 			for k, v := range codeMap {
+				input = strings.Replace(input, "<p>"+k+"</p>", v, -1)
 				input = strings.Replace(input, k, v, -1)
 			}
 		}
-		//
-		input = strings.Replace(input, "<p><pre>", "<pre>", -1)
-		input = strings.Replace(input, "</pre></p>", "</pre>", -1)
 		return input
 	}
-	return ContentRich(db, input)
+	return contentRich(db, input, embeds)
 }
 
 type urlInfo struct {
@@ -61,7 +140,7 @@ type urlInfo struct {
 	Click string
 }
 
-func ContentRich(db *youdb.DB, input string) string {
+func contentRich(db *youdb.DB, input string, embeds *contentEmbeds) string {
 	input = strings.TrimSpace(input)
 	input = " " + input // fix Has url Prefix
 	input = strings.Replace(input, "<", "&lt;", -1)
@@ -69,16 +148,17 @@ func ContentRich(db *youdb.DB, input string) string {
 	input = imgRegexp.ReplaceAllString(input, `<img src="$1" />`)
 
 	// video
-	// youku
-	if strings.Index(input, "player.youku.com") >= 0 {
-		input = youku1Regexp.ReplaceAllString(input, `<embed src="https://player.youku.com/player.php/sid/$1/v.swf" quality="high" width="590" height="492" align="middle" allowScriptAccess="sameDomain" type="application/x-shockwave-flash"></embed>`)
-	}
-	if strings.Index(input, "v.youku.com") >= 0 {
-		input = youku2Regexp.ReplaceAllString(input, `<embed src="https://player.youku.com/player.php/sid/$1/v.swf" quality="high" width="590" height="492" align="middle" allowScriptAccess="sameDomain" type="application/x-shockwave-flash"></embed>`)
-	}
+	input = embedURLRegexp.ReplaceAllStringFunc(input, func(m string) string {
+		if embed := embedHTML(db, m); len(embed) > 0 {
+			return embeds.add(embed)
+		}
+		return m
+	})
 
 	if strings.Index(input, "://gist") >= 0 {
-		input = gistRegexp.ReplaceAllString(input, `<script src="$1.js"></script>`)
+		input = gistRegexp.ReplaceAllStringFunc(input, func(m string) string {
+			return embeds.add(`<script src="` + m + `.js"></script>`)
+		})
 	}
 	if strings.Index(input, "@") >= 0 {
 		input = mentionRegexp.ReplaceAllString(input, ` @<a href="/member/$1">$1</a> `)
@@ -0,0 +1,95 @@
+package util
+
+import (
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// DiffRow 并排对比的一行，Type: equal/change/delete/insert
+type DiffRow struct {
+	Type     string
+	LeftNum  int
+	Left     string
+	RightNum int
+	Right    string
+	HasLeft  bool
+	HasRight bool
+}
+
+func diffSplitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r\n")
+	}
+	return lines
+}
+
+// DiffSideBySide 按行对比 a、b，删除和新增相邻时并排显示
+func DiffSideBySide(a, b string) []DiffRow {
+	dmp := diffmatchpatch.New()
+	ca, cb, lines := dmp.DiffLinesToChars(a, b)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(ca, cb, false), lines)
+
+	var rows []DiffRow
+	var dels, ins []string
+	leftNum, rightNum := 0, 0
+
+	flush := func() {
+		n := len(dels)
+		if len(ins) > n {
+			n = len(ins)
+		}
+		for i := 0; i < n; i++ {
+			row := DiffRow{}
+			if i < len(dels) {
+				leftNum++
+				row.LeftNum, row.Left, row.HasLeft = leftNum, dels[i], true
+			}
+			if i < len(ins) {
+				rightNum++
+				row.RightNum, row.Right, row.HasRight = rightNum, ins[i], true
+			}
+			switch {
+			case row.HasLeft && row.HasRight:
+				row.Type = "change"
+			case row.HasLeft:
+				row.Type = "delete"
+			default:
+				row.Type = "insert"
+			}
+			rows = append(rows, row)
+		}
+		dels, ins = dels[:0], ins[:0]
+	}
+
+	for _, d := range diffs {
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			dels = append(dels, diffSplitLines(d.Text)...)
+		case diffmatchpatch.DiffInsert:
+			ins = append(ins, diffSplitLines(d.Text)...)
+		default:
+			flush()
+			for _, line := range diffSplitLines(d.Text) {
+				leftNum++
+				rightNum++
+				rows = append(rows, DiffRow{
+					Type:     "equal",
+					LeftNum:  leftNum,
+					Left:     line,
+					RightNum: rightNum,
+					Right:    line,
+					HasLeft:  true,
+					HasRight: true,
+				})
+			}
+		}
+	}
+	flush()
+
+	return rows
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffSideBySide(t *testing.T) {
+	// 期望的行，没有的一边行号为 0、内容为空
+	type row struct {
+		Type     string
+		LeftNum  int
+		Left     string
+		RightNum int
+		Right    string
+	}
+	tests := []struct {
+		name string
+		a, b string
+		want []row
+	}{
+		{"same", "a\nb\n", "a\nb\n", []row{
+			{"equal", 1, "a", 1, "a"},
+			{"equal", 2, "b", 2, "b"},
+		}},
+		{"both empty", "", "", nil},
+		{"change", "a\nb\nc", "a\nB\nc", []row{
+			{"equal", 1, "a", 1, "a"},
+			{"change", 2, "b", 2, "B"},
+			{"equal", 3, "c", 3, "c"},
+		}},
+		{"insert", "a\nc\n", "a\nb\nc\n", []row{
+			{"equal", 1, "a", 1, "a"},
+			{"insert", 0, "", 2, "b"},
+			{"equal", 2, "c", 3, "c"},
+		}},
+		{"delete", "a\nb\nc\n", "a\nc\n", []row{
+			{"equal", 1, "a", 1, "a"},
+			{"delete", 2, "b", 0, ""},
+			{"equal", 3, "c", 2, "c"},
+		}},
+		{"more deleted than inserted", "x\ny\nz\n", "w\n", []row{
+			{"change", 1, "x", 1, "w"},
+			{"delete", 2, "y", 0, ""},
+			{"delete", 3, "z", 0, ""},
+		}},
+		{"from empty", "", "a\nb", []row{
+			{"insert", 0, "", 1, "a"},
+			{"insert", 0, "", 2, "b"},
+		}},
+		{"crlf", "a\r\nb\r\n", "a\r\nc\r\n", []row{
+			{"equal", 1, "a", 1, "a"},
+			{"change", 2, "b", 2, "c"},
+		}},
+	}
+	for _, tt := range tests {
+		var got []row
+		for _, r := range DiffSideBySide(tt.a, tt.b) {
+			if r.HasLeft != (r.LeftNum > 0) || r.HasRight != (r.RightNum > 0) {
+				t.Errorf("%s: HasLeft/HasRight mismatch %+v", tt.name, r)
+			}
+			got = append(got, row{r.Type, r.LeftNum, r.Left, r.RightNum, r.Right})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: DiffSideBySide(%q, %q)\n got %v\nwant %v", tt.name, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
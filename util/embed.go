@@ -0,0 +1,174 @@
+package util
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"html"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ego008/youdb"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/weint/httpclient"
+)
+
+// EmbedProvider 视频等嵌入内容提供者，链接匹配 Pattern 时替换为嵌入代码
+type EmbedProvider struct {
+	Name     string
+	Pattern  string // 链接正则，从链接开头匹配
+	Template string // 嵌入代码，$1 ~ $9 为 Pattern 的分组
+	OEmbed   string // oEmbed 接口, eg: "https://www.youtube.com/oembed?format=json&url=%s"，设置后忽略 Template
+}
+
+type embedProvider struct {
+	EmbedProvider
+	re *regexp.Regexp
+}
+
+var (
+	embedURLRegexp   = regexp.MustCompile(`https?://[^\s<>"]+`)
+	embedSrcRegexp   = regexp.MustCompile(`^https://`)
+	embedProviders   []*embedProvider
+	embedPolicy      = newEmbedPolicy()
+	defaultProviders = []EmbedProvider{
+		{
+			Name:     "youku",
+			Pattern:  `https?://v\.youku\.com/v_show/id_([a-zA-Z0-9=]+)`,
+			Template: `<iframe src="https://player.youku.com/embed/$1" width="590" height="332" frameborder="0" allowfullscreen></iframe>`,
+		},
+		{
+			Name:     "youku",
+			Pattern:  `https?://player\.youku\.com/player\.php/sid/([a-zA-Z0-9=]+)/v\.swf`,
+			Template: `<iframe src="https://player.youku.com/embed/$1" width="590" height="332" frameborder="0" allowfullscreen></iframe>`,
+		},
+		{
+			Name:     "bilibili",
+			Pattern:  `https?://(?:www\.|m\.)?bilibili\.com/video/(BV[a-zA-Z0-9]+)`,
+			Template: `<iframe src="https://player.bilibili.com/player.html?bvid=$1&autoplay=0" width="590" height="332" frameborder="0" allowfullscreen></iframe>`,
+		},
+		{
+			Name:     "bilibili",
+			Pattern:  `https?://(?:www\.|m\.)?bilibili\.com/video/av(\d+)`,
+			Template: `<iframe src="https://player.bilibili.com/player.html?aid=$1&autoplay=0" width="590" height="332" frameborder="0" allowfullscreen></iframe>`,
+		},
+		{
+			Name:     "youtube",
+			Pattern:  `https?://(?:www\.|m\.)?youtube\.com/watch\?(?:\S*&)?v=([\w-]{11})`,
+			Template: `<iframe src="https://www.youtube-nocookie.com/embed/$1" width="590" height="332" frameborder="0" allowfullscreen></iframe>`,
+		},
+		{
+			Name:     "youtube",
+			Pattern:  `https?://youtu\.be/([\w-]{11})`,
+			Template: `<iframe src="https://www.youtube-nocookie.com/embed/$1" width="590" height="332" frameborder="0" allowfullscreen></iframe>`,
+		},
+		{
+			Name:     "vimeo",
+			Pattern:  `https?://(?:www\.)?vimeo\.com/(\d+)`,
+			Template: `<iframe src="https://player.vimeo.com/video/$1" width="590" height="332" frameborder="0" allowfullscreen></iframe>`,
+		},
+	}
+)
+
+func init() {
+	for _, p := range defaultProviders {
+		if err := RegisterEmbedProvider(p); err != nil {
+			log.Fatal("embed provider ", p.Name, " err ", err)
+		}
+	}
+}
+
+// RegisterEmbedProvider 注册嵌入提供者，后注册的优先匹配
+func RegisterEmbedProvider(p EmbedProvider) error {
+	if len(p.Pattern) == 0 {
+		return errors.New("pattern is empty")
+	}
+	if len(p.Template) == 0 && len(p.OEmbed) == 0 {
+		return errors.New("template and oembed are empty")
+	}
+	re, err := regexp.Compile(p.Pattern)
+	if err != nil {
+		return err
+	}
+	embedProviders = append([]*embedProvider{{p, re}}, embedProviders...)
+	return nil
+}
+
+func newEmbedPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("iframe", "video", "audio", "source", "div")
+	p.AllowAttrs("src").Matching(embedSrcRegexp).OnElements("iframe", "video", "audio", "source")
+	p.AllowAttrs("width", "height", "title").OnElements("iframe", "video")
+	p.AllowAttrs("frameborder", "allowfullscreen", "allow", "scrolling", "referrerpolicy").OnElements("iframe")
+	p.AllowAttrs("controls", "poster", "preload").OnElements("video", "audio")
+	p.AllowAttrs("type").OnElements("source")
+	p.AllowAttrs("class").Matching(classRegexp).OnElements("div")
+	p.AllowURLSchemes("https")
+	return p
+}
+
+func embedMatch(link string) (*embedProvider, []string) {
+	for _, p := range embedProviders {
+		m := p.re.FindStringSubmatch(link)
+		if m != nil && strings.HasPrefix(link, m[0]) {
+			return p, m
+		}
+	}
+	return nil, nil
+}
+
+func embedCacheKey(link string) []byte {
+	hash := md5.Sum([]byte(link))
+	return []byte(hex.EncodeToString(hash[:]))
+}
+
+// embedHTML 链接对应的嵌入代码，oEmbed 只读缓存，不会在此请求接口
+func embedHTML(db *youdb.DB, link string) string {
+	p, m := embedMatch(link)
+	if p == nil {
+		return ""
+	}
+	if len(p.OEmbed) > 0 {
+		rs := db.Hget("url_md5_embed", embedCacheKey(link))
+		if rs.State == "ok" {
+			return rs.Data[0].String()
+		}
+		return ""
+	}
+	out := p.Template
+	for i := len(m) - 1; i > 0; i-- {
+		out = strings.Replace(out, "$"+strconv.Itoa(i), html.EscapeString(m[i]), -1)
+	}
+	return embedPolicy.Sanitize(out)
+}
+
+// EmbedPrefetch 请求内容中 oEmbed 链接的嵌入代码并缓存，在发帖、回复时调用
+func EmbedPrefetch(db *youdb.DB, content string) {
+	for _, link := range embedURLRegexp.FindAllString(content, -1) {
+		p, _ := embedMatch(link)
+		if p == nil || len(p.OEmbed) == 0 {
+			continue
+		}
+		key := embedCacheKey(link)
+		if db.Hget("url_md5_embed", key).State == "ok" {
+			continue
+		}
+
+		hc := httpclient.Get(strings.Replace(p.OEmbed, "%s", url.QueryEscape(link), 1))
+		hc.SetTimeout(10 * time.Second)
+		t := struct {
+			Html string `json:"html"`
+		}{}
+		if err := hc.ReplyJson(&t); err != nil || hc.Status() != 200 {
+			log.Println("oembed", p.Name, link, err)
+			continue
+		}
+		if out := embedPolicy.Sanitize(t.Html); len(out) > 0 {
+			db.Hset("url_md5_embed", key, []byte(out))
+		}
+	}
+}
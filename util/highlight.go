@@ -0,0 +1,52 @@
+package util
+
+import (
+	"bytes"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+)
+
+// 超过该行数的代码块默认折叠
+const highlightCollapseLines = 30
+
+var highlightFormatter = chromahtml.New(chromahtml.WithClasses(true))
+
+// HighlightCode 代码高亮，输出 class 形式，样式见 static/highligt/chroma.css
+// lang 为空或无法识别时自动检测语言
+func HighlightCode(lang, code string) string {
+	code = strings.Trim(code, "\n")
+
+	var lexer chroma.Lexer
+	if len(lang) > 0 {
+		lexer = lexers.Get(lang)
+	}
+	if lexer == nil {
+		lexer = lexers.Analyse(code)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	var buf bytes.Buffer
+	it, err := lexer.Tokenise(nil, code)
+	if err == nil {
+		err = highlightFormatter.Format(&buf, styles.Fallback, it)
+	}
+	if err != nil {
+		buf.Reset()
+		buf.WriteString("<pre><code>" + html.EscapeString(code) + "</code></pre>")
+	}
+
+	lines := strings.Count(code, "\n") + 1
+	if lines > highlightCollapseLines {
+		return `<details class="code-collapse"><summary>` + strconv.Itoa(lines) + ` 行代码，点击展开</summary>` + buf.String() + `</details>`
+	}
+	return buf.String()
+}
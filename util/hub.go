@@ -0,0 +1,150 @@
+package util
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// 进程内的发布订阅，用于 SSE 推送
+// 按用户推送未读提醒数，按话题（如 article:<aid>）推送新评论
+
+// HubEvent 对应 SSE 的 event 和 data
+type HubEvent struct {
+	Name string
+	Data string
+}
+
+type HubSub struct {
+	C      chan HubEvent
+	uid    uint64
+	topics []string
+	closed bool
+}
+
+type Hub struct {
+	mu     sync.Mutex
+	users  map[uint64]map[*HubSub]struct{}
+	topics map[string]map[*HubSub]struct{}
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		users:  map[uint64]map[*HubSub]struct{}{},
+		topics: map[string]map[*HubSub]struct{}{},
+	}
+}
+
+// Subscribe 订阅用户 uid（0 为游客，不接收用户消息）和若干话题，hub 关闭后 C 会被关闭
+func (hub *Hub) Subscribe(uid uint64, topics []string) *HubSub {
+	sub := &HubSub{
+		C:      make(chan HubEvent, 16),
+		uid:    uid,
+		topics: topics,
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.closed {
+		sub.closed = true
+		close(sub.C)
+		return sub
+	}
+	if uid > 0 {
+		if hub.users[uid] == nil {
+			hub.users[uid] = map[*HubSub]struct{}{}
+		}
+		hub.users[uid][sub] = struct{}{}
+	}
+	for _, topic := range topics {
+		if hub.topics[topic] == nil {
+			hub.topics[topic] = map[*HubSub]struct{}{}
+		}
+		hub.topics[topic][sub] = struct{}{}
+	}
+	return sub
+}
+
+func (hub *Hub) Unsubscribe(sub *HubSub) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	if sub.uid > 0 {
+		delete(hub.users[sub.uid], sub)
+		if len(hub.users[sub.uid]) == 0 {
+			delete(hub.users, sub.uid)
+		}
+	}
+	for _, topic := range sub.topics {
+		delete(hub.topics[topic], sub)
+		if len(hub.topics[topic]) == 0 {
+			delete(hub.topics, topic)
+		}
+	}
+	sub.closed = true
+	close(sub.C)
+}
+
+// send 不阻塞，订阅者来不及读时丢弃
+func (hub *Hub) send(subs map[*HubSub]struct{}, ev HubEvent) {
+	for sub := range subs {
+		select {
+		case sub.C <- ev:
+		default:
+		}
+	}
+}
+
+func hubEvent(name string, data interface{}) HubEvent {
+	jb, _ := json.Marshal(data)
+	return HubEvent{Name: name, Data: string(jb)}
+}
+
+// PublishUser 推送给用户的所有连接
+func (hub *Hub) PublishUser(uid uint64, name string, data interface{}) {
+	ev := hubEvent(name, data)
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if !hub.closed {
+		hub.send(hub.users[uid], ev)
+	}
+}
+
+// PublishTopic 推送给订阅了话题的连接
+func (hub *Hub) PublishTopic(topic, name string, data interface{}) {
+	ev := hubEvent(name, data)
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if !hub.closed {
+		hub.send(hub.topics[topic], ev)
+	}
+}
+
+// Close 关闭所有订阅，正在推送的 SSE 请求随之结束
+func (hub *Hub) Close() {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.closed {
+		return
+	}
+	hub.closed = true
+	closed := map[*HubSub]struct{}{}
+	for _, subs := range hub.users {
+		for sub := range subs {
+			closed[sub] = struct{}{}
+		}
+	}
+	for _, subs := range hub.topics {
+		for sub := range subs {
+			closed[sub] = struct{}{}
+		}
+	}
+	for sub := range closed {
+		sub.closed = true
+		close(sub.C)
+	}
+	hub.users = nil
+	hub.topics = nil
+}
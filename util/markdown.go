@@ -0,0 +1,252 @@
+package util
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"unicode"
+
+	"github.com/ego008/youdb"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	gmutil "github.com/yuin/goldmark/util"
+)
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.Linkify,
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+		extension.Strikethrough,
+		extension.TaskList,
+		mentionExtension{},
+	),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+// CommonMark/GFM 格式化，保留 @提及、链接点击数和 gist 等扩展
+func markdownFmt(db *youdb.DB, input string, embeds *contentEmbeds) string {
+	source := []byte(input)
+	pc := parser.NewContext()
+	doc := markdown.Parser().Parse(text.NewReader(source), parser.WithContext(pc))
+
+	// 嵌入和链接点击数
+	urlNodes := map[string][]ast.Node{}
+	var keys [][]byte
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var url []byte
+		switch n := node.(type) {
+		case *ast.Link:
+			url = n.Destination
+		case *ast.AutoLink:
+			if n.AutoLinkType == ast.AutoLinkURL {
+				url = n.URL(source)
+				if embed := autoLinkEmbed(db, url); len(embed) > 0 {
+					n.SetAttributeString("embed", []byte(embeds.add(embed)))
+					return ast.WalkContinue, nil
+				}
+			}
+		}
+		if bytes.HasPrefix(url, []byte("http")) {
+			hash := md5.Sum(url)
+			urlMd5 := hex.EncodeToString(hash[:])
+			if _, ok := urlNodes[urlMd5]; !ok {
+				keys = append(keys, []byte(urlMd5))
+			}
+			urlNodes[urlMd5] = append(urlNodes[urlMd5], node)
+		}
+		return ast.WalkContinue, nil
+	})
+	if len(keys) > 0 {
+		rs := db.Hmget("url_md5_click", keys)
+		if rs.State == "ok" {
+			for i := 0; i < (len(rs.Data) - 1); i += 2 {
+				click := youdb.B2ds(rs.Data[i+1])
+				for _, node := range urlNodes[rs.Data[i].String()] {
+					node.SetAttributeString("clicks", []byte(click))
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := markdown.Renderer().Render(&buf, source, doc); err != nil {
+		return contentLegacyFmt(db, input, embeds)
+	}
+	return buf.String()
+}
+
+// @提及
+
+var kindMention = ast.NewNodeKind("Mention")
+
+type mentionNode struct {
+	ast.BaseInline
+	Name []byte
+}
+
+func (n *mentionNode) Kind() ast.NodeKind {
+	return kindMention
+}
+
+func (n *mentionNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Name": string(n.Name)}, nil)
+}
+
+type mentionParser struct{}
+
+func (p mentionParser) Trigger() []byte {
+	return []byte{'@'}
+}
+
+func (p mentionParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	if unicode.IsLetter(before) || unicode.IsDigit(before) || before == '_' {
+		return nil
+	}
+	line, _ := block.PeekLine()
+	name := mentionRegexp.FindSubmatch(line)
+	if name == nil || !bytes.HasPrefix(line, name[0]) {
+		return nil
+	}
+	block.Advance(1 + len(name[1]))
+	return &mentionNode{Name: name[1]}
+}
+
+type mentionExtension struct{}
+
+func (e mentionExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(gmutil.Prioritized(mentionParser{}, 500)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(gmutil.Prioritized(&contentRenderer{}, 100)))
+}
+
+type contentRenderer struct{}
+
+func (r *contentRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMention, r.renderMention)
+	reg.Register(ast.KindAutoLink, r.renderAutoLink)
+	reg.Register(ast.KindLink, r.renderLink)
+	reg.Register(ast.KindFencedCodeBlock, r.renderCodeBlock)
+	reg.Register(ast.KindCodeBlock, r.renderCodeBlock)
+}
+
+func (r *contentRenderer) renderCodeBlock(w gmutil.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	var lang string
+	if n, ok := node.(*ast.FencedCodeBlock); ok {
+		lang = string(n.Language(source))
+	}
+	var code bytes.Buffer
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(source))
+	}
+	w.WriteString(HighlightCode(lang, code.String()))
+	w.WriteByte('\n')
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *contentRenderer) renderMention(w gmutil.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		n := node.(*mentionNode)
+		name := gmutil.EscapeHTML(n.Name)
+		w.WriteString(`@<a href="/member/`)
+		w.Write(name)
+		w.WriteString(`">`)
+		w.Write(name)
+		w.WriteString(`</a>`)
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func writeClicks(w gmutil.BufWriter, node ast.Node) {
+	if v, ok := node.AttributeString("clicks"); ok {
+		click := gmutil.EscapeHTML(v.([]byte))
+		w.WriteString(` <span class="badge-notification clicks" title="`)
+		w.Write(click)
+		w.WriteString(` 次点击">`)
+		w.Write(click)
+		w.WriteString(`</span>`)
+	}
+}
+
+func (r *contentRenderer) renderAutoLink(w gmutil.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.AutoLink)
+	url := gmutil.URLEscape(n.URL(source), false)
+	if html.IsDangerousURL(url) {
+		w.Write(gmutil.EscapeHTML(n.Label(source)))
+		return ast.WalkContinue, nil
+	}
+	href := gmutil.EscapeHTML(url)
+
+	if n.AutoLinkType == ast.AutoLinkEmail {
+		w.WriteString(`<a href="mailto:`)
+		w.Write(href)
+		w.WriteString(`">`)
+		w.Write(gmutil.EscapeHTML(n.Label(source)))
+		w.WriteString(`</a>`)
+		return ast.WalkContinue, nil
+	}
+
+	if v, ok := n.AttributeString("embed"); ok {
+		w.Write(v.([]byte))
+		return ast.WalkContinue, nil
+	}
+	if imgRegexp.Match(url) {
+		w.WriteString(`<img src="`)
+		w.Write(href)
+		w.WriteString(`" />`)
+		return ast.WalkContinue, nil
+	}
+
+	w.WriteString(`<a href="`)
+	w.Write(href)
+	w.WriteString(`" target="_blank">`)
+	w.Write(gmutil.EscapeHTML(n.Label(source)))
+	w.WriteString(`</a>`)
+	writeClicks(w, n)
+	return ast.WalkContinue, nil
+}
+
+// autoLinkEmbed 单独的 gist、视频链接转为嵌入代码
+func autoLinkEmbed(db *youdb.DB, url []byte) string {
+	if gistRegexp.Match(url) {
+		return `<script src="` + string(gistRegexp.Find(url)) + `.js"></script>`
+	}
+	return embedHTML(db, string(url))
+}
+
+func (r *contentRenderer) renderLink(w gmutil.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.Link)
+	if entering {
+		w.WriteString(`<a href="`)
+		dest := gmutil.URLEscape(n.Destination, true)
+		if !html.IsDangerousURL(dest) {
+			w.Write(gmutil.EscapeHTML(dest))
+		}
+		w.WriteByte('"')
+		if n.Title != nil {
+			w.WriteString(` title="`)
+			w.Write(gmutil.EscapeHTML(n.Title))
+			w.WriteByte('"')
+		}
+		w.WriteString(` target="_blank">`)
+	} else {
+		w.WriteString(`</a>`)
+		writeClicks(w, n)
+	}
+	return ast.WalkContinue, nil
+}
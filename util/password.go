@@ -0,0 +1,66 @@
+package util
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 密码哈希，User.Password 的格式：
+//   bcrypt$<bcrypt hash>   当前格式
+//   sha256$<name>$<hex>     旧格式，固定了计算时的用户名，改名后仍能登录
+//   <hex>                   旧格式，用当前用户名计算
+// 旧格式在下次登录成功时升级为 bcrypt
+// 传入的 pw 是前端 md5 后的值
+
+const (
+	passwordBcryptPrefix = "bcrypt$"
+	passwordSha256Prefix = "sha256$"
+)
+
+func passwordLegacyHash(name, pw string) string {
+	hash := sha256.New()
+	hash.Write([]byte(fmt.Sprintf("%s%d%s%d", name, len(name), pw, len(pw))))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// PasswordHash 生成当前格式的哈希
+func PasswordHash(pw string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return passwordBcryptPrefix + string(b), nil
+}
+
+// PasswordCheck 校验密码，name 为当前用户名；upgrade 为 true 时应该用 PasswordHash 重新生成
+func PasswordCheck(hashed, name, pw string) (ok, upgrade bool) {
+	if len(hashed) == 0 || len(pw) == 0 {
+		return false, false
+	}
+	if strings.HasPrefix(hashed, passwordBcryptPrefix) {
+		err := bcrypt.CompareHashAndPassword([]byte(hashed[len(passwordBcryptPrefix):]), []byte(pw))
+		return err == nil, false
+	}
+
+	hexHash := hashed
+	if strings.HasPrefix(hashed, passwordSha256Prefix) {
+		i := strings.LastIndex(hashed, "$")
+		name = hashed[len(passwordSha256Prefix):i]
+		hexHash = hashed[i+1:]
+	}
+	ok = subtle.ConstantTimeCompare([]byte(hexHash), []byte(passwordLegacyHash(name, pw))) == 1
+	return ok, ok
+}
+
+// PasswordPinName 改名前调用，把依赖用户名的旧格式固定为原来的用户名
+func PasswordPinName(hashed, name string) string {
+	if len(hashed) == 0 || strings.Contains(hashed, "$") {
+		return hashed
+	}
+	return passwordSha256Prefix + name + "$" + hashed
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordCheck(t *testing.T) {
+	// 前端传来的是 md5("password")
+	const pw = "5f4dcc3b5aa765d61d8327deb882cf99"
+	// sha256("alice" + "5" + pw + "32")
+	const legacy = "ef234ded5ee3d83927de262b591a76f8b048ccca967573b26491b61e11b5c228"
+
+	bcryptHash, err := PasswordHash(pw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(bcryptHash, passwordBcryptPrefix) {
+		t.Fatalf("PasswordHash = %q, want prefix %q", bcryptHash, passwordBcryptPrefix)
+	}
+
+	tests := []struct {
+		name        string
+		hashed      string
+		user        string
+		pw          string
+		ok, upgrade bool
+	}{
+		{"bcrypt", bcryptHash, "alice", pw, true, false},
+		{"bcrypt wrong pw", bcryptHash, "alice", "x", false, false},
+		{"bcrypt renamed", bcryptHash, "bob", pw, true, false},
+		{"legacy", legacy, "alice", pw, true, true},
+		{"legacy wrong pw", legacy, "alice", "x", false, false},
+		{"legacy renamed", legacy, "bob", pw, false, false},
+		{"pinned", "sha256$alice$" + legacy, "bob", pw, true, true},
+		{"pinned wrong pw", "sha256$alice$" + legacy, "alice", "x", false, false},
+		{"pinned wrong name", "sha256$bob$" + legacy, "alice", pw, false, false},
+		{"empty hash", "", "alice", pw, false, false},
+		{"empty pw", legacy, "alice", "", false, false},
+	}
+	for _, tt := range tests {
+		ok, upgrade := PasswordCheck(tt.hashed, tt.user, tt.pw)
+		if ok != tt.ok || upgrade != tt.upgrade {
+			t.Errorf("%s: PasswordCheck = (%v, %v), want (%v, %v)", tt.name, ok, upgrade, tt.ok, tt.upgrade)
+		}
+	}
+}
+
+func TestPasswordPinName(t *testing.T) {
+	tests := []struct {
+		hashed, name, want string
+	}{
+		{"abc", "alice", "sha256$alice$abc"},
+		{"sha256$bob$abc", "alice", "sha256$bob$abc"},
+		{"bcrypt$$2a$10$xyz", "alice", "bcrypt$$2a$10$xyz"},
+		{"", "alice", ""},
+	}
+	for _, tt := range tests {
+		if got := PasswordPinName(tt.hashed, tt.name); got != tt.want {
+			t.Errorf("PasswordPinName(%q, %q) = %q, want %q", tt.hashed, tt.name, got, tt.want)
+		}
+	}
+}
+
+// 旧格式升级流程：改名前固定用户名，登录成功后换成 bcrypt
+func TestPasswordUpgrade(t *testing.T) {
+	const pw = "5f4dcc3b5aa765d61d8327deb882cf99"
+	hashed := PasswordPinName(passwordLegacyHash("alice", pw), "alice")
+	ok, upgrade := PasswordCheck(hashed, "bob", pw)
+	if !ok || !upgrade {
+		t.Fatalf("pinned legacy hash: ok %v upgrade %v", ok, upgrade)
+	}
+	hashed, err := PasswordHash(pw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, upgrade := PasswordCheck(hashed, "bob", pw); !ok || upgrade {
+		t.Errorf("upgraded hash: ok %v upgrade %v", ok, upgrade)
+	}
+}
@@ -0,0 +1,108 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// 默认白名单，对应 Markdown 渲染会输出的标签
+const (
+	defaultSanitizeTags    = "p,br,hr,h1,h2,h3,h4,h5,h6,blockquote,pre,code,em,strong,del,ul,ol,li,table,thead,tbody,tr,th,td,a,img,input,span,div,sup,sub,kbd,details,summary"
+	defaultSanitizeAttrs   = "a:href title target,img:src alt title width height,input:type checked disabled,th:align,td:align,ol:start,*:class"
+	defaultSanitizeSchemes = "http,https,mailto"
+)
+
+var (
+	classRegexp     = regexp.MustCompile(`^[\w\- ]+$`)
+	inputTypeRegexp = regexp.MustCompile(`^checkbox$`)
+	inputTagRegexp  = regexp.MustCompile(`<input\b[^>]*>`)
+	sanitizePolicy  = NewSanitizePolicy("", "", "")
+)
+
+// NewSanitizePolicy 按白名单生成过滤规则，参数为空时使用默认值
+// tags: "p,br,a"  attrs: "a:href title,*:class"  schemes: "http,https"
+func NewSanitizePolicy(tags, attrs, schemes string) *bluemonday.Policy {
+	if len(tags) == 0 {
+		tags = defaultSanitizeTags
+	}
+	if len(attrs) == 0 {
+		attrs = defaultSanitizeAttrs
+	}
+	if len(schemes) == 0 {
+		schemes = defaultSanitizeSchemes
+	}
+
+	p := bluemonday.NewPolicy()
+	p.AllowElements(splitList(tags, ",")...)
+	for _, item := range splitList(attrs, ",") {
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		names := splitList(kv[1], " ")
+		if len(names) == 0 {
+			continue
+		}
+		el := strings.TrimSpace(kv[0])
+		switch el {
+		case "*":
+			for _, name := range names {
+				if name == "class" {
+					p.AllowAttrs(name).Matching(classRegexp).Globally()
+				} else {
+					p.AllowAttrs(name).Globally()
+				}
+			}
+		case "input":
+			// 只用于任务列表的复选框，不能让内容里出现输入框
+			for _, name := range names {
+				if name == "type" {
+					p.AllowAttrs(name).Matching(inputTypeRegexp).OnElements(el)
+				} else {
+					p.AllowAttrs(name).OnElements(el)
+				}
+			}
+		default:
+			p.AllowAttrs(names...).OnElements(el)
+		}
+	}
+
+	p.AllowURLSchemes(splitList(schemes, ",")...)
+	p.AllowRelativeURLs(true)
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnFullyQualifiedLinks(true)
+	return p
+}
+
+// SetSanitizePolicy 设置全局过滤规则
+func SetSanitizePolicy(p *bluemonday.Policy) {
+	sanitizePolicy = p
+}
+
+// Sanitize 过滤 html，只保留白名单中的标签、属性和链接协议。
+// input 去掉 type 后会变成文本框，所以不是复选框的整个去掉
+func Sanitize(input string) string {
+	out := sanitizePolicy.Sanitize(input)
+	if strings.Contains(out, "<input") {
+		out = inputTagRegexp.ReplaceAllStringFunc(out, func(m string) string {
+			if strings.Contains(m, ` type="checkbox"`) {
+				return m
+			}
+			return ""
+		})
+	}
+	return out
+}
+
+func splitList(s, sep string) []string {
+	var items []string
+	for _, v := range strings.Split(s, sep) {
+		v = strings.TrimSpace(v)
+		if len(v) > 0 {
+			items = append(items, v)
+		}
+	}
+	return items
+}
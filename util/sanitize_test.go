@@ -0,0 +1,235 @@
+package util
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ego008/youdb"
+	"golang.org/x/net/html"
+)
+
+func testDB(t *testing.T) *youdb.DB {
+	db, err := youdb.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// 常见的 XSS 写法，经过完整的 ContentFmt 后都不能留下可执行的内容
+var xssCorpus = []struct {
+	name  string
+	input string
+}{
+	{"script", `<script>alert(1)</script>`},
+	{"script upper", `<SCRIPT SRC=//evil.example/x.js></SCRIPT>`},
+	{"script split", `<scr<script>ipt>alert(1)</scr</script>ipt>`},
+	{"img onerror", `<img src=x onerror=alert(1)>`},
+	{"img onerror quoted", `<img src="x" onerror="alert(1)" />`},
+	{"body onload", `<body onload=alert(1)>`},
+	{"a onclick", `<a href="/" onclick="alert(1)">x</a>`},
+	{"div onmouseover", `<div onmouseover="alert(1)">x</div>`},
+	{"details ontoggle", `<details open ontoggle=alert(1)>`},
+	{"a javascript", `<a href="javascript:alert(1)">x</a>`},
+	{"a javascript mixed case", `<a href="JaVaScRiPt:alert(1)">x</a>`},
+	{"a javascript entity", `<a href="&#106;avascript:alert(1)">x</a>`},
+	{"a javascript tab", "<a href=\"java\tscript:alert(1)\">x</a>"},
+	{"a vbscript", `<a href="vbscript:msgbox(1)">x</a>`},
+	{"a data", `<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`},
+	{"img data svg", `<img src="data:image/svg+xml;base64,PHN2ZyBvbmxvYWQ9YWxlcnQoMSk+">`},
+	{"md link javascript", `[x](javascript:alert(1))`},
+	{"md link data", `[x](data:text/html,<script>alert(1)</script>)`},
+	{"md image javascript", `![x](javascript:alert(1))`},
+	{"md autolink javascript", `<javascript:alert(1)>`},
+	{"svg onload", `<svg onload=alert(1)>`},
+	{"svg script", `<svg><script>alert(1)</script></svg>`},
+	{"svg animate", `<svg><animate onbegin=alert(1) attributeName=x dur=1s>`},
+	{"svg use href", `<svg><use href="data:image/svg+xml,<svg id='x' xmlns='http://www.w3.org/2000/svg'><image href='1' onerror='alert(1)'/></svg>#x"/></svg>`},
+	{"math", `<math><mtext><table><mglyph><style><img src=x onerror=alert(1)>`},
+	{"style tag", `<style>body{background:url("javascript:alert(1)")}</style>`},
+	{"style attr", `<p style="background:url(javascript:alert(1))">x</p>`},
+	{"style expression", `<div style="width:expression(alert(1))">x</div>`},
+	{"iframe", `<iframe src="javascript:alert(1)"></iframe>`},
+	{"iframe srcdoc", `<iframe srcdoc="<script>alert(1)</script>"></iframe>`},
+	{"object", `<object data="javascript:alert(1)"></object>`},
+	{"embed", `<embed src="javascript:alert(1)">`},
+	{"form", `<form action="javascript:alert(1)"><button>x</button></form>`},
+	{"meta refresh", `<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`},
+	{"base", `<base href="javascript:alert(1)//">`},
+	{"link", `<link rel="stylesheet" href="javascript:alert(1)">`},
+	{"input text", `<input type="text" name="user">`},
+	{"input password", `<input type="password" name="pw">`},
+	{"input no type", `<input name="user" checked>`},
+	{"input onfocus", `<input type="checkbox" autofocus onfocus=alert(1)>`},
+	{"class injection", `<p class="x&quot; onclick=&quot;alert(1)">x</p>`},
+	{"code fence", "```html\n<script>alert(1)</script>\n```"},
+	{"mention", `@<img src=x onerror=alert(1)>`},
+}
+
+var (
+	xssAllowedTags = map[string]bool{}
+	xssURLAttrs    = map[string]bool{"href": true, "src": true}
+)
+
+func init() {
+	for _, tag := range splitList(defaultSanitizeTags, ",") {
+		xssAllowedTags[tag] = true
+	}
+}
+
+// xssCheck 解析输出的 html，返回不该出现的标签、属性或链接
+func xssCheck(out string) []string {
+	var bad []string
+	doc, err := html.Parse(strings.NewReader(out))
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Parent != nil && n.Parent.Type != html.DocumentNode &&
+			n.Data != "head" && n.Data != "body" {
+			if !xssAllowedTags[n.Data] {
+				bad = append(bad, "<"+n.Data+">")
+			}
+			for _, a := range n.Attr {
+				key := strings.ToLower(a.Key)
+				val := strings.ToLower(strings.TrimSpace(a.Val))
+				switch {
+				case strings.HasPrefix(key, "on"), key == "style", key == "srcdoc", key == "formaction":
+					bad = append(bad, key)
+				case xssURLAttrs[key] && strings.Contains(val, ":") &&
+					!strings.HasPrefix(val, "http://") && !strings.HasPrefix(val, "https://") && !strings.HasPrefix(val, "mailto:"):
+					bad = append(bad, key+"="+val)
+				case n.Data == "input" && key == "type" && val != "checkbox":
+					bad = append(bad, "input type="+val)
+				}
+			}
+			if n.Data == "input" && !xssHasAttr(n, "type", "checkbox") {
+				bad = append(bad, "input without checkbox type")
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return bad
+}
+
+func xssHasAttr(n *html.Node, key, val string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key && a.Val == val {
+			return true
+		}
+	}
+	return false
+}
+
+func TestContentFmtXSS(t *testing.T) {
+	db := testDB(t)
+	for _, format := range []string{ContentFormatMarkdown, ContentFormatLegacy} {
+		for _, tt := range xssCorpus {
+			out := ContentFmt(db, format, tt.input)
+			if bad := xssCheck(out); len(bad) > 0 {
+				t.Errorf("format %q %s: %v in %s", format, tt.name, bad, out)
+			}
+		}
+	}
+}
+
+func TestContentFmtTaskList(t *testing.T) {
+	db := testDB(t)
+	out := ContentFmt(db, ContentFormatMarkdown, "- [x] done\n- [ ] todo")
+	if strings.Count(out, `type="checkbox"`) != 2 || !strings.Contains(out, "checked") || len(xssCheck(out)) > 0 {
+		t.Errorf("task list checkboxes lost: %s", out)
+	}
+}
+
+func TestSanitizeInput(t *testing.T) {
+	tests := []struct {
+		input string
+		keep  bool
+	}{
+		{`<input type="checkbox" checked disabled>`, true},
+		{`<input type="CHECKBOX">`, false},
+		{`<input type="checkbox2">`, false},
+		{`<input type="text">`, false},
+		{`<input type="password">`, false},
+		{`<input checked>`, false},
+		{`<input>`, false},
+	}
+	for _, tt := range tests {
+		out := Sanitize(tt.input)
+		if got := strings.Contains(out, "<input"); got != tt.keep {
+			t.Errorf("Sanitize(%q) = %q, keep input want %v", tt.input, out, tt.keep)
+		}
+	}
+}
+
+// 用户在属性值里写占位符时不能把嵌入代码带进属性，见 contentEmbeds
+func TestContentFmtEmbedPlaceholder(t *testing.T) {
+	db := testDB(t)
+	const video = "https://youtu.be/dQw4w9WgXcQ"
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{"link title", ContentFormatMarkdown, video + "\n\n[x](https://a.example/ \"[embedtag_1]\")"},
+		{"image alt and title", ContentFormatMarkdown, video + "\n\n![[embedtag_1]](https://a.example/a.png \"[embedtag_1]\")"},
+		{"old placeholder in text", ContentFormatMarkdown, video + "\n\n[embedtag_1] [embedtag_2]"},
+		{"guessed placeholder", ContentFormatMarkdown, video + "\n\n[x](https://a.example/ \"[embed-00000000000000000000000000000000-1]\")"},
+		{"legacy", ContentFormatLegacy, video + "\n\n[embedtag_1] https://gist.github.com/1"},
+	}
+	for _, tt := range tests {
+		out := ContentFmt(db, tt.format, tt.input)
+		doc, err := html.Parse(strings.NewReader(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		iframes := 0
+		var walk func(n *html.Node)
+		walk = func(n *html.Node) {
+			if n.Type == html.ElementNode {
+				if n.Data == "iframe" {
+					iframes++
+				}
+				for _, a := range n.Attr {
+					if strings.ContainsAny(a.Val, "<>") {
+						t.Errorf("%s: attribute %s=%q in %s", tt.name, a.Key, a.Val, out)
+					}
+				}
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+		}
+		walk(doc)
+		if iframes != 1 {
+			t.Errorf("%s: %d iframes, want the one video embed: %s", tt.name, iframes, out)
+		}
+	}
+}
+
+func TestContentEmbedsRestore(t *testing.T) {
+	e := newContentEmbeds("")
+	tag := e.add(`<iframe src="https://player.vimeo.com/video/1"></iframe>`)
+	tests := []struct {
+		input, want string
+	}{
+		{`<p>` + tag + `</p>`, `<p><iframe src="https://player.vimeo.com/video/1"></iframe></p>`},
+		{`<a title="` + tag + `">x</a>`, `<a title="` + tag + `">x</a>`},
+		{`<img alt="` + tag + `" src="a.png"/>` + tag, `<img alt="` + tag + `" src="a.png"/><iframe src="https://player.vimeo.com/video/1"></iframe>`},
+		{`<p>[embedtag_1]</p>`, `<p>[embedtag_1]</p>`},
+	}
+	for _, tt := range tests {
+		if got := e.restore(tt.input); got != tt.want {
+			t.Errorf("restore(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+	if other := newContentEmbeds(""); other.nonce == e.nonce {
+		t.Error("nonce reused between calls")
+	}
+}
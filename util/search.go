@@ -0,0 +1,111 @@
+package util
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	searchTermMinLen = 2
+	searchTermMaxLen = 32
+)
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
+
+// SearchTerms 分词，返回 词 -> 出现次数
+// 字母数字按单词切分，中日韩文字按单字和双字切分
+func SearchTerms(input string) map[string]uint64 {
+	terms := map[string]uint64{}
+
+	addWord := func(w []rune) {
+		if len(w) < searchTermMinLen || len(w) > searchTermMaxLen {
+			return
+		}
+		terms[string(w)]++
+	}
+	addCJK := func(w []rune) {
+		for i := range w {
+			terms[string(w[i])]++
+			if i+1 < len(w) {
+				terms[string(w[i:i+2])]++
+			}
+		}
+	}
+
+	var word, cjk []rune
+	for _, r := range strings.ToLower(input) {
+		switch {
+		case isCJK(r):
+			addWord(word)
+			word = word[:0]
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			addCJK(cjk)
+			cjk = cjk[:0]
+			word = append(word, r)
+		default:
+			addWord(word)
+			addCJK(cjk)
+			word = word[:0]
+			cjk = cjk[:0]
+		}
+	}
+	addWord(word)
+	addCJK(cjk)
+
+	return terms
+}
+
+// SearchQueryTerms 查询分词，中日韩文字有双字时只取双字
+func SearchQueryTerms(input string) []string {
+	var items []string
+	seen := map[string]struct{}{}
+
+	add := func(t string) {
+		if _, ok := seen[t]; ok {
+			return
+		}
+		seen[t] = struct{}{}
+		items = append(items, t)
+	}
+
+	for _, field := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+		return !(isCJK(r) || unicode.IsLetter(r) || unicode.IsDigit(r))
+	}) {
+		var word, cjk []rune
+		flush := func() {
+			if len(word) >= searchTermMinLen && len(word) <= searchTermMaxLen {
+				add(string(word))
+			}
+			if len(cjk) == 1 {
+				add(string(cjk))
+			}
+			for i := 0; i+1 < len(cjk); i++ {
+				add(string(cjk[i : i+2]))
+			}
+			word = word[:0]
+			cjk = cjk[:0]
+		}
+		for _, r := range field {
+			if isCJK(r) {
+				if len(word) > 0 {
+					flush()
+				}
+				cjk = append(cjk, r)
+			} else {
+				if len(cjk) > 0 {
+					flush()
+				}
+				word = append(word, r)
+			}
+		}
+		flush()
+	}
+
+	return items
+}
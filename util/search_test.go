@@ -0,0 +1,71 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]uint64
+	}{
+		{"empty", "", map[string]uint64{}},
+		{"words", "hello, world hello", map[string]uint64{"hello": 2, "world": 1}},
+		{"short word dropped", "a go b", map[string]uint64{"go": 1}},
+		{"case folding", "Go GO go Kani", map[string]uint64{"go": 3, "kani": 1}},
+		{"digits", "v2 2024", map[string]uint64{"v2": 1, "2024": 1}},
+		{"cjk single", "中", map[string]uint64{"中": 1}},
+		{"cjk bigrams", "中文搜索", map[string]uint64{
+			"中": 1, "文": 1, "搜": 1, "索": 1,
+			"中文": 1, "文搜": 1, "搜索": 1,
+		}},
+		{"cjk split by punctuation", "中文，搜索", map[string]uint64{
+			"中": 1, "文": 1, "搜": 1, "索": 1,
+			"中文": 1, "搜索": 1,
+		}},
+		{"cjk repeated", "你好你好", map[string]uint64{"你": 2, "好": 2, "你好": 2, "好你": 1}},
+		{"mixed", "用Go写论坛", map[string]uint64{
+			"用": 1, "go": 1, "写": 1, "论": 1, "坛": 1,
+			"写论": 1, "论坛": 1,
+		}},
+		{"mixed case folding", "Kani论坛KANI", map[string]uint64{"kani": 2, "论": 1, "坛": 1, "论坛": 1}},
+		{"kana and hangul", "カナ 한글", map[string]uint64{
+			"カ": 1, "ナ": 1, "カナ": 1,
+			"한": 1, "글": 1, "한글": 1,
+		}},
+	}
+	for _, tt := range tests {
+		if got := SearchTerms(tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: SearchTerms(%q) = %v, want %v", tt.name, tt.input, got, tt.want)
+		}
+	}
+
+	long := ""
+	for i := 0; i < searchTermMaxLen+1; i++ {
+		long += "x"
+	}
+	if got := SearchTerms(long); len(got) != 0 {
+		t.Errorf("SearchTerms(too long) = %v, want empty", got)
+	}
+}
+
+func TestSearchQueryTerms(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"", nil},
+		{"Hello hello", []string{"hello"}},
+		{"中", []string{"中"}},
+		{"中文搜索", []string{"中文", "文搜", "搜索"}},
+		{"用Go写论坛", []string{"用", "go", "写论", "论坛"}},
+		{"a 论坛", []string{"论坛"}},
+	}
+	for _, tt := range tests {
+		if got := SearchQueryTerms(tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SearchQueryTerms(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Sign 用 HMAC-SHA256 给若干字段签名，返回 hex 字符串
+func Sign(key []byte, parts ...string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignCheck 校验 Sign 生成的签名
+func SignCheck(key []byte, sig string, parts ...string) bool {
+	return hmac.Equal([]byte(sig), []byte(Sign(key, parts...)))
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SmsSender 短信发送接口
+type SmsSender interface {
+	Send(telephone, content string) error
+}
+
+// HttpSmsSender 按 URL 模板请求短信网关
+// URL 中可用 {telephone} {content} {appkey} {timestamp} {sign}，
+// sign 为 hex(HMAC-SHA256(AppSecret, appkey+telephone+content+timestamp))
+// URL 以 "POST " 开头时，? 后面的部分作为表单提交
+type HttpSmsSender struct {
+	URL       string
+	AppKey    string
+	AppSecret string
+	Client    *http.Client
+}
+
+func (s *HttpSmsSender) Send(telephone, content string) error {
+	timestamp := strconv.FormatInt(time.Now().UTC().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(s.AppSecret))
+	mac.Write([]byte(s.AppKey + telephone + content + timestamp))
+	sign := hex.EncodeToString(mac.Sum(nil))
+
+	r := strings.NewReplacer(
+		"{telephone}", url.QueryEscape(telephone),
+		"{content}", url.QueryEscape(content),
+		"{appkey}", url.QueryEscape(s.AppKey),
+		"{timestamp}", timestamp,
+		"{sign}", sign,
+	)
+
+	method, target := "GET", s.URL
+	if strings.HasPrefix(target, "POST ") {
+		method, target = "POST", strings.TrimPrefix(target, "POST ")
+	}
+	target = r.Replace(target)
+
+	var body io.Reader
+	if method == "POST" {
+		if i := strings.Index(target, "?"); i >= 0 {
+			body = strings.NewReader(target[i+1:])
+			target = target[:i]
+		}
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return err
+	}
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	rb, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sms gateway status %d: %s", resp.StatusCode, rb)
+	}
+	return nil
+}
+
+// LogSmsSender 只写日志，开发时使用
+type LogSmsSender struct{}
+
+func (s LogSmsSender) Send(telephone, content string) error {
+	log.Printf("sms to %s: %s", telephone, content)
+	return nil
+}
+
+// NewSmsSender 没有配置 SMSURL 时使用 LogSmsSender
+func NewSmsSender(smsURL, appKey, appSecret string) SmsSender {
+	if len(smsURL) == 0 {
+		return LogSmsSender{}
+	}
+	return &HttpSmsSender{
+		URL:       smsURL,
+		AppKey:    appKey,
+		AppSecret: appSecret,
+	}
+}
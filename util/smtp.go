@@ -3,6 +3,7 @@ package util
 import (
 	"fmt"
 	"log"
+	"mime"
 	"net/smtp"
 	"strings"
 )
@@ -16,6 +17,7 @@ type Message struct {
 	Type    string
 	Massive bool
 	Info    string
+	Header  map[string]string // 额外的邮件头, eg: List-Unsubscribe
 }
 
 // Content create mail content
@@ -27,8 +29,13 @@ func (m Message) Content() string {
 	}
 
 	// create mail content
-	content := "From: " + m.User + "<" + m.From +
-		">\r\nSubject: " + m.Subject + "\r\nContent-Type: " + contentType + "\r\n\r\n" + m.Body
+	content := "From: " + mime.QEncoding.Encode("UTF-8", m.User) + "<" + m.From +
+		">\r\nSubject: " + mime.QEncoding.Encode("UTF-8", m.Subject) +
+		"\r\nMIME-Version: 1.0\r\nContent-Type: " + contentType + "\r\n"
+	for k, v := range m.Header {
+		content += k + ": " + v + "\r\n"
+	}
+	content += "\r\n" + m.Body
 	return content
 }
 
@@ -8,7 +8,8 @@ var (
 	nicknameRegexp    = regexp.MustCompile(`^[a-z0-9A-Z\p{Han}]+(_[a-z0-9A-Z\p{Han}]+)*$`)
 	usernameRegexp    = regexp.MustCompile(`^[a-zA-Z][a-z0-9A-Z]*(_[a-z0-9A-Z]+)*$`)
 	regUserNameRegexp = regexp.MustCompile(`[^a-z0-9A-Z\p{Han}]+`)
-	mailRegexp        = regexp.MustCompile(`^[a-zA-Z][a-z0-9A-Z]*(_[a-z0-9A-Z]+)*$`)
+	telephoneRegexp   = regexp.MustCompile(`^\+?[0-9]{6,15}$`)
+	mailRegexp        = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9-]+(\.[a-zA-Z0-9-]+)*\.[a-zA-Z]{2,}$`)
 )
 
 func IsNickname(str string) bool {
@@ -32,6 +33,10 @@ func IsMail(str string) bool {
 	return mailRegexp.MatchString(str)
 }
 
+func IsTelephone(str string) bool {
+	return telephoneRegexp.MatchString(str)
+}
+
 func RemoveCharacter(str string) string {
 	return regUserNameRegexp.ReplaceAllString(str, "")
 }
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试向量，密钥为 ASCII "12345678901234567890"，取 8 位结果的后 6 位
+const totpTestSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+var totpVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTotpCode(t *testing.T) {
+	for _, tt := range totpVectors {
+		step := TotpStep(time.Unix(tt.unix, 0))
+		if got := TotpCode(totpTestSecret, step); got != tt.code {
+			t.Errorf("TotpCode(T=%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+		// 小写、带填充的密钥也能用
+		if got := TotpCode(strings.ToLower(totpTestSecret)+"====", step); got != tt.code {
+			t.Errorf("TotpCode(lower, T=%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+	if got := TotpCode("not base32!", 1); got != "" {
+		t.Errorf("TotpCode(bad secret) = %q, want empty", got)
+	}
+}
+
+func TestTotpCheck(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := TotpStep(now)
+	tests := []struct {
+		name string
+		code string
+		at   time.Time
+		ok   bool
+		step uint64
+	}{
+		{"current", "050471", now, true, step},
+		{"with spaces", "050 471", now, true, step},
+		{"previous step", TotpCode(totpTestSecret, step-1), now, true, step - 1},
+		{"next step", TotpCode(totpTestSecret, step+1), now, true, step + 1},
+		{"two steps old", TotpCode(totpTestSecret, step-2), now, false, 0},
+		{"two steps ahead", TotpCode(totpTestSecret, step+2), now, false, 0},
+		{"wrong", "000000", now, false, 0},
+		{"short", "05047", now, false, 0},
+		{"8 digits", "14050471", now, false, 0},
+		{"empty", "", now, false, 0},
+	}
+	for _, tt := range tests {
+		got, ok := TotpCheck(totpTestSecret, tt.code, tt.at)
+		if ok != tt.ok || got != tt.step {
+			t.Errorf("%s: TotpCheck(%q) = (%d, %v), want (%d, %v)", tt.name, tt.code, got, ok, tt.step, tt.ok)
+		}
+	}
+}
+
+func TestTotpSecretNew(t *testing.T) {
+	secret, err := TotpSecretNew()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 || TotpCode(secret, 1) == "" {
+		t.Errorf("TotpSecretNew = %q", secret)
+	}
+	uri := TotpURI("Kani", "alice", secret)
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "otpauth" || u.Query().Get("secret") != secret || u.Query().Get("digits") != "6" {
+		t.Errorf("TotpURI = %q", uri)
+	}
+}